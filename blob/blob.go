@@ -0,0 +1,39 @@
+// Package blob provides storages for binary objects such as uploaded files.
+package blob
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+// Notable errors.
+var (
+	ErrNotFound   = errors.New("blob: not found")
+	ErrInvalidKey = errors.New("blob: invalid key")
+)
+
+// Store saves and loads blobs by their keys.
+type Store interface {
+	// Put saves contents of the reader under the key,
+	// replacing the blob with the same key.
+	Put(key string, r io.Reader) error
+
+	// Get returns contents of the blob. The caller must close it.
+	Get(key string) (io.ReadCloser, error)
+
+	// Delete removes the blob. Deleting a missing blob is not an error.
+	Delete(key string) error
+}
+
+// NewKey returns a new random key.
+func NewKey() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
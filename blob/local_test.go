@@ -0,0 +1,61 @@
+package blob
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocal(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.Put(key, strings.NewReader("contents"))
+	assert.NoError(t, err)
+
+	r, err := l.Get(key)
+	if assert.NoError(t, err) {
+		b, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "contents", string(b))
+		assert.NoError(t, r.Close())
+	}
+
+	err = l.Delete(key)
+	assert.NoError(t, err)
+
+	_, err = l.Get(key)
+	assert.Equal(t, ErrNotFound, err)
+
+	err = l.Delete(key)
+	assert.NoError(t, err)
+}
+
+func TestLocal_InvalidKey(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "../secret", "a/b", ".hidden"} {
+		t.Run(key, func(t *testing.T) {
+			err := l.Put(key, strings.NewReader("contents"))
+			assert.Equal(t, ErrInvalidKey, err)
+
+			_, err = l.Get(key)
+			assert.Equal(t, ErrInvalidKey, err)
+
+			err = l.Delete(key)
+			assert.Equal(t, ErrInvalidKey, err)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package chat
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Broker delivers payloads between instances of the Hub that serve
+// the same chat. Every published payload must be received
+// by all instances, the publishing one included.
+type Broker interface {
+	// Publish sends the payload to all instances.
+	Publish(payload []byte) error
+
+	// Messages returns channel of payloads published by all instances.
+	Messages() <-chan []byte
+}
+
+// envelope is a unit of communication between instances of the Hub.
+// Exactly one of the payload fields is set, MessageID can also
+// be set along with the Event.
+type envelope struct {
+	// Instance of the Hub that has published the envelope.
+	Node string `json:"node"`
+
+	// ID of the new message, or of the message of the Event if it has one.
+	// Messages are not published themselves, since they can exceed
+	// the size limit of the Broker, so other instances load them
+	// from the storage and render them on their own.
+	MessageID int64 `json:"messageId,omitempty"`
+
+	Event        *Event        `json:"event,omitempty"`
+	Subscription *subscription `json:"subscription,omitempty"`
+	Presence     *presence     `json:"presence,omitempty"`
+	Kick         *kick         `json:"kick,omitempty"`
+}
+
+// newNodeID generates random id for the instance of the Hub.
+func newNodeID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -2,9 +2,11 @@ package chat
 
 import (
 	"encoding/json"
-	"html"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/lazy-void/chatapp/models"
 
@@ -27,14 +29,53 @@ const (
 	// Sends pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (9 * pongWait) / 10
 
-	// Maximum message size allowed from peer.
-	messageMaxSize = 2048
+	// Maximum request size allowed from peer. The connection
+	// is closed if the peer sends a bigger one.
+	requestMaxSize = 8192
+
+	// Maximum length of the message text in characters.
+	messageMaxLength = 1000
+
+	// Maximum length of the message key in bytes.
+	messageKeyMaxLength = 64
+
+	// Maximum number of files attached to the message.
+	attachmentsMaxCount = 10
+
+	// Maximum length of the room name.
+	roomNameMaxLength = 50
+
+	// Maximum length of the search query in characters.
+	searchQueryMaxLength = 256
+
+	// Maximum length of the reason sent when the connection is closed in bytes.
+	closeReasonMaxLength = 123
 )
 
+// kickedCloseCode closes connections of the kicked and banned users,
+// so that clients know not to reconnect at once.
+const kickedCloseCode = 4000
+
 // API actions.
 const (
-	loadMoreAction  = "loadMore"
-	broadcastAction = "broadcast"
+	loadMoreAction   = "loadMore"
+	broadcastAction  = "broadcast"
+	createRoomAction = "createRoom"
+	listRoomsAction  = "listRooms"
+	joinRoomAction   = "joinRoom"
+	leaveRoomAction  = "leaveRoom"
+	directAction     = "direct"
+	editAction       = "edit"
+	deleteAction     = "delete"
+	resumeAction     = "resume"
+	typingAction     = "typing"
+	onlineAction     = "online"
+	readAction       = "read"
+	threadAction     = "thread"
+	searchAction     = "search"
+
+	addReactionAction    = "addReaction"
+	removeReactionAction = "removeReaction"
 )
 
 var upgrader = websocket.Upgrader{
@@ -44,13 +85,60 @@ var upgrader = websocket.Upgrader{
 
 // Request represents a request from the Client.
 type Request struct {
+	// Optional id chosen by the client, it is sent back
+	// in the response to tell which request it belongs to.
+	ID string `json:"id"`
+
 	Action string `json:"action"`
 
-	// if client wants to broadcast a message
+	// if client wants to broadcast a message, load more messages,
+	// join or leave the room, search in it, or tells that the user
+	// is typing in it or has read it
+	Room int64 `json:"room"`
+
+	// if client wants to broadcast or send a direct message
 	Message string `json:"message"`
 
-	// if client wants to load more messages
-	Offset int `json:"offset"`
+	// if client wants to send a direct message
+	To string `json:"to"`
+
+	// if client wants to reply in the thread started by the message
+	// with this id instead of broadcasting to the main timeline
+	Parent int64 `json:"parent"`
+
+	// if client wants to attach uploaded files to the message
+	Attachments []int64 `json:"attachments"`
+
+	// if client wants to broadcast or send a direct message only once:
+	// requests with the key that the user has already sent are
+	// acknowledged, but the message is not sent again
+	Key string `json:"key"`
+
+	// if client wants to load messages sent before
+	// the message with this id, 0 to load the latest
+	Before int64 `json:"before"`
+
+	// if client wants to receive messages sent after
+	// the message with this id, e.g. after reconnecting
+	After int64 `json:"after"`
+
+	// if client wants to create a room
+	Name string `json:"name"`
+
+	// if client wants to edit or delete a message, react to it,
+	// load the thread started by it, or mark messages of the room
+	// up to it as read
+	MessageID int64 `json:"messageId"`
+
+	// if client wants to add or remove a reaction
+	Emoji string `json:"emoji"`
+
+	// if client wants to search for messages, optionally only
+	// in the room, by the author or in the time range
+	Query  string     `json:"query"`
+	Author string     `json:"author"`
+	Since  *time.Time `json:"since"`
+	Until  *time.Time `json:"until"`
 }
 
 // Client represents a client connected to the chat
@@ -61,14 +149,27 @@ type Client struct {
 	// Information about the user
 	user models.User
 
+	// Rooms the client receives messages from. Owned by the hub.
+	rooms map[int64]bool
+
+	// Whether the client receives presence events. Owned by the hub.
+	presence bool
+
 	// The websocket connection.
 	conn *websocket.Conn
 
 	// Buffered channel of outbound messages.
 	sendMessage chan Message
 
-	// Channel of outbound responses to requests
+	// Buffered channel of outbound responses to requests.
 	sendResponse chan Response
+
+	// Buffered channel of outbound events.
+	sendEvent chan Event
+
+	// Closed by the hub when it removes the client. The send channels
+	// are never closed, since readPump writes responses to them.
+	done chan struct{}
 }
 
 func (c *Client) readPump() {
@@ -77,7 +178,7 @@ func (c *Client) readPump() {
 		_ = c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(messageMaxSize)
+	c.conn.SetReadLimit(requestMaxSize)
 	err := c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	if err != nil {
 		log.Err(err).Msg("error while setting read deadline on websocket connection")
@@ -103,29 +204,419 @@ func (c *Client) readPump() {
 		var req Request
 		err = json.Unmarshal(message, &req)
 		if err != nil {
-			log.Err(err).Msg("error unmarshalling client request")
+			c.respond(errorResponse(Request{}, invalidJSONError, "Request is not a valid JSON."))
+			continue
+		}
+
+		c.handleRequest(req)
+	}
+}
+
+func (c *Client) handleRequest(req Request) {
+	switch req.Action {
+	case broadcastAction:
+		if !c.checkText(req) || !c.isMember(req, req.Room) || !c.checkRestriction(req) {
+			return
+		}
+
+		if name, args, ok := parseCommand(req.Message); ok {
+			c.runCommand(req, name, args)
+			return
+		}
+		// the text starting with a slash is sent by doubling it
+		if strings.HasPrefix(req.Message, "//") {
+			req.Message = req.Message[1:]
+		}
+
+		c.send(req, req.Room)
+	case directAction:
+		if !c.checkText(req) || !c.checkRestriction(req) {
+			return
+		}
+
+		room, err := c.hub.rooms.Direct(c.user.Username, req.To)
+		if errors.Is(err, models.ErrInvalidUsername) {
+			c.respond(errorResponse(req, notFoundError, "User doesn't exist."))
+			return
+		} else if err != nil {
+			c.internalError(req, err, "error getting direct room")
+			return
+		}
+
+		c.hub.Subscribe(c.user.Username, room)
+		if req.To != c.user.Username {
+			c.hub.Subscribe(req.To, room)
+		}
+
+		c.send(req, room)
+		c.sendRooms(req)
+	case loadMoreAction:
+		if !c.isMember(req, req.Room) {
+			return
+		}
+
+		messages, err := c.hub.LoadMore(req.Room, req.Before)
+		if err != nil {
+			c.internalError(req, err, "error loading messages from db")
 			return
 		}
 
-		switch req.Action {
-		case broadcastAction:
-			c.hub.broadcast <- Message{
-				Text:     html.EscapeString(req.Message),
-				Username: c.user.Username,
-				Created:  time.Now().UTC(),
+		c.respond(Response{Request: req, Messages: messages})
+	case threadAction:
+		messages, err := c.hub.LoadThread(req.MessageID)
+		if errors.Is(err, models.ErrNoRecord) {
+			c.respond(errorResponse(req, notFoundError, "Thread doesn't exist."))
+			return
+		} else if err != nil {
+			c.internalError(req, err, "error loading thread from db")
+			return
+		}
+
+		if !c.isMember(req, messages[0].Room) {
+			return
+		}
+
+		c.respond(Response{Request: req, Messages: messages})
+	case searchAction:
+		query := strings.TrimSpace(req.Query)
+		if query == "" || utf8.RuneCountInString(query) > searchQueryMaxLength {
+			c.respond(errorResponse(req, invalidRequestError, "Search query must be from 1 to 256 characters long."))
+			return
+		}
+
+		q := models.SearchQuery{Text: query, RoomID: req.Room, Author: req.Author}
+		if req.Since != nil {
+			q.Since = *req.Since
+		}
+		if req.Until != nil {
+			q.Until = *req.Until
+		}
+
+		found, err := c.hub.Search(c.user.Username, q)
+		if err != nil {
+			c.internalError(req, err, "error searching messages in db")
+			return
+		}
+
+		c.respond(Response{Request: req, Results: found})
+	case resumeAction:
+		c.hub.resume <- inbound{client: c, request: req}
+	case typingAction:
+		c.hub.typing <- inbound{client: c, request: req}
+	case onlineAction:
+		c.hub.online <- inbound{client: c, request: req}
+	case readAction:
+		err := c.hub.rooms.MarkRead(req.Room, c.user.Username, req.MessageID)
+		if errors.Is(err, models.ErrNoRecord) {
+			c.respond(errorResponse(req, notFoundError, "Message doesn't exist in the room."))
+			return
+		} else if err != nil {
+			c.internalError(req, err, "error marking messages as read")
+			return
+		}
+
+		c.hub.events <- Event{Type: readEvent, Room: req.Room, Username: c.user.Username, MessageID: req.MessageID}
+	case editAction:
+		if !c.checkText(req) || !c.checkRestriction(req) {
+			return
+		}
+
+		// users mentioned before the edit are not notified again
+		mentioned := make(map[string]bool)
+		if old, err := c.hub.messages.Get(req.MessageID); err == nil {
+			for _, username := range old.Mentions {
+				mentioned[username] = true
 			}
-		case loadMoreAction:
-			messages, err := c.hub.loadMore(req.Offset)
+		}
+
+		msg, err := c.hub.messages.Edit(req.MessageID, c.user.Username, req.Message, time.Now().UTC())
+		if !c.checkChange(req, err) {
+			return
+		}
+
+		// mentions and previews of the old text are replaced by the new ones
+		mentions := c.hub.mentions(c.user.Username, msg.Text, msg.RoomID)
+		if len(mentions) > 0 {
+			err := c.hub.messages.Mention(msg.ID, mentions)
 			if err != nil {
-				log.Err(err).Msg("error loading messages from db")
-				continue
+				log.Err(err).Msg("error while saving mentions")
+			} else {
+				msg.Mentions = mentions
+			}
+		}
+
+		chatMsg := NewMessage(msg)
+		c.hub.events <- Event{Type: editEvent, Message: &chatMsg}
+		for _, username := range msg.Mentions {
+			if !mentioned[username] {
+				c.hub.events <- Event{Type: mentionEvent, Username: username, Message: &chatMsg}
 			}
+		}
+		c.hub.unfurl(msg)
+	case deleteAction:
+		msg, err := c.hub.messages.Delete(req.MessageID, c.user.Username)
+		if errors.Is(err, models.ErrNotAuthor) && c.user.Role.AtLeast(models.RoleModerator) {
+			msg, err = c.removeMessage(req.MessageID)
+		}
+		if !c.checkChange(req, err) {
+			return
+		}
+
+		chatMsg := NewMessage(msg)
+		c.hub.events <- Event{Type: deleteEvent, Message: &chatMsg}
+	case addReactionAction, removeReactionAction:
+		if !validEmoji(req.Emoji) {
+			c.respond(errorResponse(req, invalidRequestError, "Reaction must be an emoji."))
+			return
+		}
+
+		// only members of the room can react to its messages
+		msg, err := c.hub.messages.Get(req.MessageID)
+		if errors.Is(err, models.ErrNoRecord) || err == nil && msg.Deleted {
+			c.respond(errorResponse(req, notFoundError, "Message doesn't exist."))
+			return
+		} else if err != nil {
+			c.internalError(req, err, "error getting message from db")
+			return
+		}
+		if !c.isMember(req, msg.RoomID) {
+			return
+		}
+
+		if req.Action == addReactionAction {
+			msg, err = c.hub.messages.React(req.MessageID, c.user.Username, req.Emoji)
+		} else {
+			msg, err = c.hub.messages.Unreact(req.MessageID, c.user.Username, req.Emoji)
+		}
+		if errors.Is(err, models.ErrNoRecord) {
+			c.respond(errorResponse(req, notFoundError, "Message doesn't exist."))
+			return
+		} else if err != nil {
+			c.internalError(req, err, "error changing reactions")
+			return
+		}
+
+		chatMsg := NewMessage(msg)
+		c.hub.events <- Event{Type: reactionEvent, Message: &chatMsg}
+	case createRoomAction:
+		name := strings.TrimSpace(req.Name)
+		if name == "" || utf8.RuneCountInString(name) > roomNameMaxLength {
+			c.respond(errorResponse(req, invalidRequestError, "Room name must be from 1 to 50 characters long."))
+			return
+		}
+
+		id, err := c.hub.rooms.Insert(name, c.user.Username)
+		if errors.Is(err, models.ErrDuplicateRoomName) {
+			c.respond(errorResponse(req, conflictError, "Room with such name already exists."))
+			return
+		} else if err != nil {
+			c.internalError(req, err, "error creating room")
+			return
+		}
+
+		c.hub.Subscribe(c.user.Username, id)
+		c.sendRooms(req)
+	case listRoomsAction:
+		c.sendRooms(req)
+	case joinRoomAction:
+		err := c.hub.rooms.Join(req.Room, c.user.Username)
+		if errors.Is(err, models.ErrInvalidRoom) {
+			c.respond(errorResponse(req, notFoundError, "Room doesn't exist."))
+			return
+		} else if err != nil {
+			c.internalError(req, err, "error joining room")
+			return
+		}
 
-			c.sendResponse <- Response{Request: req, Messages: messages}
+		c.hub.Subscribe(c.user.Username, req.Room)
+		c.sendRooms(req)
+	case leaveRoomAction:
+		err := c.hub.rooms.Leave(req.Room, c.user.Username)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			c.internalError(req, err, "error leaving room")
+			return
 		}
+
+		c.hub.Unsubscribe(c.user.Username, req.Room)
+		c.sendRooms(req)
+	default:
+		c.respond(errorResponse(req, unknownActionError, "Action is not supported."))
+	}
+}
+
+// send passes the message from the request to the hub
+// for saving and broadcasting to the room.
+func (c *Client) send(req Request, room int64) {
+	c.hub.broadcast <- inbound{
+		client:  c,
+		request: req,
+		message: c.hub.newInbound(c.user.Username, req, room),
+	}
+}
+
+// runCommand runs the command sent in the request and responds
+// with the result unless the command has done it already.
+func (c *Client) runCommand(req Request, name, args string) {
+	cmd, ok := c.hub.commands.Lookup(name)
+	if !ok {
+		c.respond(errorResponse(req, unknownCommandError, "Command doesn't exist, send /help to list commands."))
+		return
+	}
+	if !cmd.allowed(c.user.Role) {
+		c.respond(errorResponse(req, forbiddenError, "You are not allowed to run the command."))
+		return
+	}
+
+	call := &CommandCall{Command: cmd, Args: args, Username: c.user.Username, Room: req.Room, client: c, request: req}
+	err := cmd.Handler(call)
+
+	var cmdErr CommandError
+	switch {
+	case errors.Is(err, ErrUsage):
+		c.respond(errorResponse(req, invalidRequestError, "Usage: "+cmd.Usage))
+	case errors.As(err, &cmdErr):
+		c.respond(errorResponse(req, invalidRequestError, string(cmdErr)))
+	case err != nil:
+		c.internalError(req, err, "error running command")
+	case !call.answered:
+		c.respond(Response{Request: req})
+	}
+}
+
+// checkRestriction checks that the user is allowed to send messages,
+// muted users can neither send new ones nor edit the sent ones.
+// It responds with an error otherwise.
+func (c *Client) checkRestriction(req Request) bool {
+	if err := c.hub.restriction(c.user.Username); err != nil {
+		c.respond(Response{Request: req, Error: err})
+		return false
+	}
+
+	return true
+}
+
+// checkText checks that the text of the message from the request
+// is valid and responds with an error otherwise.
+func (c *Client) checkText(req Request) bool {
+	if err := textError(req); err != nil {
+		c.respond(Response{Request: req, Error: err})
+		return false
+	}
+
+	return true
+}
+
+// textError checks that the message text from the request is not empty
+// unless files are attached to it, and that the text, its key and
+// attachments are not too long, and tells what is wrong otherwise.
+func textError(req Request) *Error {
+	switch {
+	case strings.TrimSpace(req.Message) == "" && len(req.Attachments) == 0:
+		return &Error{Code: invalidRequestError, Message: "Message can't be empty."}
+	case len(req.Attachments) > attachmentsMaxCount:
+		return &Error{Code: invalidRequestError, Message: "At most 10 files can be attached to the message."}
+	case utf8.RuneCountInString(req.Message) > messageMaxLength:
+		return &Error{Code: tooLongError, Message: "Message must be at most 1000 characters long."}
+	case len(req.Key) > messageKeyMaxLength:
+		return &Error{Code: invalidRequestError, Message: "Message key must be at most 64 bytes long."}
+	}
+
+	return nil
+}
+
+// checkChange checks the result of editing or deleting
+// a message and responds with an error if it has failed.
+func (c *Client) checkChange(req Request, err error) bool {
+	switch {
+	case errors.Is(err, models.ErrNoRecord):
+		c.respond(errorResponse(req, notFoundError, "Message doesn't exist."))
+		return false
+	case errors.Is(err, models.ErrNotAuthor):
+		c.respond(errorResponse(req, forbiddenError, "Only the author can change the message."))
+		return false
+	case err != nil:
+		c.internalError(req, err, "error changing message")
+		return false
+	}
+
+	return true
+}
+
+// close tells the peer why the connection is closed and closes it.
+// It is safe to call concurrently with the pumps, the client is
+// unregistered when readPump fails to read from the connection.
+func (c *Client) close(code int, reason string) {
+	for len(reason) > closeReasonMaxLength || !utf8.ValidString(reason) {
+		reason = reason[:len(reason)-1]
+	}
+
+	msg := websocket.FormatCloseMessage(code, reason)
+	_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+	_ = c.conn.Close()
+}
+
+// removeMessage deletes the message of another user on behalf
+// of the moderator. Direct conversations are not moderated.
+func (c *Client) removeMessage(id int64) (models.Message, error) {
+	msg, err := c.hub.messages.Get(id)
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	room, err := c.hub.rooms.Get(msg.RoomID)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if room.Direct {
+		return models.Message{}, models.ErrNotAuthor
+	}
+
+	return c.hub.messages.Remove(id)
+}
+
+// isMember checks that the user is a member of the room
+// and responds to the request with an error if it is not.
+func (c *Client) isMember(req Request, room int64) bool {
+	ok, err := c.hub.rooms.IsMember(room, c.user.Username)
+	if err != nil {
+		c.internalError(req, err, "error checking room membership")
+		return false
+	}
+	if !ok {
+		c.respond(errorResponse(req, forbiddenError, "You are not a member of the room."))
+		return false
+	}
+
+	return true
+}
+
+// respond queues the response to be written to the connection.
+// It is dropped if the hub has removed the client meanwhile.
+func (c *Client) respond(resp Response) {
+	select {
+	case c.sendResponse <- resp:
+	case <-c.done:
 	}
 }
 
+// sendRooms responds to the request with the list of all rooms.
+func (c *Client) sendRooms(req Request) {
+	rooms, err := c.hub.ListRooms(c.user.Username)
+	if err != nil {
+		c.internalError(req, err, "error loading rooms from db")
+		return
+	}
+
+	c.respond(Response{Request: req, Rooms: rooms})
+}
+
+// internalError logs the error and tells the client
+// that the request has failed because of the server.
+func (c *Client) internalError(req Request, err error, msg string) {
+	log.Err(err).Msg(msg)
+	c.respond(errorResponse(req, internalError, "Something went wrong, try again later."))
+}
+
 // writePump pumps messages from the hub to the websocket connection.
 func (c *Client) writePump() {
 	ticker := time.NewTicker(pingPeriod)
@@ -150,12 +641,11 @@ func (c *Client) writePump() {
 
 	for {
 		select {
-		case message, ok := <-c.sendMessage:
-			if !ok {
-				// The hub closed the channel.
-				_ = c.conn.WriteMessage(websocket.CloseMessage, nil)
-			}
-
+		case <-c.done:
+			// The hub removed the client.
+			_ = c.conn.WriteMessage(websocket.CloseMessage, nil)
+			return
+		case message := <-c.sendMessage:
 			// add all queued messages to the update
 			update := Update{Messages: []Message{message}}
 			for i := 0; i < len(c.sendMessage); i++ {
@@ -173,12 +663,7 @@ func (c *Client) writePump() {
 				log.Err(err).Msg("error sending message to websocket")
 				return
 			}
-		case resp, ok := <-c.sendResponse:
-			if !ok {
-				// The hub closed the channel.
-				_ = c.conn.WriteMessage(websocket.CloseMessage, nil)
-			}
-
+		case resp := <-c.sendResponse:
 			jsonResp, err := json.Marshal(resp)
 			if err != nil {
 				log.Err(err).Msg("error marshaling response to json")
@@ -190,6 +675,18 @@ func (c *Client) writePump() {
 				log.Err(err).Msg("error sending message to websocket")
 				return
 			}
+		case event := <-c.sendEvent:
+			jsonEvent, err := json.Marshal(event)
+			if err != nil {
+				log.Err(err).Msg("error marshaling event to json")
+				return
+			}
+
+			err = writeMessage(jsonEvent)
+			if err != nil {
+				log.Err(err).Msg("error sending message to websocket")
+				return
+			}
 		case <-ticker.C:
 			err := c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err != nil {
@@ -207,19 +704,34 @@ func (c *Client) writePump() {
 
 // ServeWS handles websocket requests from the peer.
 func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(ContextUserKey).(models.User)
+	joined, err := hub.rooms.Joined(user.Username)
+	if err != nil {
+		log.Err(err).Msg("error loading joined rooms from db")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	rooms := make(map[int64]bool, len(joined))
+	for _, room := range joined {
+		rooms[room.ID] = true
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Err(err).Msg("error upgrading connection to websocket")
 		return
 	}
 
-	user := r.Context().Value(ContextUserKey).(models.User)
 	c := &Client{
 		hub:          hub,
 		user:         user,
+		rooms:        rooms,
 		conn:         conn,
 		sendMessage:  make(chan Message, 256),
-		sendResponse: make(chan Response),
+		sendResponse: make(chan Response, 16),
+		sendEvent:    make(chan Event, 256),
+		done:         make(chan struct{}),
 	}
 	c.hub.register <- c
 
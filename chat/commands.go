@@ -0,0 +1,346 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/lazy-void/chatapp/markdown"
+	"github.com/lazy-void/chatapp/models"
+)
+
+const (
+	// Maximum length of the command name in bytes.
+	commandNameMaxLength = 32
+
+	// Maximum length of the room topic in characters.
+	topicMaxLength = 250
+
+	// Maximum length of the nickname in characters.
+	nicknameMaxLength = 50
+)
+
+// ErrUsage is returned by command handlers if the arguments
+// are invalid. The caller is shown usage of the command.
+var ErrUsage = errors.New("chat: invalid command arguments")
+
+// CommandError is returned by command handlers to tell the caller
+// why the command has failed. Other errors are logged, and the caller
+// is only told that something went wrong.
+type CommandError string
+
+func (e CommandError) Error() string {
+	return string(e)
+}
+
+// Command is run by sending "/name arguments" to a room
+// instead of a message.
+type Command struct {
+	// Name of the command without the slash. It consists
+	// of lowercase letters, digits, "-" and "_".
+	Name string
+
+	// Usage of the command, e.g. "/topic [text]".
+	// It is "/name" if empty.
+	Usage string
+
+	// Short description shown by /help.
+	Description string
+
+	// Lowest role allowed to run the command,
+	// any user can run it if empty.
+	Role models.Role
+
+	Handler CommandHandler
+}
+
+// allowed reports whether users with the role can run the command.
+func (cmd Command) allowed(role models.Role) bool {
+	return cmd.Role == "" || role.AtLeast(cmd.Role)
+}
+
+// CommandHandler runs the command. Handlers are called in the goroutine
+// reading requests of the connection, so they should not block for long.
+type CommandHandler func(call *CommandCall) error
+
+// CommandCall is a command sent by the user to the room.
+type CommandCall struct {
+	Command Command
+
+	// Text after the command name without surrounding spaces.
+	Args string
+
+	Username string
+	Room     int64
+
+	client  *Client
+	request Request
+
+	// Whether the caller has got a response to the request.
+	answered bool
+}
+
+// Reply sends the text to the connection of the caller only.
+func (call *CommandCall) Reply(text string) {
+	call.answered = true
+	call.client.respond(Response{Request: call.request, Reply: text})
+}
+
+// Broadcast sends the text to the room as a message of the caller,
+// to the thread if the command was sent there. The command is
+// acknowledged as the message, so it should be broadcast only once.
+func (call *CommandCall) Broadcast(text string) {
+	call.answered = true
+
+	req := call.request
+	req.Message = text
+	if !call.client.checkText(req) {
+		return
+	}
+
+	call.client.send(req, call.Room)
+}
+
+// Commands is a registry of the slash commands.
+// It is safe for concurrent use.
+type Commands struct {
+	mu       sync.RWMutex
+	commands map[string]Command
+}
+
+// NewCommands returns the registry of the built-in commands:
+// /me, /nick, /help, /topic and /who, and the moderation commands
+// /mute, /unmute, /kick, /ban and /unban.
+func NewCommands() *Commands {
+	cs := &Commands{commands: make(map[string]Command)}
+	cs.Register(Command{
+		Name:        "me",
+		Usage:       "/me <action>",
+		Description: "Tells the room what you are doing.",
+		Handler:     runMe,
+	})
+	cs.Register(Command{
+		Name:        "nick",
+		Usage:       "/nick [nickname]",
+		Description: "Changes the name shown instead of your username, removes it if empty.",
+		Handler:     runNick,
+	})
+	cs.Register(Command{
+		Name:        "help",
+		Usage:       "/help [command]",
+		Description: "Lists the commands or tells how to use one.",
+		Handler:     runHelp,
+	})
+	cs.Register(Command{
+		Name:        "topic",
+		Usage:       "/topic [text]",
+		Description: "Shows the topic of the room or changes it.",
+		Handler:     runTopic,
+	})
+	cs.Register(Command{
+		Name:        "who",
+		Usage:       "/who",
+		Description: "Lists members of the room who are online.",
+		Handler:     runWho,
+	})
+	registerModeration(cs)
+
+	return cs
+}
+
+// Register adds the command to the registry. It replaces the command
+// with the same name, so built-in commands can be overridden.
+// Register panics if the name is invalid or the handler is nil.
+func (cs *Commands) Register(cmd Command) {
+	if !validCommandName(cmd.Name) {
+		panic(fmt.Sprintf("chat: invalid command name %q", cmd.Name))
+	}
+	if cmd.Handler == nil {
+		panic("chat: nil handler of the command " + cmd.Name)
+	}
+	if cmd.Usage == "" {
+		cmd.Usage = "/" + cmd.Name
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.commands[cmd.Name] = cmd
+}
+
+// Lookup returns the command with the name.
+func (cs *Commands) Lookup(name string) (Command, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	cmd, ok := cs.commands[name]
+	return cmd, ok
+}
+
+// All returns the registered commands sorted by name.
+func (cs *Commands) All() []Command {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	all := make([]Command, 0, len(cs.commands))
+	for _, cmd := range cs.commands {
+		all = append(all, cmd)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+
+	return all
+}
+
+func validCommandName(name string) bool {
+	if name == "" || len(name) > commandNameMaxLength {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseCommand returns the name and the arguments of the command
+// if the text is "/name" optionally followed by a space and arguments.
+// Other texts starting with a slash, like paths, are not commands.
+func parseCommand(text string) (name, args string, ok bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", "", false
+	}
+
+	name = text[1:]
+	if i := strings.IndexAny(name, " \t\n"); i >= 0 {
+		name, args = name[:i], name[i+1:]
+	}
+	if !validCommandName(name) {
+		return "", "", false
+	}
+
+	return name, strings.TrimSpace(args), true
+}
+
+// runMe broadcasts the action in the third person, e.g. "*Ann* waves".
+func runMe(call *CommandCall) error {
+	if call.Args == "" {
+		return ErrUsage
+	}
+
+	user, err := call.client.hub.users.Get(call.Username)
+	if err != nil {
+		return err
+	}
+	name := user.Username
+	if user.Nickname != "" {
+		name = user.Nickname
+	}
+
+	call.Broadcast("*" + markdown.Escape(name) + "* " + call.Args)
+	return nil
+}
+
+// runNick changes the nickname of the caller. Nicknames follow the rules
+// of usernames and can't be usernames of other users.
+func runNick(call *CommandCall) error {
+	nickname := call.Args
+	if utf8.RuneCountInString(nickname) > nicknameMaxLength {
+		return CommandError("Nickname must be at most 50 characters long.")
+	}
+	if html.EscapeString(nickname) != nickname {
+		return CommandError("Characters <, >, &, ' and \" are not allowed in nicknames.")
+	}
+
+	users := call.client.hub.users
+	if nickname != "" && nickname != call.Username {
+		_, err := users.Get(nickname)
+		if err == nil {
+			return CommandError("Nickname is a username of another user.")
+		} else if !errors.Is(err, models.ErrNoRecord) {
+			return err
+		}
+	}
+
+	err := users.SetNickname(call.Username, nickname)
+	if err != nil {
+		return err
+	}
+
+	if nickname == "" {
+		call.Reply("Your nickname is removed.")
+	} else {
+		call.Reply("You are now known as " + nickname + ".")
+	}
+	return nil
+}
+
+// runHelp replies with the usage and the description
+// of the requested command or of all commands.
+func runHelp(call *CommandCall) error {
+	commands := call.client.hub.commands
+	role := call.client.user.Role
+	if call.Args != "" {
+		cmd, ok := commands.Lookup(strings.TrimPrefix(call.Args, "/"))
+		if !ok || !cmd.allowed(role) {
+			return CommandError("Command doesn't exist.")
+		}
+
+		call.Reply(cmd.Usage + " — " + cmd.Description)
+		return nil
+	}
+
+	lines := []string{"Commands:"}
+	for _, cmd := range commands.All() {
+		if cmd.allowed(role) {
+			lines = append(lines, cmd.Usage+" — "+cmd.Description)
+		}
+	}
+
+	call.Reply(strings.Join(lines, "\n"))
+	return nil
+}
+
+// runTopic replies with the topic of the room or changes it
+// and notifies the members of the room.
+func runTopic(call *CommandCall) error {
+	rooms := call.client.hub.rooms
+	if call.Args == "" {
+		room, err := rooms.Get(call.Room)
+		if err != nil {
+			return err
+		}
+
+		if room.Topic == "" {
+			call.Reply("The room has no topic.")
+		} else {
+			call.Reply("Topic: " + room.Topic)
+		}
+		return nil
+	}
+
+	if utf8.RuneCountInString(call.Args) > topicMaxLength {
+		return CommandError("Topic must be at most 250 characters long.")
+	}
+
+	err := rooms.SetTopic(call.Room, call.Args)
+	if err != nil {
+		return err
+	}
+
+	call.client.hub.events <- Event{Type: topicEvent, Room: call.Room, Username: call.Username, Topic: call.Args}
+	return nil
+}
+
+// runWho asks the hub to reply with the members
+// of the room who are online.
+func runWho(call *CommandCall) error {
+	call.answered = true
+	call.client.hub.who <- inbound{client: call.client, request: call.request}
+	return nil
+}
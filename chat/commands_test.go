@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		wantName string
+		wantArgs string
+		wantOK   bool
+	}{
+		{"Command", "/who", "who", "", true},
+		{"Command with arguments", "/me  waves at everyone ", "me", "waves at everyone", true},
+		{"Arguments on the next line", "/topic\nRelease", "topic", "Release", true},
+		{"Not a command", "hello /who", "", "", false},
+		{"Path", "/usr/bin is missing", "", "", false},
+		{"Lone slash", "/ who", "", "", false},
+		{"Uppercase", "/WHO", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, args, ok := parseCommand(tt.text)
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantArgs, args)
+			assert.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
+
+func TestCommands_Register(t *testing.T) {
+	cs := NewCommands()
+	handler := func(call *CommandCall) error { return nil }
+
+	cs.Register(Command{Name: "roll", Handler: handler})
+	cmd, ok := cs.Lookup("roll")
+	if assert.True(t, ok) {
+		assert.Equal(t, "/roll", cmd.Usage)
+	}
+
+	// built-in commands can be overridden
+	cs.Register(Command{Name: "me", Usage: "/me <mood>", Handler: handler})
+	cmd, _ = cs.Lookup("me")
+	assert.Equal(t, "/me <mood>", cmd.Usage)
+
+	var names []string
+	for _, cmd := range cs.All() {
+		names = append(names, cmd.Name)
+	}
+	assert.Equal(t, []string{"ban", "help", "kick", "me", "mute", "nick", "roll", "topic", "unban", "unmute", "who"}, names)
+
+	assert.Panics(t, func() { cs.Register(Command{Name: "Roll", Handler: handler}) })
+	assert.Panics(t, func() { cs.Register(Command{Name: "dice"}) })
+}
@@ -0,0 +1,68 @@
+package chat
+
+import "net/http"
+
+// Error codes sent to the Client when its request has failed.
+const (
+	// Request is not a valid JSON.
+	invalidJSONError = "invalid_json"
+
+	// Action of the request is not supported.
+	unknownActionError = "unknown_action"
+
+	// Message is a slash command that is not registered.
+	unknownCommandError = "unknown_command"
+
+	// Request has invalid or missing fields.
+	invalidRequestError = "invalid_request"
+
+	// Message text is longer than messageMaxLength.
+	tooLongError = "message_too_long"
+
+	// Requested user, room or message doesn't exist.
+	notFoundError = "not_found"
+
+	// User is not allowed to do the requested action.
+	forbiddenError = "forbidden"
+
+	// Request conflicts with the existing data, e.g. a room name is taken.
+	conflictError = "conflict"
+
+	// Request has failed because of the server,
+	// it is safe to try again later.
+	internalError = "internal"
+)
+
+// Error describes why the request of the Client has failed.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// StatusCode returns the HTTP status code matching the error
+// for the requests made via the HTTP API.
+func (e *Error) StatusCode() int {
+	switch e.Code {
+	case invalidJSONError, invalidRequestError, unknownActionError, unknownCommandError:
+		return http.StatusBadRequest
+	case tooLongError:
+		return http.StatusUnprocessableEntity
+	case notFoundError:
+		return http.StatusNotFound
+	case forbiddenError:
+		return http.StatusForbidden
+	case conflictError:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorResponse creates the response to the failed request.
+func errorResponse(req Request, code, message string) Response {
+	return Response{Request: req, Error: &Error{Code: code, Message: message}}
+}
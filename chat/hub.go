@@ -2,9 +2,15 @@
 package chat
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/lazy-void/chatapp/markdown"
 	"github.com/lazy-void/chatapp/models"
 
 	"github.com/rs/zerolog/log"
@@ -13,16 +19,187 @@ import (
 // Message represents a message in the chat. Client and Hub
 // use them during communication.
 type Message struct {
-	Text     string    `json:"text"`
-	Username string    `json:"username"`
-	Created  time.Time `json:"created"`
+	ID       int64      `json:"id"`
+	Room     int64      `json:"room"`
+	Text     string     `json:"text"`
+	Username string     `json:"username"`
+	Created  time.Time  `json:"created"`
+	Key      string     `json:"key,omitempty"`
+	Edited   *time.Time `json:"edited,omitempty"`
+	Deleted  bool       `json:"deleted,omitempty"`
+	Mentions []string   `json:"mentions,omitempty"`
+
+	// Name shown instead of the username if the author has chosen one.
+	Nickname string `json:"nickname,omitempty"`
+
+	// Whether the message is sent by a bot via the API.
+	Bot bool `json:"bot,omitempty"`
+
+	// Text is the Markdown source as it was written,
+	// HTML is its safe rendering to be shown to users.
+	HTML string `json:"html"`
+
+	// ID of the message that started the thread if the message is a reply.
+	Parent int64 `json:"parent,omitempty"`
+
+	// Number of replies if the message has started a thread.
+	Replies int `json:"replies,omitempty"`
+
+	Reactions   []Reaction   `json:"reactions,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Previews    []Preview    `json:"previews,omitempty"`
+}
+
+// Reaction represents reactions to the message with the same emoji.
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// Attachment represents a file attached to the message.
+type Attachment struct {
+	ID          int64  `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+
+	// Dimensions of images.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+
+	// Where the file can be downloaded from.
+	URL string `json:"url"`
+
+	// Downscaled copy of the image to be shown inline,
+	// nil if the file has no thumbnail.
+	Thumbnail *Thumbnail `json:"thumbnail,omitempty"`
+}
+
+// Preview describes the page linked from the message.
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+}
+
+// Thumbnail represents a downscaled copy of an attached image.
+type Thumbnail struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// NewAttachment converts attachment from the storage to the chat attachment.
+func NewAttachment(a models.Attachment) Attachment {
+	attachment := Attachment{
+		ID:          a.ID,
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		Width:       a.Width,
+		Height:      a.Height,
+		URL:         fmt.Sprintf(AttachmentURL, a.ID),
+	}
+	if a.ThumbnailKey != "" {
+		attachment.Thumbnail = &Thumbnail{
+			URL:    fmt.Sprintf(ThumbnailURL, a.ID),
+			Width:  a.ThumbnailWidth,
+			Height: a.ThumbnailHeight,
+		}
+	}
+
+	return attachment
+}
+
+// NewMessage converts message from the storage to the chat message
+// and renders its Markdown. Text of deleted messages is not revealed.
+func NewMessage(m models.Message) Message {
+	msg := Message{
+		ID:       m.ID,
+		Room:     m.RoomID,
+		Text:     m.Text,
+		HTML:     markdown.Render(m.Text),
+		Username: m.Username,
+		Nickname: m.Nickname,
+		Bot:      m.Bot,
+		Created:  m.Created,
+		Key:      m.Key,
+		Mentions: m.Mentions,
+		Parent:   m.ParentID,
+		Replies:  m.Replies,
+	}
+	for _, r := range m.Reactions {
+		msg.Reactions = append(msg.Reactions, Reaction{Emoji: r.Emoji, Count: r.Count, Users: r.Usernames})
+	}
+	for _, a := range m.Attachments {
+		msg.Attachments = append(msg.Attachments, NewAttachment(a))
+	}
+	for _, p := range m.Previews {
+		msg.Previews = append(msg.Previews, Preview{URL: p.URL, Title: p.Title, Description: p.Description, Image: p.Image})
+	}
+	if !m.Edited.IsZero() {
+		edited := m.Edited
+		msg.Edited = &edited
+	}
+	if m.Deleted {
+		msg.Text = ""
+		msg.HTML = ""
+		msg.Deleted = true
+		msg.Reactions = nil
+		msg.Attachments = nil
+		msg.Previews = nil
+	}
+
+	return msg
+}
+
+// Room represents a chat room as it is seen by the Client.
+// Direct rooms are named after the other participant.
+type Room struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Direct bool   `json:"direct"`
+	Joined bool   `json:"joined"`
+	Topic  string `json:"topic,omitempty"`
 }
 
 // Response represents a response that Hub
-// sends on the Request of the Client.
+// sends on the Request of the Client. Error is set
+// if the request has failed.
 type Response struct {
 	Request  Request   `json:"request"`
 	Messages []Message `json:"messages"`
+	Rooms    []Room    `json:"rooms,omitempty"`
+	Users    []string  `json:"users,omitempty"`
+	Results  []Found   `json:"results,omitempty"`
+	Ack      *Ack      `json:"ack,omitempty"`
+	Error    *Error    `json:"error,omitempty"`
+
+	// Messages replayed on resume that were changed while
+	// the client was disconnected, to be updated in place.
+	Changed []Message `json:"changed,omitempty"`
+
+	// Text of the reply to the command, shown only to the caller.
+	Reply string `json:"reply,omitempty"`
+}
+
+// Found is a message found by the search.
+type Found struct {
+	Message Message `json:"message"`
+
+	// HTML fragment of the text with the found words in <mark> tags.
+	Headline string `json:"headline"`
+
+	Rank float64 `json:"rank"`
+}
+
+// Ack confirms that the message sent by the Client is stored.
+type Ack struct {
+	ID      int64     `json:"id"`
+	Key     string    `json:"key,omitempty"`
+	Created time.Time `json:"created"`
 }
 
 // Update contains all new messages for the Client.
@@ -30,20 +207,195 @@ type Update struct {
 	Messages []Message `json:"messages"`
 }
 
+// AttachmentURL is the format of the URL that attached files
+// are downloaded from. It takes the attachment id.
+const AttachmentURL = "/attachments/%d"
+
+// ThumbnailURL is the format of the URL that thumbnails
+// of attached images are downloaded from.
+const ThumbnailURL = "/attachments/%d/thumbnail"
+
+// Number of messages loaded from the history at once.
+const historyPageSize = 100
+
+// Number of missed messages replayed at once when the client resumes.
+const resumePageSize = 500
+
+// Number of the latest replies loaded with the thread.
+const threadPageSize = 100
+
+// Number of the best matching messages returned by the search.
+const searchPageSize = 50
+
+// Event types.
+const (
+	editEvent     = "edit"
+	deleteEvent   = "delete"
+	typingEvent   = "typing"
+	presenceEvent = "presence"
+	readEvent     = "read"
+	mentionEvent  = "mention"
+	replyEvent    = "reply"
+	reactionEvent = "reaction"
+	previewEvent  = "preview"
+	topicEvent    = "topic"
+)
+
+// Previews are loaded for this many links of the message at most.
+const previewsMaxCount = 3
+
+// Time allowed to load previews of all links of the message.
+const unfurlTimeout = 15 * time.Second
+
+// Number of messages whose links can be unfurled at once.
+// Links of other messages are not unfurled meanwhile.
+const unfurlMaxConcurrency = 8
+
+// Time after which the user is no longer considered typing
+// unless the client repeats the typing request.
+const typingTimeout = 4 * time.Second
+
+// Event notifies the Client about changes in the chat
+// other than new messages.
+type Event struct {
+	Type string `json:"event"`
+
+	// if a message was edited, deleted or reacted to, got previews
+	// of its links, mentions the user or replies in a thread
+	Message *Message `json:"message,omitempty"`
+
+	// if the user has started or stopped typing in the room,
+	// has read its messages or has changed its topic
+	Room     int64  `json:"room,omitempty"`
+	Username string `json:"username,omitempty"`
+	Typing   bool   `json:"typing,omitempty"`
+	Topic    string `json:"topic,omitempty"`
+
+	// if the user has come online or gone offline
+	Online bool `json:"online,omitempty"`
+
+	// if the user has read messages of the room up to this one,
+	// or the thread started by this message got a reply
+	MessageID int64 `json:"messageId,omitempty"`
+
+	// number of replies in the thread after the reply
+	Replies int `json:"replies,omitempty"`
+}
+
+// room returns id of the room the event has happened in.
+func (e Event) room() int64 {
+	if e.Message != nil {
+		return e.Message.Room
+	}
+	return e.Room
+}
+
+// typist identifies the user typing in the room.
+type typist struct {
+	room     int64
+	username string
+}
+
 // MessageInterface provides methods for inserting and getting
 // messages from the storage.
 type MessageInterface interface {
-	Insert(text string, username string, created time.Time) (int, error)
-	Latest(n, offset int) ([]models.Message, error)
+	Insert(roomID, parentID int64, text, username, key string, attachments []int64, created time.Time) (models.Message, error)
+	Get(id int64) (models.Message, error)
+	Before(roomID, id int64, n int) ([]models.Message, error)
+	Thread(id int64, n int) ([]models.Message, error)
+	After(username string, id int64, n int) ([]models.Message, error)
+	Changed(username string, id int64, n int) ([]models.Message, error)
+	Edit(id int64, username, text string, edited time.Time) (models.Message, error)
+	Delete(id int64, username string) (models.Message, error)
+	Remove(id int64) (models.Message, error)
+	React(id int64, username, emoji string) (models.Message, error)
+	Unreact(id int64, username, emoji string) (models.Message, error)
+	Mention(id int64, usernames []string) error
+	AddPreviews(id int64, urls []string) (models.Message, error)
+	Search(username string, q models.SearchQuery, n int) ([]models.SearchResult, error)
+}
+
+// Unfurler loads previews of the pages linked from messages.
+type Unfurler interface {
+	Unfurl(ctx context.Context, url string) (models.Preview, error)
+}
+
+// RoomInterface provides methods for managing rooms
+// and their members in the storage.
+type RoomInterface interface {
+	Insert(name, creator string) (int64, error)
+	Get(id int64) (models.Room, error)
+	All() ([]models.Room, error)
+	Joined(username string) ([]models.Room, error)
+	Direct(username, peer string) (int64, error)
+	Join(roomID int64, username string) error
+	Leave(roomID int64, username string) error
+	IsMember(roomID int64, username string) (bool, error)
+	Members(roomID int64, usernames []string) ([]string, error)
+	MarkRead(roomID int64, username string, messageID int64) error
+	SetTopic(id int64, topic string) error
+}
+
+// UserInterface provides methods for getting and changing users in the storage.
+type UserInterface interface {
+	Get(username string) (models.User, error)
+	SetNickname(username, nickname string) error
+	Sanction(s models.Sanction) (int64, error)
+	ActiveSanction(username string, kind models.SanctionKind) (models.Sanction, error)
+	LiftSanction(username string, kind models.SanctionKind) error
+}
+
+// inbound is a message sent by the client to the hub
+// along with the request it was sent in.
+type inbound struct {
+	client  *Client
+	request Request
+	message Message
+
+	// Receives the result of broadcasting if the message
+	// is posted with Hub.Post rather than by the client.
+	result chan posted
+}
+
+// posted is the result of broadcasting the message,
+// err is set if the message wasn't stored.
+type posted struct {
+	message Message
+	err     *Error
+}
+
+// subscription describes a change of the rooms that
+// connections of the user receive messages from.
+type subscription struct {
+	Username string `json:"username"`
+	Room     int64  `json:"room"`
+	Join     bool   `json:"join"`
+}
+
+// kick closes all connections of the user.
+type kick struct {
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
 }
 
 // Hub maintains the set of active clients and broadcasts messages to them.
+// Several instances of the Hub can serve the same chat if they share
+// the storage and the Broker.
 type Hub struct {
+	// Unique id of the instance.
+	node string
+
 	//  Registered clients.
 	clients map[*Client]bool
 
+	// Registered clients grouped by the rooms they have joined.
+	members map[int64]map[*Client]bool
+
 	// Inbound messages from the clients.
-	broadcast chan Message
+	broadcast chan inbound
+
+	// Events to send to the clients.
+	events chan Event
 
 	// Register requests from the clients.
 	register chan *Client
@@ -51,58 +403,681 @@ type Hub struct {
 	// Unregister requests from the clients.
 	unregister chan *Client
 
-	// Latest and insert chat messages from/in the storage.
+	// Requests to join or leave rooms.
+	subscribe chan subscription
+
+	// Requests to replay messages missed by the clients.
+	resume chan inbound
+
+	// Typing notifications from the clients.
+	typing chan inbound
+
+	// Requests for the list of online users.
+	online chan inbound
+
+	// Requests for the online members of the room made by /who.
+	who chan inbound
+
+	// Requests to close connections of the users.
+	kicks chan kick
+
+	// Number of local connections of every online user.
+	connections map[string]int
+
+	// Users online on other instances and when the last
+	// announcement of every instance expires.
+	remoteOnline map[string]map[string]time.Time
+
+	// Users typing on any instance and when they stop being typing.
+	typists map[typist]time.Time
+
+	// Time after which typing notifications expire.
+	typingTimeout time.Duration
+
+	// Get and insert chat messages from/in the storage.
 	messages MessageInterface
+
+	// Rooms and their members in the storage.
+	rooms RoomInterface
+
+	// Users in the storage.
+	users UserInterface
+
+	// Delivers messages and subscriptions to other instances.
+	broker Broker
+
+	// Loads previews of the links, nil if previews are disabled.
+	unfurler Unfurler
+
+	// Holds a token for every message being unfurled.
+	unfurling chan struct{}
+
+	// Slash commands that can be sent instead of messages.
+	commands *Commands
+}
+
+// HubOptions are the optional dependencies of the Hub.
+// Zero value disables all of them.
+type HubOptions struct {
+	// Broker connects the Hub with other instances serving
+	// the same chat. It is not used if nil.
+	Broker Broker
+
+	// Unfurler loads previews of the links in messages.
+	// Previews are disabled if nil.
+	Unfurler Unfurler
 }
 
 // NewHub initializes new instance of the Hub.
-func NewHub(messages MessageInterface) *Hub {
+func NewHub(messages MessageInterface, rooms RoomInterface, users UserInterface, opts HubOptions) *Hub {
 	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan Message),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		messages:   messages,
+		node:          newNodeID(),
+		clients:       make(map[*Client]bool),
+		members:       make(map[int64]map[*Client]bool),
+		broadcast:     make(chan inbound),
+		events:        make(chan Event),
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		subscribe:     make(chan subscription),
+		resume:        make(chan inbound),
+		typing:        make(chan inbound),
+		online:        make(chan inbound),
+		who:           make(chan inbound),
+		kicks:         make(chan kick),
+		connections:   make(map[string]int),
+		remoteOnline:  make(map[string]map[string]time.Time),
+		typists:       make(map[typist]time.Time),
+		typingTimeout: typingTimeout,
+		messages:      messages,
+		rooms:         rooms,
+		users:         users,
+		broker:        opts.Broker,
+		unfurler:      opts.Unfurler,
+		unfurling:     make(chan struct{}, unfurlMaxConcurrency),
+		commands:      NewCommands(),
 	}
 }
 
+// Commands returns the registry of the slash commands
+// to register custom commands in.
+func (h *Hub) Commands() *Commands {
+	return h.commands
+}
+
 // Run starts chat Hub.
 func (h *Hub) Run() {
+	var remote <-chan []byte
+	if h.broker != nil {
+		remote = h.broker.Messages()
+	}
+
+	sweep := time.NewTicker(h.typingTimeout / 4)
+	defer sweep.Stop()
+	announce := time.NewTicker(presenceInterval)
+	defer announce.Stop()
+
+	// learn who is online on the other instances
+	h.publish(envelope{Presence: &presence{Hello: true}})
+
 	for {
 		select {
 		case client := <-h.register:
 			h.clients[client] = true
+			for room := range client.rooms {
+				h.addMember(room, client)
+			}
+			h.connect(client.user.Username)
 		case client := <-h.unregister:
 			if h.clients[client] {
-				close(client.sendMessage)
-				close(client.sendResponse)
-				delete(h.clients, client)
+				h.removeClient(client)
+			}
+		case s := <-h.subscribe:
+			h.applySubscription(s)
+			h.publish(envelope{Subscription: &s})
+		case in := <-h.resume:
+			h.replay(in.client, in.request)
+		case in := <-h.typing:
+			if !in.client.rooms[in.request.Room] {
+				h.respond(in.client, errorResponse(in.request, forbiddenError, "You are not a member of the room."))
+				continue
+			}
+
+			e := Event{Type: typingEvent, Room: in.request.Room, Username: in.client.user.Username, Typing: true}
+			h.startTyping(e)
+			h.publishEvent(e)
+		case now := <-sweep.C:
+			h.sweepTypists(now)
+		case now := <-announce.C:
+			h.announce()
+			h.sweepPresence(now)
+		case in := <-h.online:
+			// the client wants to keep the list up to date from now on
+			in.client.presence = true
+			h.respond(in.client, Response{Request: in.request, Users: h.onlineUsers()})
+		case in := <-h.who:
+			h.respondWho(in.client, in.request)
+		case k := <-h.kicks:
+			h.applyKick(k)
+			h.publish(envelope{Kick: &k})
+		case in := <-h.broadcast:
+			message, err := h.send(in)
+			if in.result != nil {
+				in.result <- posted{message: message, err: err}
+			}
+		case e := <-h.events:
+			h.deliverEvent(e)
+			h.publishEvent(e)
+		case payload := <-remote:
+			var e envelope
+			err := json.Unmarshal(payload, &e)
+			if err != nil {
+				log.Err(err).Msg("error unmarshalling envelope from broker")
+				continue
 			}
-		case message := <-h.broadcast:
-			_, err := h.messages.Insert(message.Text, message.Username, message.Created)
-			if errors.Is(err, models.ErrInvalidUsername) {
-				log.Err(err).Msg("message has invalid username attached to it")
-			} else if err != nil {
-				log.Err(err).Msg("error while saving message")
+			if e.Node == h.node {
+				// already handled when was published
 				continue
 			}
 
-			for client := range h.clients {
-				select {
-				case client.sendMessage <- message:
-				default:
-					close(client.sendMessage)
-					close(client.sendResponse)
-					delete(h.clients, client)
+			if e.Event != nil && e.MessageID != 0 {
+				message, ok := h.loadPublished(e.MessageID)
+				if !ok {
+					continue
 				}
+				e.Event.Message = &message
+			}
+
+			switch {
+			case e.Event == nil && e.MessageID != 0:
+				if message, ok := h.loadPublished(e.MessageID); ok {
+					h.deliver(message)
+				}
+			case e.Event != nil && e.Event.Type == typingEvent:
+				h.startTyping(*e.Event)
+			case e.Event != nil && e.Event.Type == replyEvent:
+				h.deliverReply(*e.Event)
+			case e.Event != nil:
+				h.deliverEvent(*e.Event)
+			case e.Subscription != nil:
+				h.applySubscription(*e.Subscription)
+			case e.Presence != nil:
+				h.applyPresence(e.Node, *e.Presence)
+			case e.Kick != nil:
+				h.applyKick(*e.Kick)
 			}
 		}
 	}
 }
 
-// loadMore gets older messages from the storage using provided offset.
-func (h *Hub) loadMore(offset int) ([]Message, error) {
-	messages, err := h.messages.Latest(100, offset)
+// send stores the inbound message and broadcasts it to the room, notifying
+// the mentioned users. The client is told whether the message is stored.
+func (h *Hub) send(in inbound) (Message, *Error) {
+	message := in.message
+	stored, err := h.messages.Insert(message.Room, message.Parent, message.Text, message.Username, message.Key,
+		in.request.Attachments, message.Created)
+
+	var fail *Error
+	switch {
+	case errors.Is(err, models.ErrDuplicateKey):
+		// the client has retried, the message was delivered the first time
+		h.acknowledge(in.client, in.request, stored)
+		return NewMessage(stored), nil
+	case errors.Is(err, models.ErrInvalidUsername):
+		fail = &Error{Code: notFoundError, Message: "User doesn't exist."}
+	case errors.Is(err, models.ErrInvalidRoom):
+		fail = &Error{Code: notFoundError, Message: "Room doesn't exist."}
+	case errors.Is(err, models.ErrInvalidAttachment):
+		fail = &Error{Code: notFoundError, Message: "Attachment doesn't exist."}
+	case errors.Is(err, models.ErrInvalidParent):
+		fail = &Error{Code: notFoundError, Message: "Message to reply to doesn't exist in the room."}
+	case err != nil:
+		log.Err(err).Msg("error while saving message")
+		fail = &Error{Code: internalError, Message: "Message wasn't saved."}
+	}
+	if fail != nil {
+		h.respond(in.client, Response{Request: in.request, Error: fail})
+		return Message{}, fail
+	}
+
+	if len(message.Mentions) > 0 {
+		err := h.messages.Mention(stored.ID, message.Mentions)
+		if err != nil {
+			log.Err(err).Msg("error while saving mentions")
+		} else {
+			stored.Mentions = message.Mentions
+		}
+	}
+
+	message = NewMessage(stored)
+	if message.Parent != 0 {
+		h.reply(message)
+	} else {
+		h.deliver(message)
+		h.publish(envelope{MessageID: message.ID})
+	}
+	h.acknowledge(in.client, in.request, stored)
+	h.unfurl(stored)
+
+	for _, username := range message.Mentions {
+		e := Event{Type: mentionEvent, Username: username, Message: &message}
+		h.deliverEvent(e)
+		h.publishEvent(e)
+	}
+
+	return message, nil
+}
+
+// Post broadcasts the message from the request to its room on behalf
+// of the user, e.g. a bot using the HTTP API, as if it was sent via
+// websocket. Slash commands are not run, the text is sent as is.
+// Post waits until the message is stored and returns it. If the request
+// has failed, the returned error is an *Error telling why.
+func (h *Hub) Post(username string, req Request) (Message, error) {
+	if err := textError(req); err != nil {
+		return Message{}, err
+	}
+
+	ok, err := h.rooms.IsMember(req.Room, username)
+	if err != nil {
+		log.Err(err).Msg("error checking room membership")
+		return Message{}, &Error{Code: internalError, Message: "Something went wrong, try again later."}
+	}
+	if !ok {
+		return Message{}, &Error{Code: forbiddenError, Message: "You are not a member of the room."}
+	}
+	if err := h.restriction(username); err != nil {
+		return Message{}, err
+	}
+
+	result := make(chan posted, 1)
+	h.broadcast <- inbound{
+		request: req,
+		message: h.newInbound(username, req, req.Room),
+		result:  result,
+	}
+
+	p := <-result
+	if p.err != nil {
+		return Message{}, p.err
+	}
+
+	return p.message, nil
+}
+
+// newInbound creates the message from the request that the user
+// sends to the room.
+func (h *Hub) newInbound(username string, req Request, room int64) Message {
+	return Message{
+		Room:     room,
+		Text:     req.Message,
+		Username: username,
+		Created:  time.Now().UTC(),
+		Key:      req.Key,
+		Mentions: h.mentions(username, req.Message, room),
+		Parent:   req.Parent,
+	}
+}
+
+// mentions returns sorted usernames of the existing users mentioned
+// in the text that can read it, that is, are members of the room.
+// The author is never mentioned.
+func (h *Hub) mentions(author, text string, room int64) []string {
+	var mentioned []string
+	for _, username := range parseMentions(text) {
+		if username != author {
+			mentioned = append(mentioned, username)
+		}
+	}
+	if len(mentioned) == 0 {
+		return nil
+	}
+
+	members, err := h.rooms.Members(room, mentioned)
+	if err != nil {
+		log.Err(err).Msg("error checking room membership")
+		return nil
+	}
+	isMember := make(map[string]bool, len(members))
+	for _, username := range members {
+		isMember[username] = true
+	}
+
+	// the first mentions in the text are kept
+	var usernames []string
+	for _, username := range mentioned {
+		if len(usernames) == mentionsMaxCount {
+			break
+		}
+		if isMember[username] {
+			usernames = append(usernames, username)
+		}
+	}
+	sort.Strings(usernames)
+
+	return usernames
+}
+
+// deliver sends the message to the local clients in the room of the message.
+// The author is no longer typing once the message is sent.
+func (h *Hub) deliver(message Message) {
+	h.stopTyping(typist{room: message.Room, username: message.Username})
+
+	for client := range h.members[message.Room] {
+		select {
+		case client.sendMessage <- message:
+		default:
+			h.removeClient(client)
+		}
+	}
+}
+
+// loadPublished loads the message published by another instance
+// from the storage, ok is false if it can't be loaded.
+func (h *Hub) loadPublished(id int64) (message Message, ok bool) {
+	stored, err := h.messages.Get(id)
+	if err != nil {
+		log.Err(err).Int64("message", id).Msg("error getting published message from db")
+		return Message{}, false
+	}
+
+	return NewMessage(stored), true
+}
+
+// reply notifies the clients in the room about the reply in the thread,
+// so that they can update the thread without showing the reply in
+// the main timeline.
+func (h *Hub) reply(message Message) {
+	e := Event{Type: replyEvent, Message: &message, MessageID: message.Parent}
+	parent, err := h.messages.Get(message.Parent)
+	if err != nil {
+		log.Err(err).Msg("error getting thread parent from db")
+	} else {
+		e.Replies = parent.Replies
+	}
+
+	h.deliverReply(e)
+	h.publishEvent(e)
+}
+
+// unfurl loads previews of the links in the message in the background
+// and sends the message with them to the room as a preview event.
+// Nothing is loaded if too many messages are being unfurled already.
+func (h *Hub) unfurl(m models.Message) {
+	if h.unfurler == nil {
+		return
+	}
+	links := markdown.Links(m.Text)
+	if len(links) == 0 {
+		return
+	}
+	if len(links) > previewsMaxCount {
+		links = links[:previewsMaxCount]
+	}
+
+	select {
+	case h.unfurling <- struct{}{}:
+	default:
+		log.Warn().Int64("message", m.ID).Msg("too many messages are being unfurled, skipping")
+		return
+	}
+
+	go func() {
+		defer func() { <-h.unfurling }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), unfurlTimeout)
+		defer cancel()
+
+		var urls []string
+		for _, link := range links {
+			p, err := h.unfurler.Unfurl(ctx, link)
+			if err != nil {
+				log.Debug().Err(err).Str("url", link).Msg("no preview of the link")
+				continue
+			}
+			urls = append(urls, p.URL)
+		}
+		if len(urls) == 0 {
+			return
+		}
+
+		stored, err := h.messages.AddPreviews(m.ID, urls)
+		if errors.Is(err, models.ErrNoRecord) {
+			// the message has been deleted meanwhile
+			return
+		} else if err != nil {
+			log.Err(err).Msg("error saving previews of the message")
+			return
+		}
+
+		message := NewMessage(stored)
+		h.events <- Event{Type: previewEvent, Message: &message}
+	}()
+}
+
+// deliverReply sends the reply event to the local clients.
+// The author is no longer typing once the reply is sent.
+func (h *Hub) deliverReply(e Event) {
+	h.stopTyping(typist{room: e.Message.Room, username: e.Message.Username})
+	h.deliverEvent(e)
+}
+
+// deliverEvent sends the event to the local clients in the room it has happened in.
+// Typing events are not sent to the typing user, and mention events
+// are sent to the mentioned user only.
+func (h *Hub) deliverEvent(e Event) {
+	for client := range h.members[e.room()] {
+		if e.Type == typingEvent && client.user.Username == e.Username {
+			continue
+		}
+		if e.Type == mentionEvent && client.user.Username != e.Username {
+			continue
+		}
+
+		select {
+		case client.sendEvent <- e:
+		default:
+			h.removeClient(client)
+		}
+	}
+}
+
+// replay responds to the resume request with messages sent after the
+// one with the requested id and the earlier ones changed since it was sent.
+// It runs in the hub goroutine, so the replayed messages are queued before
+// the ones delivered afterwards. If a full page of new messages is returned,
+// the client should resume again from the last replayed message.
+func (h *Hub) replay(client *Client, req Request) {
+	messages, err := h.messages.After(client.user.Username, req.After, resumePageSize)
+	if err != nil {
+		log.Err(err).Msg("error loading missed messages from db")
+		h.respond(client, errorResponse(req, internalError, "Missed messages weren't loaded."))
+		return
+	}
+
+	var changed []models.Message
+	if req.After != 0 {
+		changed, err = h.messages.Changed(client.user.Username, req.After, resumePageSize)
+		if err != nil {
+			log.Err(err).Msg("error loading changed messages from db")
+			h.respond(client, errorResponse(req, internalError, "Missed messages weren't loaded."))
+			return
+		}
+	}
+
+	resp := Response{Request: req, Messages: make([]Message, len(messages))}
+	for i, m := range messages {
+		resp.Messages[i] = NewMessage(m)
+	}
+	for _, m := range changed {
+		resp.Changed = append(resp.Changed, NewMessage(m))
+	}
+
+	h.respond(client, resp)
+}
+
+// respondWho replies to the /who command with the members of the room
+// who are online on any instance. Membership is checked outside of the hub
+// goroutine, so that a slow query doesn't hold up the delivery of messages.
+func (h *Hub) respondWho(client *Client, req Request) {
+	online := h.onlineUsers()
+	go func() {
+		here, err := h.rooms.Members(req.Room, online)
+		if err != nil {
+			log.Err(err).Msg("error checking room membership")
+			client.respond(errorResponse(req, internalError, "Something went wrong, try again later."))
+			return
+		}
+
+		client.respond(Response{Request: req, Users: here, Reply: "Online: " + strings.Join(here, ", ") + "."})
+	}()
+}
+
+// startTyping notifies the local clients that the user has started typing
+// unless they already know it, and postpones the end of typing. Every instance
+// tracks typing users on its own, so the end of typing is never published.
+func (h *Hub) startTyping(e Event) {
+	t := typist{room: e.Room, username: e.Username}
+	if _, ok := h.typists[t]; !ok {
+		h.deliverEvent(e)
+	}
+	h.typists[t] = time.Now().Add(h.typingTimeout)
+}
+
+// stopTyping notifies the local clients that the user has stopped typing.
+func (h *Hub) stopTyping(t typist) {
+	if _, ok := h.typists[t]; !ok {
+		return
+	}
+
+	delete(h.typists, t)
+	h.deliverEvent(Event{Type: typingEvent, Room: t.room, Username: t.username, Typing: false})
+}
+
+// sweepTypists stops typing of the users that have been silent for too long.
+func (h *Hub) sweepTypists(now time.Time) {
+	for t, until := range h.typists {
+		if now.After(until) {
+			h.stopTyping(t)
+		}
+	}
+}
+
+// applySubscription updates rooms of the local clients of the user.
+func (h *Hub) applySubscription(s subscription) {
+	for client := range h.clients {
+		if client.user.Username != s.Username {
+			continue
+		}
+
+		if s.Join {
+			client.rooms[s.Room] = true
+			h.addMember(s.Room, client)
+		} else {
+			delete(client.rooms, s.Room)
+			delete(h.members[s.Room], client)
+		}
+	}
+}
+
+// publishEvent sends the event to other instances of the Hub.
+// The message of the event is replaced by its id.
+func (h *Hub) publishEvent(e Event) {
+	env := envelope{Event: &e}
+	if e.Message != nil {
+		env.MessageID = e.Message.ID
+		e.Message = nil
+	}
+
+	h.publish(env)
+}
+
+// publish sends the envelope to other instances of the Hub.
+func (h *Hub) publish(e envelope) {
+	if h.broker == nil {
+		return
+	}
+
+	e.Node = h.node
+	payload, err := json.Marshal(e)
+	if err != nil {
+		log.Err(err).Msg("error marshalling envelope")
+		return
+	}
+
+	err = h.broker.Publish(payload)
+	if err != nil {
+		log.Err(err).Msg("error publishing envelope to broker")
+	}
+}
+
+// Kick closes all connections of the user on every instance
+// telling the reason. The user can connect again unless banned.
+func (h *Hub) Kick(username, reason string) {
+	h.kicks <- kick{Username: username, Reason: reason}
+}
+
+// applyKick closes the local connections of the kicked user.
+// Clients are unregistered when their connections fail.
+func (h *Hub) applyKick(k kick) {
+	for client := range h.clients {
+		if client.user.Username == k.Username {
+			go client.close(kickedCloseCode, k.Reason)
+		}
+	}
+}
+
+// Subscribe starts delivering messages of the room
+// to all connections of the user on every instance.
+func (h *Hub) Subscribe(username string, roomID int64) {
+	h.subscribe <- subscription{Username: username, Room: roomID, Join: true}
+}
+
+// Unsubscribe stops delivering messages of the room
+// to all connections of the user on every instance.
+func (h *Hub) Unsubscribe(username string, roomID int64) {
+	h.subscribe <- subscription{Username: username, Room: roomID, Join: false}
+}
+
+// respond sends the response to the client unless
+// the client is gone or is not keeping up.
+func (h *Hub) respond(client *Client, resp Response) {
+	if !h.clients[client] {
+		return
+	}
+
+	select {
+	case client.sendResponse <- resp:
+	default:
+		log.Warn().Msg("dropping response to the client that is not keeping up")
+	}
+}
+
+// acknowledge tells the client that its message is stored.
+func (h *Hub) acknowledge(client *Client, req Request, m models.Message) {
+	h.respond(client, Response{Request: req, Ack: &Ack{ID: m.ID, Key: m.Key, Created: m.Created}})
+}
+
+func (h *Hub) addMember(room int64, client *Client) {
+	if h.members[room] == nil {
+		h.members[room] = make(map[*Client]bool)
+	}
+	h.members[room][client] = true
+}
+
+// removeClient forgets the client and tells its pumps to stop.
+func (h *Hub) removeClient(client *Client) {
+	close(client.done)
+	delete(h.clients, client)
+	for room := range client.rooms {
+		delete(h.members[room], client)
+	}
+	h.disconnect(client.user.Username)
+}
+
+// LoadMore gets messages of the room sent before the message with provided id
+// from the storage, newest first. The latest messages are returned if id is 0.
+func (h *Hub) LoadMore(room, before int64) ([]Message, error) {
+	messages, err := h.messages.Before(room, before, historyPageSize)
 	if err != nil {
 		return []Message{}, err
 	}
@@ -112,8 +1087,71 @@ func (h *Hub) loadMore(offset int) ([]Message, error) {
 
 	chatMessages := make([]Message, len(messages))
 	for i, m := range messages {
-		chatMessages[i] = Message{Text: m.Text, Username: m.Username, Created: m.Created}
+		chatMessages[i] = NewMessage(m)
 	}
 
 	return chatMessages, nil
 }
+
+// LoadThread gets the message with provided id followed by the
+// latest replies in the thread it has started, oldest first.
+func (h *Hub) LoadThread(id int64) ([]Message, error) {
+	messages, err := h.messages.Thread(id, threadPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	chatMessages := make([]Message, len(messages))
+	for i, m := range messages {
+		chatMessages[i] = NewMessage(m)
+	}
+
+	return chatMessages, nil
+}
+
+// Search finds messages matching the query in the rooms the user is a member of,
+// best matches first.
+func (h *Hub) Search(username string, q models.SearchQuery) ([]Found, error) {
+	results, err := h.messages.Search(username, q, searchPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]Found, len(results))
+	for i, r := range results {
+		found[i] = Found{Message: NewMessage(r.Message), Headline: r.Headline, Rank: r.Rank}
+	}
+
+	return found, nil
+}
+
+// ListRooms returns all rooms marking the ones the user has joined
+// followed by the direct rooms of the user.
+func (h *Hub) ListRooms(username string) ([]Room, error) {
+	all, err := h.rooms.All()
+	if err != nil {
+		return nil, err
+	}
+
+	joined, err := h.rooms.Joined(username)
+	if err != nil {
+		return nil, err
+	}
+
+	isJoined := make(map[int64]bool, len(joined))
+	for _, r := range joined {
+		isJoined[r.ID] = true
+	}
+
+	rooms := make([]Room, 0, len(all)+len(joined))
+	for _, r := range all {
+		rooms = append(rooms, Room{ID: r.ID, Name: r.Name, Joined: isJoined[r.ID], Topic: r.Topic})
+	}
+	for _, r := range joined {
+		if r.Direct {
+			rooms = append(rooms, Room{ID: r.ID, Name: r.Name, Direct: true, Joined: true, Topic: r.Topic})
+		}
+	}
+
+	return rooms, nil
+}
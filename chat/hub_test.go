@@ -0,0 +1,1361 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHub_Direct(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	alice := connect(t, ts, "alice")
+	bob := connect(t, ts, "bob")
+	eve := connect(t, ts, "eve")
+
+	request(t, alice, Request{Action: directAction, To: "bob", Message: "hi"})
+
+	// sender gets the message, its ack and the updated list of rooms
+	var rooms []Room
+	for i := 0; i < 3; i++ {
+		var payload Response
+		receive(t, alice, &payload)
+
+		switch {
+		case payload.Rooms != nil:
+			rooms = payload.Rooms
+		case payload.Ack != nil:
+			assert.Equal(t, directAction, payload.Request.Action)
+		default:
+			if assert.Len(t, payload.Messages, 1) {
+				assert.Equal(t, "hi", payload.Messages[0].Text)
+			}
+		}
+	}
+	assert.Contains(t, rooms, Room{ID: 2, Name: "", Direct: true, Joined: true})
+
+	var update Update
+	receive(t, bob, &update)
+	if assert.Len(t, update.Messages, 1) {
+		assert.Equal(t, "hi", update.Messages[0].Text)
+		assert.Equal(t, "alice", update.Messages[0].Username)
+		assert.Equal(t, int64(2), update.Messages[0].Room)
+	}
+
+	receiveNothing(t, eve)
+}
+
+func TestHub_DirectToNonExistentUser(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	alice := connect(t, ts, "alice")
+
+	request(t, alice, Request{Action: directAction, To: "", Message: "hi"})
+
+	var resp Response
+	receive(t, alice, &resp)
+	assert.Equal(t, directAction, resp.Request.Action)
+	assert.Equal(t, &Error{Code: notFoundError, Message: "User doesn't exist."}, resp.Error)
+}
+
+func TestHub_SeveralInstances(t *testing.T) {
+	store := newMemoryStore()
+	broker := &memoryBroker{}
+	ts1 := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{Broker: broker.connect()}))
+	ts2 := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{Broker: broker.connect()}))
+
+	for _, username := range []string{"alice", "bob", "eve"} {
+		err := rooms{store}.Join(models.DefaultRoomID, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := connect(t, ts1, "alice")
+	bob := connect(t, ts2, "bob")
+	eve := connect(t, ts2, "eve")
+
+	// message in the room reaches clients of both instances
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "hello"})
+
+	msg, _ := receiveSent(t, alice)
+	assert.Equal(t, "hello", msg.Text)
+
+	for _, conn := range []*websocket.Conn{bob, eve} {
+		var update Update
+		receive(t, conn, &update)
+		if assert.Len(t, update.Messages, 1) {
+			assert.Equal(t, "hello", update.Messages[0].Text)
+		}
+	}
+
+	// messages are delivered however long they get once rendered
+	long := strings.Repeat("<b>😀</b>", messageMaxLength/8)
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: long})
+	msg, _ = receiveSent(t, alice)
+
+	for _, conn := range []*websocket.Conn{bob, eve} {
+		var update Update
+		receive(t, conn, &update)
+		if assert.Len(t, update.Messages, 1) {
+			assert.Equal(t, long, update.Messages[0].Text)
+			assert.Contains(t, update.Messages[0].HTML, "&lt;b&gt;😀&lt;/b&gt;")
+		}
+	}
+
+	// and so are events about them
+	edited := strings.Repeat("<i>😎</i>", messageMaxLength/8)
+	request(t, alice, Request{Action: editAction, MessageID: msg.ID, Message: edited})
+
+	for _, conn := range []*websocket.Conn{alice, bob, eve} {
+		var event Event
+		receive(t, conn, &event)
+		assert.Equal(t, editEvent, event.Type)
+		if assert.NotNil(t, event.Message) {
+			assert.Equal(t, edited, event.Message.Text)
+			assert.Contains(t, event.Message.HTML, "&lt;i&gt;😎&lt;/i&gt;")
+		}
+	}
+
+	// direct room created on one instance is joined by the client of another
+	request(t, alice, Request{Action: directAction, To: "bob", Message: "hi"})
+
+	var update Update
+	receive(t, bob, &update)
+	if assert.Len(t, update.Messages, 1) {
+		assert.Equal(t, "hi", update.Messages[0].Text)
+	}
+
+	receiveNothing(t, eve)
+}
+
+func TestHub_EditAndDelete(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	for _, username := range []string{"alice", "bob"} {
+		err := rooms{store}.Join(models.DefaultRoomID, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := connect(t, ts, "alice")
+	bob := connect(t, ts, "bob")
+
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "helo"})
+
+	receiveSent(t, alice)
+	var update Update
+	receive(t, bob, &update)
+	id := update.Messages[0].ID
+
+	// only the author can change the message
+	request(t, bob, Request{Action: editAction, MessageID: id, Message: "hacked"})
+
+	var resp Response
+	receive(t, bob, &resp)
+	assert.Equal(t, &Error{Code: forbiddenError, Message: "Only the author can change the message."}, resp.Error)
+
+	request(t, alice, Request{Action: editAction, MessageID: id, Message: "hello"})
+
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		var event Event
+		receive(t, conn, &event)
+		assert.Equal(t, editEvent, event.Type)
+		if assert.NotNil(t, event.Message) {
+			assert.Equal(t, id, event.Message.ID)
+			assert.Equal(t, "hello", event.Message.Text)
+			assert.NotNil(t, event.Message.Edited)
+		}
+	}
+
+	request(t, alice, Request{Action: deleteAction, MessageID: id})
+
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		var event Event
+		receive(t, conn, &event)
+		assert.Equal(t, deleteEvent, event.Type)
+		if assert.NotNil(t, event.Message) {
+			assert.Equal(t, id, event.Message.ID)
+			assert.Empty(t, event.Message.Text)
+			assert.True(t, event.Message.Deleted)
+		}
+	}
+
+	request(t, alice, Request{Action: deleteAction, MessageID: id})
+
+	resp = Response{}
+	receive(t, alice, &resp)
+	assert.Equal(t, &Error{Code: notFoundError, Message: "Message doesn't exist."}, resp.Error)
+}
+
+func TestHub_ModeratorDeletes(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	for _, username := range []string{"alice", "bob", "mod"} {
+		err := rooms{store}.Join(models.DefaultRoomID, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := connect(t, ts, "alice")
+	bob := connect(t, ts, "bob")
+	mod := connectAs(t, ts, "mod", models.RoleModerator)
+
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "spam"})
+
+	receiveSent(t, alice)
+	var update Update
+	receive(t, bob, &update)
+	receive(t, mod, &update)
+	id := update.Messages[0].ID
+
+	// members can't delete messages of other users
+	request(t, bob, Request{Action: deleteAction, MessageID: id})
+
+	var resp Response
+	receive(t, bob, &resp)
+	assert.Equal(t, &Error{Code: forbiddenError, Message: "Only the author can change the message."}, resp.Error)
+
+	// moderators can't edit them either
+	request(t, mod, Request{Action: editAction, MessageID: id, Message: "edited"})
+
+	resp = Response{}
+	receive(t, mod, &resp)
+	assert.Equal(t, &Error{Code: forbiddenError, Message: "Only the author can change the message."}, resp.Error)
+
+	// but can delete them
+	request(t, mod, Request{Action: deleteAction, MessageID: id})
+
+	for _, conn := range []*websocket.Conn{alice, bob, mod} {
+		var event Event
+		receive(t, conn, &event)
+		assert.Equal(t, deleteEvent, event.Type)
+		if assert.NotNil(t, event.Message) {
+			assert.Equal(t, id, event.Message.ID)
+			assert.True(t, event.Message.Deleted)
+		}
+	}
+
+}
+
+func TestHub_RemovedClient(t *testing.T) {
+	store := newMemoryStore()
+	hub := NewHub(store, rooms{store}, users{store}, HubOptions{})
+
+	client := &Client{
+		hub:          hub,
+		user:         models.User{Username: "alice"},
+		rooms:        map[int64]bool{},
+		sendMessage:  make(chan Message, 1),
+		sendResponse: make(chan Response),
+		sendEvent:    make(chan Event, 1),
+		done:         make(chan struct{}),
+	}
+	hub.clients[client] = true
+	hub.connect("alice")
+
+	// the hub removes the client that doesn't keep up
+	// while readPump is still handling its request
+	hub.removeClient(client)
+
+	assert.NotPanics(t, func() {
+		client.respond(errorResponse(Request{}, internalError, "Something went wrong, try again later."))
+	})
+}
+
+func TestHub_Markdown(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	err := rooms{store}.Join(models.DefaultRoomID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := connect(t, ts, "alice")
+
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "**hi** <b>there</b>"})
+	msg, _ := receiveSent(t, alice)
+
+	// the source is kept as it was written
+	assert.Equal(t, "**hi** <b>there</b>", msg.Text)
+	assert.Equal(t, "<strong>hi</strong> &lt;b&gt;there&lt;/b&gt;", msg.HTML)
+
+	request(t, alice, Request{Action: editAction, MessageID: msg.ID, Message: "_hello_ & bye"})
+
+	var event Event
+	receive(t, alice, &event)
+	if assert.NotNil(t, event.Message) {
+		assert.Equal(t, "_hello_ & bye", event.Message.Text)
+		assert.Equal(t, "<em>hello</em> &amp; bye", event.Message.HTML)
+	}
+}
+
+func TestHub_Previews(t *testing.T) {
+	store := newMemoryStore()
+	store.previews["https://example.com"] = models.Preview{
+		URL:         "https://example.com",
+		Title:       "Example Domain",
+		Description: "For use in examples",
+	}
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{Unfurler: store}))
+
+	err := rooms{store}.Join(models.DefaultRoomID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := connect(t, ts, "alice")
+
+	// links without previews are skipped
+	request(t, alice, Request{
+		Action:  broadcastAction,
+		Room:    models.DefaultRoomID,
+		Message: "see https://example.com and https://example.org",
+	})
+	// the preview can be loaded before the message is written
+	// to the connection, so the payloads come in any order
+	var (
+		msg   *Message
+		event *Event
+		acked bool
+	)
+	for msg == nil || event == nil || !acked {
+		var payload struct {
+			Event
+			Response
+		}
+		receive(t, alice, &payload)
+
+		switch {
+		case payload.Type != "":
+			event = &payload.Event
+		case payload.Ack != nil:
+			acked = true
+		case len(payload.Messages) == 1:
+			msg = &payload.Messages[0]
+		}
+	}
+	assert.Empty(t, msg.Previews)
+
+	assert.Equal(t, previewEvent, event.Type)
+	if assert.NotNil(t, event.Message) {
+		assert.Equal(t, msg.ID, event.Message.ID)
+		assert.Equal(t, []Preview{{
+			URL:         "https://example.com",
+			Title:       "Example Domain",
+			Description: "For use in examples",
+		}}, event.Message.Previews)
+	}
+}
+
+func TestHub_Resume(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	err := rooms{store}.Join(models.DefaultRoomID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// messages sent while alice was offline, rooms she hasn't joined
+	// and replies are left out by the SQL tested in models/postgresql
+	for _, text := range []string{"seen", "missed", "missed too"} {
+		_, err := store.Insert(models.DefaultRoomID, 0, text, "bob", "", nil, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = store.Edit(1, "bob", "seen and edited", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := connect(t, ts, "alice")
+	request(t, alice, Request{Action: resumeAction, After: 1})
+
+	var resp Response
+	receive(t, alice, &resp)
+	assert.Equal(t, resumeAction, resp.Request.Action)
+	assert.Nil(t, resp.Error)
+	if assert.Len(t, resp.Messages, 2) {
+		assert.Equal(t, "missed", resp.Messages[0].Text)
+		assert.Equal(t, "missed too", resp.Messages[1].Text)
+	}
+	// the message seen before is changed in place
+	if assert.Len(t, resp.Changed, 1) {
+		assert.Equal(t, "seen and edited", resp.Changed[0].Text)
+	}
+
+	// live delivery goes on after the replay
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "back"})
+
+	msg, _ := receiveSent(t, alice)
+	assert.Equal(t, "back", msg.Text)
+}
+
+func TestHub_Ack(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	for _, username := range []string{"alice", "bob"} {
+		err := rooms{store}.Join(models.DefaultRoomID, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := connect(t, ts, "alice")
+	bob := connect(t, ts, "bob")
+
+	req := Request{ID: "1", Action: broadcastAction, Room: models.DefaultRoomID, Message: "hello", Key: "k1"}
+	request(t, alice, req)
+
+	msg, ack := receiveSent(t, alice)
+	assert.Equal(t, msg.ID, ack.ID)
+	assert.Equal(t, "k1", ack.Key)
+	assert.Equal(t, msg.Created, ack.Created)
+
+	var update Update
+	receive(t, bob, &update)
+	if assert.Len(t, update.Messages, 1) {
+		assert.Equal(t, msg.ID, update.Messages[0].ID)
+	}
+
+	// retry is acknowledged with the same message, but not sent again
+	req.ID = "2"
+	request(t, alice, req)
+
+	var resp Response
+	receive(t, alice, &resp)
+	assert.Equal(t, "2", resp.Request.ID)
+	assert.Equal(t, &ack, resp.Ack)
+
+	receiveNothing(t, bob)
+}
+
+func TestHub_ErrorResponses(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	err := rooms{store}.Join(models.DefaultRoomID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := connect(t, ts, "alice")
+
+	tests := []struct {
+		name      string
+		request   interface{}
+		wantID    string
+		wantError string
+	}{
+		{
+			name:      "Invalid JSON",
+			request:   "{not json",
+			wantID:    "",
+			wantError: invalidJSONError,
+		},
+		{
+			name:      "Unknown action",
+			request:   Request{ID: "1", Action: "dance"},
+			wantID:    "1",
+			wantError: unknownActionError,
+		},
+		{
+			name:      "Empty message",
+			request:   Request{ID: "2", Action: broadcastAction, Room: models.DefaultRoomID, Message: " "},
+			wantID:    "2",
+			wantError: invalidRequestError,
+		},
+		{
+			name:      "Message too long",
+			request:   Request{ID: "3", Action: broadcastAction, Room: models.DefaultRoomID, Message: strings.Repeat("a", messageMaxLength+1)},
+			wantID:    "3",
+			wantError: tooLongError,
+		},
+		{
+			name:      "Not a member",
+			request:   Request{ID: "4", Action: loadMoreAction, Room: 42},
+			wantID:    "4",
+			wantError: forbiddenError,
+		},
+	}
+
+	// requests are sent over the same connection,
+	// which must stay open after every error
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if raw, ok := tt.request.(string); ok {
+				err := alice.WriteMessage(websocket.TextMessage, []byte(raw))
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				request(t, alice, tt.request.(Request))
+			}
+
+			var resp Response
+			receive(t, alice, &resp)
+			assert.Equal(t, tt.wantID, resp.Request.ID)
+			if assert.NotNil(t, resp.Error) {
+				assert.Equal(t, tt.wantError, resp.Error.Code)
+				assert.NotEmpty(t, resp.Error.Message)
+			}
+		})
+	}
+}
+
+func TestHub_Typing(t *testing.T) {
+	store := newMemoryStore()
+	hub := NewHub(store, rooms{store}, users{store}, HubOptions{})
+	hub.typingTimeout = 200 * time.Millisecond
+	ts := newTestHubServer(t, hub)
+
+	for _, username := range []string{"alice", "bob"} {
+		err := rooms{store}.Join(models.DefaultRoomID, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := connect(t, ts, "alice")
+	bob := connect(t, ts, "bob")
+	eve := connect(t, ts, "eve")
+
+	request(t, alice, Request{Action: typingAction, Room: models.DefaultRoomID})
+
+	var event Event
+	receive(t, bob, &event)
+	assert.Equal(t, Event{Type: typingEvent, Room: models.DefaultRoomID, Username: "alice", Typing: true}, event)
+
+	// typing stops on its own after a while
+	event = Event{}
+	receive(t, bob, &event)
+	assert.Equal(t, Event{Type: typingEvent, Room: models.DefaultRoomID, Username: "alice", Typing: false}, event)
+
+	// or when the message is sent
+	request(t, alice, Request{Action: typingAction, Room: models.DefaultRoomID})
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "hi"})
+
+	// the end of typing and the message can come in any order
+	var typing []bool
+	for i := 0; i < 3; i++ {
+		var payload struct {
+			Event
+			Update
+		}
+		receive(t, bob, &payload)
+
+		if payload.Type == typingEvent {
+			typing = append(typing, payload.Typing)
+		} else {
+			assert.Len(t, payload.Messages, 1)
+		}
+	}
+	assert.Equal(t, []bool{true, false}, typing)
+
+	// typing user and non-members are not notified
+	receiveSent(t, alice)
+	receiveNothing(t, alice)
+	receiveNothing(t, eve)
+}
+
+func TestHub_Presence(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	alice := connect(t, ts, "alice")
+
+	request(t, alice, Request{Action: onlineAction})
+
+	var resp Response
+	receive(t, alice, &resp)
+	assert.Equal(t, []string{"alice"}, resp.Users)
+
+	// only the first connection of the user is announced
+	bob1 := connect(t, ts, "bob")
+	bob2 := connect(t, ts, "bob")
+
+	var event Event
+	receive(t, alice, &event)
+	assert.Equal(t, Event{Type: presenceEvent, Username: "bob", Online: true}, event)
+
+	// and only the last one
+	err := bob1.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bob2.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event = Event{}
+	receive(t, alice, &event)
+	assert.Equal(t, Event{Type: presenceEvent, Username: "bob", Online: false}, event)
+
+	receiveNothing(t, alice)
+}
+
+func TestHub_PresenceSeveralInstances(t *testing.T) {
+	store := newMemoryStore()
+	broker := &memoryBroker{}
+	ts1 := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{Broker: broker.connect()}))
+	ts2 := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{Broker: broker.connect()}))
+
+	alice := connect(t, ts1, "alice")
+	request(t, alice, Request{Action: onlineAction})
+
+	var resp Response
+	receive(t, alice, &resp)
+
+	connect(t, ts2, "bob")
+
+	var event Event
+	receive(t, alice, &event)
+	assert.Equal(t, Event{Type: presenceEvent, Username: "bob", Online: true}, event)
+
+	// the new instance learns who is online from the others
+	ts3 := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{Broker: broker.connect()}))
+	eve := connect(t, ts3, "eve")
+
+	assert.Eventually(t, func() bool {
+		request(t, eve, Request{Action: onlineAction})
+
+		var resp Response
+		receive(t, eve, &resp)
+		return assert.ObjectsAreEqual([]string{"alice", "bob", "eve"}, resp.Users)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHub_Read(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	for _, username := range []string{"alice", "bob"} {
+		err := rooms{store}.Join(models.DefaultRoomID, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := connect(t, ts, "alice")
+	bob := connect(t, ts, "bob")
+
+	request(t, bob, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "hi"})
+	msg, _ := receiveSent(t, bob)
+
+	var update Update
+	receive(t, alice, &update)
+
+	request(t, alice, Request{Action: readAction, Room: models.DefaultRoomID, MessageID: msg.ID})
+
+	want := Event{Type: readEvent, Room: models.DefaultRoomID, Username: "alice", MessageID: msg.ID}
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		var event Event
+		receive(t, conn, &event)
+		assert.Equal(t, want, event)
+	}
+
+	request(t, alice, Request{Action: readAction, Room: models.DefaultRoomID, MessageID: 42})
+
+	var resp Response
+	receive(t, alice, &resp)
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, notFoundError, resp.Error.Code)
+	}
+}
+
+func TestHub_Mentions(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	for _, username := range []string{"alice", "bob"} {
+		err := rooms{store}.Join(models.DefaultRoomID, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// eve exists, but is not a member of the room
+	_, err := rooms{store}.Insert("other", "eve")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := connect(t, ts, "alice")
+	bob := connect(t, ts, "bob")
+	eve := connect(t, ts, "eve")
+
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "hi @bob, @eve, @nobody and @alice"})
+
+	msg, _ := receiveSent(t, alice)
+	assert.Equal(t, []string{"bob"}, msg.Mentions)
+
+	// the message and the notification can come in any order
+	var gotEvent bool
+	for i := 0; i < 2; i++ {
+		var payload struct {
+			Event
+			Update
+		}
+		receive(t, bob, &payload)
+
+		if payload.Type == mentionEvent {
+			gotEvent = true
+			assert.Equal(t, "bob", payload.Username)
+			if assert.NotNil(t, payload.Message) {
+				assert.Equal(t, msg.ID, payload.Message.ID)
+			}
+		} else if assert.Len(t, payload.Messages, 1) {
+			assert.Equal(t, []string{"bob"}, payload.Messages[0].Mentions)
+		}
+	}
+	assert.True(t, gotEvent)
+
+	receiveNothing(t, alice)
+	receiveNothing(t, eve)
+}
+
+func TestHub_EditMentionsAndPreviews(t *testing.T) {
+	store := newMemoryStore()
+	for _, url := range []string{"https://example.com", "https://example.org"} {
+		store.previews[url] = models.Preview{URL: url, Title: "Example Domain"}
+	}
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{Unfurler: store}))
+
+	for _, username := range []string{"alice", "bob", "carol"} {
+		err := rooms{store}.Join(models.DefaultRoomID, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg, err := store.Insert(models.DefaultRoomID, 0, "@bob https://example.com", "alice", "", nil, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Mention(msg.ID, []string{"bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddPreviews(msg.ID, []string{"https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	alice := connect(t, ts, "alice")
+	bob := connect(t, ts, "bob")
+	carol := connect(t, ts, "carol")
+
+	request(t, alice, Request{Action: editAction, MessageID: msg.ID, Message: "@bob and @carol, see https://example.org"})
+
+	// the preview can be loaded before the mention is delivered,
+	// so the events come in any order
+	for _, conn := range []*websocket.Conn{alice, bob, carol} {
+		events := make(map[string]Event)
+		for len(events) < 2 || conn == carol && len(events) < 3 {
+			var event Event
+			receive(t, conn, &event)
+			events[event.Type] = event
+		}
+
+		if edit := events[editEvent].Message; assert.NotNil(t, edit) {
+			assert.Equal(t, "@bob and @carol, see https://example.org", edit.Text)
+			assert.Equal(t, []string{"bob", "carol"}, edit.Mentions)
+			assert.Empty(t, edit.Previews)
+		}
+		if preview := events[previewEvent].Message; assert.NotNil(t, preview) {
+			assert.Equal(t, []Preview{{URL: "https://example.org", Title: "Example Domain"}}, preview.Previews)
+		}
+		// bob has already been notified of the mention
+		if conn == carol {
+			assert.Equal(t, "carol", events[mentionEvent].Username)
+		}
+	}
+
+	receiveNothing(t, alice)
+	receiveNothing(t, bob)
+	receiveNothing(t, carol)
+}
+
+func TestHub_Post(t *testing.T) {
+	store := newMemoryStore()
+	store.bots["robot"] = true
+	hub := NewHub(store, rooms{store}, users{store}, HubOptions{})
+	ts := newTestHubServer(t, hub)
+
+	for _, username := range []string{"alice", "robot"} {
+		err := rooms{store}.Join(models.DefaultRoomID, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	other, err := rooms{store}.Insert("other", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := connect(t, ts, "alice")
+
+	msg, err := hub.Post("robot", Request{Room: models.DefaultRoomID, Message: "hi @alice", Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hi @alice", msg.Text)
+	assert.Equal(t, "robot", msg.Username)
+	assert.True(t, msg.Bot)
+	assert.Equal(t, []string{"alice"}, msg.Mentions)
+
+	// the message and the notification can come in any order
+	for i := 0; i < 2; i++ {
+		var payload struct {
+			Event
+			Update
+		}
+		receive(t, alice, &payload)
+
+		if payload.Type == mentionEvent {
+			assert.Equal(t, "alice", payload.Username)
+		} else if assert.Len(t, payload.Messages, 1) {
+			assert.Equal(t, msg, payload.Messages[0])
+		}
+	}
+
+	// retried messages are not sent again
+	again, err := hub.Post("robot", Request{Room: models.DefaultRoomID, Message: "hi @alice", Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, msg.ID, again.ID)
+	receiveNothing(t, alice)
+
+	tests := []struct {
+		name string
+		req  Request
+		code string
+	}{
+		{"Empty", Request{Room: models.DefaultRoomID, Message: " "}, invalidRequestError},
+		{"TooLong", Request{Room: models.DefaultRoomID, Message: strings.Repeat("a", messageMaxLength+1)}, tooLongError},
+		{"NotMember", Request{Room: other, Message: "hi"}, forbiddenError},
+		{"InvalidParent", Request{Room: models.DefaultRoomID, Message: "hi", Parent: 100}, notFoundError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := hub.Post("robot", tt.req)
+
+			var chatErr *Error
+			if assert.ErrorAs(t, err, &chatErr) {
+				assert.Equal(t, tt.code, chatErr.Code)
+			}
+		})
+	}
+}
+
+func TestHub_Threads(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	for _, username := range []string{"alice", "bob"} {
+		err := rooms{store}.Join(models.DefaultRoomID, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := connect(t, ts, "alice")
+	bob := connect(t, ts, "bob")
+
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "question"})
+	parent, _ := receiveSent(t, alice)
+
+	var update Update
+	receive(t, bob, &update)
+
+	request(t, bob, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "answer", Parent: parent.ID})
+
+	// replies are sent as events rather than updates of the main timeline
+	var event Event
+	receive(t, alice, &event)
+	assert.Equal(t, replyEvent, event.Type)
+	assert.Equal(t, parent.ID, event.MessageID)
+	assert.Equal(t, 1, event.Replies)
+	if assert.NotNil(t, event.Message) {
+		assert.Equal(t, parent.ID, event.Message.Parent)
+		assert.Equal(t, "answer", event.Message.Text)
+	}
+
+	request(t, alice, Request{Action: threadAction, MessageID: parent.ID})
+
+	var resp Response
+	receive(t, alice, &resp)
+	if assert.Len(t, resp.Messages, 2) {
+		assert.Equal(t, parent.ID, resp.Messages[0].ID)
+		assert.Equal(t, 1, resp.Messages[0].Replies)
+		assert.Equal(t, event.Message.ID, resp.Messages[1].ID)
+	}
+
+	request(t, alice, Request{Action: loadMoreAction, Room: models.DefaultRoomID})
+
+	receive(t, alice, &resp)
+	if assert.Len(t, resp.Messages, 1) {
+		assert.Equal(t, parent.ID, resp.Messages[0].ID)
+	}
+
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "answer", Parent: 42})
+
+	receive(t, alice, &resp)
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, notFoundError, resp.Error.Code)
+	}
+}
+
+func TestHub_Reactions(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	for _, username := range []string{"alice", "bob"} {
+		err := rooms{store}.Join(models.DefaultRoomID, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// eve is not a member of the room
+	_, err := rooms{store}.Insert("other", "eve")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := connect(t, ts, "alice")
+	bob := connect(t, ts, "bob")
+	eve := connect(t, ts, "eve")
+
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "hi"})
+	msg, _ := receiveSent(t, alice)
+
+	var update Update
+	receive(t, bob, &update)
+
+	var event Event
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		request(t, conn, Request{Action: addReactionAction, MessageID: msg.ID, Emoji: "👍"})
+
+		// everybody in the room sees the change
+		for _, conn := range []*websocket.Conn{alice, bob} {
+			receive(t, conn, &event)
+			assert.Equal(t, reactionEvent, event.Type)
+		}
+	}
+	if assert.NotNil(t, event.Message) {
+		assert.Equal(t, []Reaction{{Emoji: "👍", Count: 2, Users: []string{"alice", "bob"}}}, event.Message.Reactions)
+	}
+
+	request(t, bob, Request{Action: removeReactionAction, MessageID: msg.ID, Emoji: "👍"})
+
+	receive(t, alice, &event)
+	if assert.NotNil(t, event.Message) {
+		assert.Equal(t, []Reaction{{Emoji: "👍", Count: 1, Users: []string{"alice"}}}, event.Message.Reactions)
+	}
+
+	// reactions are loaded with the history
+	request(t, alice, Request{Action: loadMoreAction, Room: models.DefaultRoomID})
+
+	var resp Response
+	receive(t, alice, &resp)
+	if assert.Len(t, resp.Messages, 1) {
+		assert.Equal(t, event.Message.Reactions, resp.Messages[0].Reactions)
+	}
+
+	tests := []struct {
+		name     string
+		conn     *websocket.Conn
+		req      Request
+		wantCode string
+	}{
+		{"Not emoji", alice, Request{Action: addReactionAction, MessageID: msg.ID, Emoji: "like"}, invalidRequestError},
+		{"Non-existent message", alice, Request{Action: addReactionAction, MessageID: 42, Emoji: "👍"}, notFoundError},
+		{"Not a member", eve, Request{Action: addReactionAction, MessageID: msg.ID, Emoji: "👍"}, forbiddenError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request(t, tt.conn, tt.req)
+
+			var resp Response
+			receive(t, tt.conn, &resp)
+			if assert.NotNil(t, resp.Error) {
+				assert.Equal(t, tt.wantCode, resp.Error.Code)
+			}
+		})
+	}
+}
+
+func TestHub_Search(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	err := rooms{store}.Join(models.DefaultRoomID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"hello world", "bye world", "hello there"} {
+		_, err := store.Insert(models.DefaultRoomID, 0, text, "bob", "", nil, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := connect(t, ts, "alice")
+
+	// filters are applied by the SQL tested in models/postgresql,
+	// the hub only passes them on
+	since := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := since.Add(time.Hour)
+	tests := []struct {
+		name      string
+		req       Request
+		wantQuery models.SearchQuery
+		wantIDs   []int64
+	}{
+		{"Query", Request{Query: " hello "}, models.SearchQuery{Text: "hello"}, []int64{1, 3}},
+		{
+			"Filters",
+			Request{Query: "hello", Room: models.DefaultRoomID, Author: "bob", Since: &since, Until: &until},
+			models.SearchQuery{Text: "hello", RoomID: models.DefaultRoomID, Author: "bob", Since: since, Until: until},
+			[]int64{1, 3},
+		},
+		{"Nothing found", Request{Query: "welcome"}, models.SearchQuery{Text: "welcome"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Action = searchAction
+			request(t, alice, tt.req)
+
+			var resp Response
+			receive(t, alice, &resp)
+
+			var ids []int64
+			for _, r := range resp.Results {
+				ids = append(ids, r.Message.ID)
+				assert.Contains(t, r.Headline, "<mark>"+tt.wantQuery.Text+"</mark>")
+			}
+			assert.Equal(t, tt.wantIDs, ids)
+
+			store.mu.Lock()
+			defer store.mu.Unlock()
+			assert.Equal(t, tt.wantQuery, store.searched)
+		})
+	}
+
+	request(t, alice, Request{Action: searchAction, Query: " "})
+
+	var resp Response
+	receive(t, alice, &resp)
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, invalidRequestError, resp.Error.Code)
+	}
+}
+
+func TestHub_Attachments(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	err := rooms{store}.Join(models.DefaultRoomID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.uploads[1] = models.Attachment{ID: 1, Username: "alice", Filename: "cat.png", ContentType: "image/png", Size: 42,
+		Width: 640, Height: 480, ThumbnailKey: "cat_thumbnail", ThumbnailWidth: 320, ThumbnailHeight: 240}
+	store.uploads[2] = models.Attachment{ID: 2, Username: "bob", Filename: "dog.png", ContentType: "image/png", Size: 42}
+
+	alice := connect(t, ts, "alice")
+
+	// the text may be omitted when there are attachments
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Attachments: []int64{1}})
+	msg, _ := receiveSent(t, alice)
+
+	// images come with thumbnails to be shown instead of them
+	assert.Equal(t, []Attachment{{
+		ID:          1,
+		Filename:    "cat.png",
+		ContentType: "image/png",
+		Size:        42,
+		Width:       640,
+		Height:      480,
+		URL:         "/attachments/1",
+		Thumbnail:   &Thumbnail{URL: "/attachments/1/thumbnail", Width: 320, Height: 240},
+	}}, msg.Attachments)
+
+	tooMany := make([]int64, attachmentsMaxCount+1)
+	tests := []struct {
+		name     string
+		req      Request
+		wantCode string
+	}{
+		{"Already sent", Request{Attachments: []int64{1}}, notFoundError},
+		{"Uploaded by other user", Request{Attachments: []int64{2}}, notFoundError},
+		{"Non-existent", Request{Attachments: []int64{42}}, notFoundError},
+		{"Too many", Request{Attachments: tooMany}, invalidRequestError},
+		{"No text", Request{}, invalidRequestError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Action = broadcastAction
+			tt.req.Room = models.DefaultRoomID
+			request(t, alice, tt.req)
+
+			var resp Response
+			receive(t, alice, &resp)
+			if assert.NotNil(t, resp.Error) {
+				assert.Equal(t, tt.wantCode, resp.Error.Code)
+			}
+		})
+	}
+}
+
+func TestHub_Commands(t *testing.T) {
+	store := newMemoryStore()
+	hub := NewHub(store, rooms{store}, users{store}, HubOptions{})
+	hub.Commands().Register(Command{
+		Name:        "roll",
+		Usage:       "/roll <dice>",
+		Description: "Rolls the dice.",
+		Handler: func(call *CommandCall) error {
+			if call.Args == "" {
+				return ErrUsage
+			}
+			call.Reply("You rolled " + call.Args + ".")
+			return nil
+		},
+	})
+	ts := newTestHubServer(t, hub)
+
+	for _, username := range []string{"alice", "bob"} {
+		err := rooms{store}.Join(models.DefaultRoomID, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := connect(t, ts, "alice")
+	bob := connect(t, ts, "bob")
+
+	// sendText sends the text to the room and checks that
+	// bob receives it as the message
+	sendText := func(text string) Message {
+		request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: text})
+		msg, _ := receiveSent(t, alice)
+
+		var update Update
+		receive(t, bob, &update)
+		if assert.Len(t, update.Messages, 1) {
+			assert.Equal(t, msg.Text, update.Messages[0].Text)
+		}
+
+		return msg
+	}
+
+	// command sends the text to the room and returns the response to it
+	command := func(text string) Response {
+		request(t, alice, Request{ID: text, Action: broadcastAction, Room: models.DefaultRoomID, Message: text})
+
+		var resp Response
+		receive(t, alice, &resp)
+		assert.Equal(t, text, resp.Request.ID)
+
+		return resp
+	}
+
+	msg := sendText("/me waves")
+	assert.Equal(t, "*alice* waves", msg.Text)
+
+	// a doubled slash sends the text as it is
+	msg = sendText("//me waves")
+	assert.Equal(t, "/me waves", msg.Text)
+
+	resp := command("/nick Ally")
+	assert.Equal(t, "You are now known as Ally.", resp.Reply)
+	msg = sendText("hello")
+	assert.Equal(t, "Ally", msg.Nickname)
+
+	// the new topic is announced to the room
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "/topic Release"})
+	var event Event
+	receive(t, bob, &event)
+	assert.Equal(t, Event{Type: topicEvent, Room: models.DefaultRoomID, Username: "alice", Topic: "Release"}, event)
+
+	// the caller gets the event and the response in any order
+	for i := 0; i < 2; i++ {
+		var payload map[string]interface{}
+		receive(t, alice, &payload)
+		assert.Nil(t, payload["error"])
+	}
+
+	resp = command("/topic")
+	assert.Equal(t, "Topic: Release", resp.Reply)
+
+	resp = command("/who")
+	assert.Equal(t, []string{"alice", "bob"}, resp.Users)
+
+	resp = command("/help")
+	assert.Contains(t, resp.Reply, "/roll <dice> — Rolls the dice.")
+
+	resp = command("/roll d20")
+	assert.Equal(t, "You rolled d20.", resp.Reply)
+
+	tests := []struct {
+		name     string
+		text     string
+		wantCode string
+	}{
+		{"Unknown command", "/dance", unknownCommandError},
+		{"Missing arguments", "/me", invalidRequestError},
+		{"Missing arguments of custom command", "/roll", invalidRequestError},
+		{"Username of another user", "/nick bob", invalidRequestError},
+		{"Topic too long", "/topic " + strings.Repeat("a", topicMaxLength+1), invalidRequestError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := command(tt.text)
+			if assert.NotNil(t, resp.Error) {
+				assert.Equal(t, tt.wantCode, resp.Error.Code)
+			}
+		})
+	}
+
+	// replies are not sent to others
+	receiveNothing(t, bob)
+}
+
+func TestHub_Moderation(t *testing.T) {
+	store := newMemoryStore()
+	store.roles["mod"] = models.RoleModerator
+	store.roles["other"] = models.RoleModerator
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	for _, username := range []string{"alice", "bob", "mod", "other"} {
+		err := rooms{store}.Join(models.DefaultRoomID, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := connect(t, ts, "alice")
+	mod := connectAs(t, ts, "mod", models.RoleModerator)
+
+	// command sends the text to the room on behalf of the user
+	// and returns the response to it
+	command := func(conn *websocket.Conn, text string) Response {
+		request(t, conn, Request{ID: text, Action: broadcastAction, Room: models.DefaultRoomID, Message: text})
+
+		var resp Response
+		receive(t, conn, &resp)
+		assert.Equal(t, text, resp.Request.ID)
+
+		return resp
+	}
+
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "hi"})
+	sent, _ := receiveSent(t, alice)
+	var update Update
+	receive(t, mod, &update)
+
+	resp := command(alice, "/mute bob")
+	assert.Equal(t, &Error{Code: forbiddenError, Message: "You are not allowed to run the command."}, resp.Error)
+
+	resp = command(alice, "/help")
+	assert.NotContains(t, resp.Reply, "/mute")
+	resp = command(mod, "/help")
+	assert.Contains(t, resp.Reply, "/mute <username> [duration] [reason]")
+
+	resp = command(mod, "/mute alice 1h flooding")
+	assert.Nil(t, resp.Error)
+	assert.Regexp(t, `^alice is muted until \d{2} \w{3} \d{4} \d{2}:\d{2} UTC: flooding\.$`, resp.Reply)
+
+	// muted users can't send messages
+	resp = command(alice, "hello")
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, forbiddenError, resp.Error.Code)
+		assert.Contains(t, resp.Error.Message, "You are muted until")
+	}
+
+	// nor send direct messages or edit the sent ones
+	request(t, alice, Request{Action: directAction, To: "bob", Message: "hello"})
+	var direct Response
+	receive(t, alice, &direct)
+	if assert.NotNil(t, direct.Error) {
+		assert.Contains(t, direct.Error.Message, "You are muted until")
+	}
+
+	request(t, alice, Request{Action: editAction, MessageID: sent.ID, Message: "spam"})
+	var edit Response
+	receive(t, alice, &edit)
+	if assert.NotNil(t, edit.Error) {
+		assert.Contains(t, edit.Error.Message, "You are muted until")
+	}
+
+	resp = command(mod, "/unmute alice")
+	assert.Equal(t, "alice is no longer muted.", resp.Reply)
+
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "hello"})
+	receiveSent(t, alice)
+	receive(t, mod, &update)
+
+	tests := []struct {
+		name    string
+		text    string
+		wantErr *Error
+	}{
+		{"Missing username", "/kick", &Error{Code: invalidRequestError, Message: "Usage: /kick <username> [reason]"}},
+		{"Nonexistent user", "/ban nobody", &Error{Code: invalidRequestError, Message: "User doesn't exist."}},
+		{"Moderator", "/mute other", &Error{Code: invalidRequestError, Message: "You can only moderate users with lower roles."}},
+		{"Not muted", "/unmute alice", &Error{Code: invalidRequestError, Message: "alice is not muted."}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := command(mod, tt.text)
+			assert.Equal(t, tt.wantErr, resp.Error)
+		})
+	}
+
+	// kicked users are disconnected with the reason
+	resp = command(mod, "/kick alice be nice")
+	assert.Equal(t, "alice is kicked: be nice.", resp.Reply)
+
+	err := alice.SetReadDeadline(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = alice.ReadMessage()
+	var closeErr *websocket.CloseError
+	if assert.ErrorAs(t, err, &closeErr) {
+		assert.Equal(t, kickedCloseCode, closeErr.Code)
+		assert.Equal(t, "You are kicked: be nice.", closeErr.Text)
+	}
+
+	// banned users can't post even if they are still connected elsewhere
+	resp = command(mod, "/ban alice")
+	assert.Equal(t, "alice is banned.", resp.Reply)
+
+	user, err := users{store}.Get("alice")
+	if assert.NoError(t, err) {
+		assert.True(t, user.Banned)
+	}
+
+	resp = command(mod, "/unban alice")
+	assert.Equal(t, "alice is no longer banned.", resp.Reply)
+}
@@ -0,0 +1,32 @@
+package chat
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Maximum number of users that can be mentioned in one message.
+const mentionsMaxCount = 10
+
+// parseMentions returns usernames from the @username tokens of the text
+// in order of appearance without duplicates. Punctuation at the end
+// of the token is not a part of the username.
+func parseMentions(text string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, word := range strings.Fields(text) {
+		if !strings.HasPrefix(word, "@") {
+			continue
+		}
+
+		username := strings.TrimRightFunc(word[1:], unicode.IsPunct)
+		if username == "" || seen[username] {
+			continue
+		}
+
+		seen[username] = true
+		usernames = append(usernames, username)
+	}
+
+	return usernames
+}
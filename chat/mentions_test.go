@@ -0,0 +1,29 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"No mentions", "hello world", nil},
+		{"Single mention", "hi @alice", []string{"alice"}},
+		{"Trailing punctuation", "@alice, @bob! and @eve.", []string{"alice", "bob", "eve"}},
+		{"Duplicates", "@alice @bob @alice", []string{"alice", "bob"}},
+		{"Lone at sign", "meet @ 5", nil},
+		{"Email", "mail me at alice@example.com", nil},
+		{"Inner punctuation", "@a.b_c-d", []string{"a.b_c-d"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseMentions(tt.text))
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package chat
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Maximum length of the reason of the sanction in characters.
+const sanctionReasonMaxLength = 500
+
+// registerModeration adds the commands that moderators use
+// to stop users from disrupting the chat.
+func registerModeration(cs *Commands) {
+	cs.Register(Command{
+		Name:        "mute",
+		Usage:       "/mute <username> [duration] [reason]",
+		Description: "Stops the user from sending messages, for the duration if given, e.g. 30m, 2h or 7d.",
+		Role:        models.RoleModerator,
+		Handler:     runSanction(models.SanctionMute),
+	})
+	cs.Register(Command{
+		Name:        "unmute",
+		Usage:       "/unmute <username>",
+		Description: "Lets the muted user send messages again.",
+		Role:        models.RoleModerator,
+		Handler:     runLift(models.SanctionMute),
+	})
+	cs.Register(Command{
+		Name:        "kick",
+		Usage:       "/kick <username> [reason]",
+		Description: "Disconnects the user, who can connect again.",
+		Role:        models.RoleModerator,
+		Handler:     runKick,
+	})
+	cs.Register(Command{
+		Name:        "ban",
+		Usage:       "/ban <username> [duration] [reason]",
+		Description: "Disconnects the user and stops them from logging in, for the duration if given.",
+		Role:        models.RoleModerator,
+		Handler:     runSanction(models.SanctionBan),
+	})
+	cs.Register(Command{
+		Name:        "unban",
+		Usage:       "/unban <username>",
+		Description: "Lets the banned user log in again.",
+		Role:        models.RoleModerator,
+		Handler:     runLift(models.SanctionBan),
+	})
+}
+
+// runSanction returns the handler imposing the sanction of the kind
+// on the user. Banned users are also kicked.
+func runSanction(kind models.SanctionKind) CommandHandler {
+	return func(call *CommandCall) error {
+		username, args := splitArg(call.Args)
+		if username == "" {
+			return ErrUsage
+		}
+
+		var expires time.Time
+		if first, rest := splitArg(args); first != "" {
+			if d, ok := parseDuration(first); ok {
+				expires = time.Now().UTC().Add(d)
+				args = rest
+			}
+		}
+		if utf8.RuneCountInString(args) > sanctionReasonMaxLength {
+			return CommandError("Reason must be at most 500 characters long.")
+		}
+
+		err := checkTarget(call, username)
+		if err != nil {
+			return err
+		}
+
+		s := models.Sanction{Username: username, Kind: kind, Reason: args, Expires: expires, Creator: call.Username}
+		_, err = call.client.hub.users.Sanction(s)
+		if errors.Is(err, models.ErrInvalidUsername) {
+			return CommandError("User doesn't exist.")
+		} else if err != nil {
+			return err
+		}
+
+		if kind == models.SanctionBan {
+			call.client.hub.Kick(username, "You are banned"+sanctionDetails(s))
+		}
+
+		call.Reply(username + " is " + sanctionPastTense(kind) + sanctionDetails(s))
+		return nil
+	}
+}
+
+// runLift returns the handler lifting the active sanctions
+// of the kind imposed on the user.
+func runLift(kind models.SanctionKind) CommandHandler {
+	return func(call *CommandCall) error {
+		username, rest := splitArg(call.Args)
+		if username == "" || rest != "" {
+			return ErrUsage
+		}
+
+		err := call.client.hub.users.LiftSanction(username, kind)
+		if errors.Is(err, models.ErrNoRecord) {
+			return CommandError(username + " is not " + sanctionPastTense(kind) + ".")
+		} else if err != nil {
+			return err
+		}
+
+		call.Reply(username + " is no longer " + sanctionPastTense(kind) + ".")
+		return nil
+	}
+}
+
+// runKick closes all connections of the user.
+func runKick(call *CommandCall) error {
+	username, reason := splitArg(call.Args)
+	if username == "" {
+		return ErrUsage
+	}
+	if utf8.RuneCountInString(reason) > sanctionReasonMaxLength {
+		return CommandError("Reason must be at most 500 characters long.")
+	}
+
+	err := checkTarget(call, username)
+	if err != nil {
+		return err
+	}
+
+	s := models.Sanction{Reason: reason}
+	call.client.hub.Kick(username, "You are kicked"+sanctionDetails(s))
+
+	call.Reply(username + " is kicked" + sanctionDetails(s))
+	return nil
+}
+
+// checkTarget checks that the user exists and the caller outranks them,
+// so that moderators can't sanction each other or admins.
+func checkTarget(call *CommandCall, username string) error {
+	user, err := call.client.hub.users.Get(username)
+	if errors.Is(err, models.ErrNoRecord) {
+		return CommandError("User doesn't exist.")
+	} else if err != nil {
+		return err
+	}
+
+	if !call.client.user.Role.Outranks(user.Role) {
+		return CommandError("You can only moderate users with lower roles.")
+	}
+
+	return nil
+}
+
+// restriction tells why the user can't send messages,
+// it is nil if the user can.
+func (h *Hub) restriction(username string) *Error {
+	user, err := h.users.Get(username)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		log.Err(err).Msg("error getting user")
+		return &Error{Code: internalError, Message: "Something went wrong, try again later."}
+	}
+	if user.Banned {
+		return &Error{Code: forbiddenError, Message: "You are banned."}
+	}
+
+	mute, err := h.users.ActiveSanction(username, models.SanctionMute)
+	if errors.Is(err, models.ErrNoRecord) {
+		return nil
+	} else if err != nil {
+		log.Err(err).Msg("error getting mute of the user")
+		return &Error{Code: internalError, Message: "Something went wrong, try again later."}
+	}
+
+	return &Error{Code: forbiddenError, Message: "You are muted" + sanctionDetails(mute)}
+}
+
+// sanctionDetails returns the end of the sentence telling
+// when the sanction expires and why it is imposed.
+func sanctionDetails(s models.Sanction) string {
+	var b strings.Builder
+	if !s.Expires.IsZero() {
+		b.WriteString(" until " + s.Expires.UTC().Format("02 Jan 2006 15:04 MST"))
+	}
+	if s.Reason != "" {
+		b.WriteString(": " + s.Reason)
+	}
+	b.WriteString(".")
+
+	return b.String()
+}
+
+func sanctionPastTense(kind models.SanctionKind) string {
+	if kind == models.SanctionBan {
+		return "banned"
+	}
+
+	return string(kind) + "d"
+}
+
+// splitArg returns the first word of the arguments and the rest of them.
+func splitArg(args string) (first, rest string) {
+	args = strings.TrimSpace(args)
+	if i := strings.IndexAny(args, " \t\n"); i >= 0 {
+		return args[:i], strings.TrimSpace(args[i+1:])
+	}
+
+	return args, ""
+}
+
+// parseDuration parses positive durations accepted by time.ParseDuration
+// and numbers of days like "7d".
+func parseDuration(s string) (time.Duration, bool) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 || n > 36500 {
+			return 0, false
+		}
+		return time.Duration(n) * 24 * time.Hour, true
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+
+	return d, true
+}
@@ -0,0 +1,34 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		name   string
+		s      string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"Minutes", "30m", 30 * time.Minute, true},
+		{"Hours and minutes", "1h30m", 90 * time.Minute, true},
+		{"Days", "7d", 7 * 24 * time.Hour, true},
+		{"Zero", "0s", 0, false},
+		{"Negative", "-1h", 0, false},
+		{"Zero days", "0d", 0, false},
+		{"Word", "spam", 0, false},
+		{"Word ending with d", "flood", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, ok := parseDuration(tt.s)
+			assert.Equal(t, tt.want, d)
+			assert.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package chat
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// Period of announcing users online on the instance to other instances.
+	presenceInterval = 30 * time.Second
+
+	// Time after which users announced by another instance are considered
+	// offline, unless the instance announces them again. Protects from
+	// instances that have gone away without telling it.
+	presenceTimeout = 3 * presenceInterval
+
+	// Maximum number of users in one announcement, keeps envelopes
+	// small enough for the Broker.
+	presenceChunkSize = 100
+)
+
+// presence tells other instances about users online on the instance
+// that has published it.
+type presence struct {
+	Usernames []string `json:"usernames,omitempty"`
+	Online    bool     `json:"online"`
+
+	// Asks other instances to announce their online users.
+	// Sent by the instance when it starts.
+	Hello bool `json:"hello,omitempty"`
+}
+
+// connect counts the new local connection of the user and
+// announces that the user is online on the first one.
+func (h *Hub) connect(username string) {
+	wasOnline := h.isOnline(username)
+	h.connections[username]++
+	if h.connections[username] == 1 {
+		h.publish(envelope{Presence: &presence{Usernames: []string{username}, Online: true}})
+	}
+	if !wasOnline {
+		h.deliverPresence(username, true)
+	}
+}
+
+// disconnect counts the closed local connection of the user and
+// announces that the user is offline after the last one.
+func (h *Hub) disconnect(username string) {
+	h.connections[username]--
+	if h.connections[username] > 0 {
+		return
+	}
+
+	delete(h.connections, username)
+	h.publish(envelope{Presence: &presence{Usernames: []string{username}, Online: false}})
+	if !h.isOnline(username) {
+		h.deliverPresence(username, false)
+	}
+}
+
+// isOnline reports whether the user is connected to any instance.
+func (h *Hub) isOnline(username string) bool {
+	return h.connections[username] > 0 || len(h.remoteOnline[username]) > 0
+}
+
+// applyPresence updates users online on another instance.
+func (h *Hub) applyPresence(node string, p presence) {
+	if p.Hello {
+		h.announce()
+		return
+	}
+
+	for _, username := range p.Usernames {
+		wasOnline := h.isOnline(username)
+		if p.Online {
+			if h.remoteOnline[username] == nil {
+				h.remoteOnline[username] = make(map[string]time.Time)
+			}
+			h.remoteOnline[username][node] = time.Now().Add(presenceTimeout)
+		} else {
+			h.forgetRemote(username, node)
+		}
+
+		if online := h.isOnline(username); online != wasOnline {
+			h.deliverPresence(username, online)
+		}
+	}
+}
+
+// announce publishes all users online on the instance.
+func (h *Hub) announce() {
+	usernames := make([]string, 0, len(h.connections))
+	for username := range h.connections {
+		usernames = append(usernames, username)
+	}
+
+	for len(usernames) > 0 {
+		n := presenceChunkSize
+		if n > len(usernames) {
+			n = len(usernames)
+		}
+
+		h.publish(envelope{Presence: &presence{Usernames: usernames[:n], Online: true}})
+		usernames = usernames[n:]
+	}
+}
+
+// sweepPresence forgets users of the instances that haven't
+// announced them for too long.
+func (h *Hub) sweepPresence(now time.Time) {
+	for username, nodes := range h.remoteOnline {
+		for node, until := range nodes {
+			if now.Before(until) {
+				continue
+			}
+
+			h.forgetRemote(username, node)
+			if !h.isOnline(username) {
+				h.deliverPresence(username, false)
+			}
+		}
+	}
+}
+
+func (h *Hub) forgetRemote(username, node string) {
+	delete(h.remoteOnline[username], node)
+	if len(h.remoteOnline[username]) == 0 {
+		delete(h.remoteOnline, username)
+	}
+}
+
+// deliverPresence notifies the local clients that have requested
+// the list of online users that the user has come online or gone offline.
+func (h *Hub) deliverPresence(username string, online bool) {
+	e := Event{Type: presenceEvent, Username: username, Online: online}
+	for client := range h.clients {
+		if !client.presence {
+			continue
+		}
+
+		select {
+		case client.sendEvent <- e:
+		default:
+			h.removeClient(client)
+		}
+	}
+}
+
+// onlineUsers returns sorted usernames of the users connected to any instance.
+func (h *Hub) onlineUsers() []string {
+	usernames := make([]string, 0, len(h.connections)+len(h.remoteOnline))
+	for username := range h.connections {
+		usernames = append(usernames, username)
+	}
+	for username := range h.remoteOnline {
+		if h.connections[username] == 0 {
+			usernames = append(usernames, username)
+		}
+	}
+	sort.Strings(usernames)
+
+	return usernames
+}
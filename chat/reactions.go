@@ -0,0 +1,31 @@
+package chat
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Maximum length of the reaction emoji in bytes. Emoji can be made
+// of several code points, e.g. flags or skin tones.
+const reactionMaxLength = 32
+
+// validEmoji reports whether the text can be used as a reaction.
+// Emoji are not validated strictly: any short text without letters,
+// spaces and control characters that is not plain ASCII is accepted.
+func validEmoji(text string) bool {
+	if text == "" || len(text) > reactionMaxLength || !utf8.ValidString(text) {
+		return false
+	}
+
+	ascii := true
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+		if r > unicode.MaxASCII {
+			ascii = false
+		}
+	}
+
+	return !ascii
+}
@@ -0,0 +1,32 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidEmoji(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want bool
+	}{
+		{"Emoji", "👍", true},
+		{"Skin tone", "👍🏽", true},
+		{"Flag", "🇺🇦", true},
+		{"Keycap", "1️⃣", true},
+		{"Empty", "", false},
+		{"Word", "like", false},
+		{"Non-latin letter", "ж", false},
+		{"ASCII", ":)", false},
+		{"Emoji with space", "👍 ", false},
+		{"Too long", "👍👍👍👍👍👍👍👍👍", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, validEmoji(tt.text))
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHub_RoomBroadcast(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	for _, username := range []string{"alice", "bob"} {
+		err := rooms{store}.Join(models.DefaultRoomID, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	random, err := rooms{store}.Insert("random", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := connect(t, ts, "alice")
+	bob := connect(t, ts, "bob")
+
+	request(t, alice, Request{Action: broadcastAction, Room: models.DefaultRoomID, Message: "hi all"})
+
+	msg, _ := receiveSent(t, alice)
+	assert.Equal(t, "hi all", msg.Text)
+
+	var update Update
+	receive(t, bob, &update)
+	if assert.Len(t, update.Messages, 1) {
+		assert.Equal(t, int64(models.DefaultRoomID), update.Messages[0].Room)
+		assert.Equal(t, "hi all", update.Messages[0].Text)
+		assert.Equal(t, "alice", update.Messages[0].Username)
+	}
+
+	// messages of the room are not sent to the users outside of it
+	request(t, bob, Request{Action: broadcastAction, Room: random, Message: "hi me"})
+
+	msg, _ = receiveSent(t, bob)
+	assert.Equal(t, random, msg.Room)
+	assert.Equal(t, "hi me", msg.Text)
+
+	// and can't be sent by them
+	request(t, alice, Request{Action: broadcastAction, Room: random, Message: "let me in"})
+
+	var resp Response
+	receive(t, alice, &resp)
+	assert.Equal(t, &Error{Code: forbiddenError, Message: "You are not a member of the room."}, resp.Error)
+
+	receiveNothing(t, alice)
+	receiveNothing(t, bob)
+}
+
+func TestHub_JoinAndLeave(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	random, err := rooms{store}.Insert("random", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob := connect(t, ts, "bob")
+	// both connections of the user are subscribed to the joined room
+	alice := connect(t, ts, "alice")
+	alicePhone := connect(t, ts, "alice")
+
+	request(t, alice, Request{Action: joinRoomAction, Room: random})
+
+	var resp Response
+	receive(t, alice, &resp)
+	assert.Empty(t, resp.Error)
+	assert.Contains(t, resp.Rooms, Room{ID: random, Name: "random", Joined: true})
+
+	request(t, bob, Request{Action: broadcastAction, Room: random, Message: "welcome"})
+
+	receiveSent(t, bob)
+	for _, conn := range []*websocket.Conn{alice, alicePhone} {
+		var update Update
+		receive(t, conn, &update)
+		if assert.Len(t, update.Messages, 1) {
+			assert.Equal(t, "welcome", update.Messages[0].Text)
+		}
+	}
+
+	request(t, alicePhone, Request{Action: leaveRoomAction, Room: random})
+
+	resp = Response{}
+	receive(t, alicePhone, &resp)
+	assert.Empty(t, resp.Error)
+	assert.Contains(t, resp.Rooms, Room{ID: random, Name: "random", Joined: false})
+
+	request(t, bob, Request{Action: broadcastAction, Room: random, Message: "bye"})
+
+	msg, _ := receiveSent(t, bob)
+	assert.Equal(t, "bye", msg.Text)
+
+	request(t, alice, Request{Action: joinRoomAction, Room: 42})
+
+	resp = Response{}
+	receive(t, alice, &resp)
+	assert.Equal(t, &Error{Code: notFoundError, Message: "Room doesn't exist."}, resp.Error)
+
+	receiveNothing(t, alice)
+	receiveNothing(t, alicePhone)
+}
+
+func TestHub_LoadMore(t *testing.T) {
+	store := newMemoryStore()
+	ts := newTestHubServer(t, NewHub(store, rooms{store}, users{store}, HubOptions{}))
+
+	err := rooms{store}.Join(models.DefaultRoomID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	random, err := rooms{store}.Insert("random", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2021, 6, 13, 12, 0, 0, 0, time.UTC)
+	for _, m := range []struct {
+		room int64
+		text string
+	}{
+		{models.DefaultRoomID, "first"},
+		{random, "elsewhere"},
+		{models.DefaultRoomID, "second"},
+		{models.DefaultRoomID, "third"},
+	} {
+		_, err := store.Insert(m.room, 0, m.text, "bob", "", nil, created)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := connect(t, ts, "alice")
+
+	tests := []struct {
+		name      string
+		room      int64
+		before    int64
+		wantTexts []string
+		wantError *Error
+	}{
+		{name: "Latest", room: models.DefaultRoomID, wantTexts: []string{"third", "second", "first"}},
+		{name: "Before", room: models.DefaultRoomID, before: 4, wantTexts: []string{"second", "first"}},
+		{name: "Before the first", room: models.DefaultRoomID, before: 1},
+		{name: "Not a member", room: random, wantError: &Error{Code: forbiddenError, Message: "You are not a member of the room."}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request(t, alice, Request{Action: loadMoreAction, Room: tt.room, Before: tt.before})
+
+			var resp Response
+			receive(t, alice, &resp)
+			assert.Equal(t, tt.wantError, resp.Error)
+
+			var texts []string
+			for _, m := range resp.Messages {
+				assert.Equal(t, tt.room, m.Room)
+				texts = append(texts, m.Text)
+			}
+			assert.Equal(t, tt.wantTexts, texts)
+		})
+	}
+}
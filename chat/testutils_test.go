@@ -0,0 +1,756 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// memoryStore keeps messages and rooms in memory. It implements
+// storage interfaces required by the Hub.
+type memoryStore struct {
+	mu       sync.Mutex
+	messages []models.Message
+	rooms    []models.Room
+	members  map[int64]map[string]bool
+	direct   map[[2]string]int64
+	reads    map[int64]map[string]int64
+
+	// users who have reacted to the messages by emoji
+	reactions map[int64]map[string]map[string]bool
+
+	// uploaded attachments by ids
+	uploads map[int64]models.Attachment
+
+	// previews of the pages by their URLs
+	previews map[string]models.Preview
+
+	// nicknames of the users by usernames
+	nicknames map[string]string
+
+	// usernames of the bots
+	bots map[string]bool
+
+	// roles of the users by usernames, members are omitted
+	roles map[string]models.Role
+
+	// active mutes and bans of the users, expiry is left to the SQL
+	sanctions map[models.SanctionKind]map[string]models.Sanction
+
+	// the last query passed to Search
+	searched models.SearchQuery
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		rooms:     []models.Room{{ID: models.DefaultRoomID, Name: "general"}},
+		members:   map[int64]map[string]bool{models.DefaultRoomID: {}},
+		direct:    make(map[[2]string]int64),
+		reads:     make(map[int64]map[string]int64),
+		reactions: make(map[int64]map[string]map[string]bool),
+		uploads:   make(map[int64]models.Attachment),
+		previews:  make(map[string]models.Preview),
+		nicknames: make(map[string]string),
+		bots:      make(map[string]bool),
+		roles:     make(map[string]models.Role),
+		sanctions: map[models.SanctionKind]map[string]models.Sanction{
+			models.SanctionMute: {},
+			models.SanctionBan:  {},
+		},
+	}
+}
+
+func (s *memoryStore) Insert(roomID, parentID int64, text, username, key string, attachments []int64,
+	created time.Time) (models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if parentID != 0 {
+		if parentID > int64(len(s.messages)) || s.messages[parentID-1].RoomID != roomID {
+			return models.Message{}, models.ErrInvalidParent
+		}
+		if p := s.messages[parentID-1].ParentID; p != 0 {
+			parentID = p
+		}
+	}
+
+	for _, m := range s.messages {
+		if key != "" && m.Username == username && m.Key == key {
+			return m, models.ErrDuplicateKey
+		}
+	}
+
+	var attached []models.Attachment
+	for _, id := range attachments {
+		a, ok := s.uploads[id]
+		if !ok || a.Username != username || a.MessageID != 0 {
+			return models.Message{}, models.ErrInvalidAttachment
+		}
+		attached = append(attached, a)
+	}
+	for i := range attached {
+		attached[i].MessageID = int64(len(s.messages) + 1)
+		s.uploads[attached[i].ID] = attached[i]
+	}
+
+	msg := models.Message{
+		ID:          int64(len(s.messages) + 1),
+		RoomID:      roomID,
+		Username:    username,
+		Nickname:    s.nicknames[username],
+		Bot:         s.bots[username],
+		Text:        text,
+		Created:     created,
+		Key:         key,
+		ParentID:    parentID,
+		Attachments: attached,
+	}
+	s.messages = append(s.messages, msg)
+	if parentID != 0 {
+		s.messages[parentID-1].Replies++
+	}
+
+	return msg, nil
+}
+
+func (s *memoryStore) Get(id int64) (models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 1 || int(id) > len(s.messages) {
+		return models.Message{}, models.ErrNoRecord
+	}
+
+	return s.messages[id-1], nil
+}
+
+// message returns the message with the id unless it doesn't exist
+// or is deleted. It must be called with the mutex locked.
+func (s *memoryStore) message(id int64) (*models.Message, error) {
+	if id < 1 || int(id) > len(s.messages) || s.messages[id-1].Deleted {
+		return nil, models.ErrNoRecord
+	}
+
+	return &s.messages[id-1], nil
+}
+
+func (s *memoryStore) Thread(id int64, n int) ([]models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 1 || int(id) > len(s.messages) || s.messages[id-1].ParentID != 0 {
+		return nil, models.ErrNoRecord
+	}
+
+	var replies []models.Message
+	for _, m := range s.messages {
+		if m.ParentID == id {
+			replies = append(replies, m)
+		}
+	}
+	if len(replies) > n {
+		replies = replies[len(replies)-n:]
+	}
+
+	return append([]models.Message{s.messages[id-1]}, replies...), nil
+}
+
+func (s *memoryStore) Before(roomID, id int64, n int) ([]models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var messages []models.Message
+	for i := len(s.messages) - 1; i >= 0 && len(messages) < n; i-- {
+		m := s.messages[i]
+		if m.RoomID == roomID && m.ParentID == 0 && (id == 0 || m.ID < id) {
+			messages = append(messages, m)
+		}
+	}
+
+	return messages, nil
+}
+
+// After returns the messages stored after the one with the id. Rooms
+// of the user and replies are filtered by the SQL and aren't checked here.
+func (s *memoryStore) After(username string, id int64, n int) ([]models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var messages []models.Message
+	for i := int(id); i < len(s.messages) && len(messages) < n; i++ {
+		messages = append(messages, s.messages[i])
+	}
+
+	return messages, nil
+}
+
+// Changed returns the edited messages up to the one with the id.
+func (s *memoryStore) Changed(username string, id int64, n int) ([]models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var messages []models.Message
+	for i := 0; i < int(id) && i < len(s.messages) && len(messages) < n; i++ {
+		if !s.messages[i].Edited.IsZero() {
+			messages = append(messages, s.messages[i])
+		}
+	}
+
+	return messages, nil
+}
+
+func (s *memoryStore) Mention(id int64, usernames []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 1 || int(id) > len(s.messages) {
+		return models.ErrNoRecord
+	}
+	s.messages[id-1].Mentions = usernames
+
+	return nil
+}
+
+func (s *memoryStore) React(id int64, username, emoji string) (models.Message, error) {
+	return s.react(id, func(reactions map[string]map[string]bool) {
+		if reactions[emoji] == nil {
+			reactions[emoji] = make(map[string]bool)
+		}
+		reactions[emoji][username] = true
+	})
+}
+
+func (s *memoryStore) Unreact(id int64, username, emoji string) (models.Message, error) {
+	return s.react(id, func(reactions map[string]map[string]bool) {
+		delete(reactions[emoji], username)
+		if len(reactions[emoji]) == 0 {
+			delete(reactions, emoji)
+		}
+	})
+}
+
+// react changes reactions to the message and aggregates them.
+func (s *memoryStore) react(id int64, change func(reactions map[string]map[string]bool)) (models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, err := s.message(id)
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	if s.reactions[id] == nil {
+		s.reactions[id] = make(map[string]map[string]bool)
+	}
+	change(s.reactions[id])
+
+	var aggregated []models.Reaction
+	for emoji, users := range s.reactions[id] {
+		r := models.Reaction{Emoji: emoji, Count: len(users)}
+		for username := range users {
+			r.Usernames = append(r.Usernames, username)
+		}
+		sort.Strings(r.Usernames)
+		aggregated = append(aggregated, r)
+	}
+	sort.Slice(aggregated, func(i, j int) bool { return aggregated[i].Emoji < aggregated[j].Emoji })
+	m.Reactions = aggregated
+
+	return *m, nil
+}
+
+func (s *memoryStore) AddPreviews(id int64, urls []string) (models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, err := s.message(id)
+	if err != nil {
+		return models.Message{}, err
+	}
+	for _, url := range urls {
+		p, ok := s.previews[url]
+		if !ok {
+			return models.Message{}, models.ErrNoRecord
+		}
+		m.Previews = append(m.Previews, p)
+	}
+
+	return *m, nil
+}
+
+// Unfurl returns the preview of the URL kept in the store,
+// so that the store can serve as the Unfurler.
+func (s *memoryStore) Unfurl(_ context.Context, url string) (models.Preview, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.previews[url]
+	if !ok {
+		return models.Preview{}, errors.New("no preview")
+	}
+
+	return p, nil
+}
+
+// Search finds messages that contain the query text and remembers
+// the query. Filters are applied by the SQL and aren't checked here.
+func (s *memoryStore) Search(username string, q models.SearchQuery, n int) ([]models.SearchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.searched = q
+
+	var results []models.SearchResult
+	for _, m := range s.messages {
+		if len(results) == n {
+			break
+		}
+		if !strings.Contains(m.Text, q.Text) {
+			continue
+		}
+
+		headline := strings.ReplaceAll(html.EscapeString(m.Text), q.Text, "<mark>"+q.Text+"</mark>")
+		results = append(results, models.SearchResult{Message: m, Headline: headline, Rank: 1})
+	}
+
+	return results, nil
+}
+
+func (s *memoryStore) Edit(id int64, username, text string, edited time.Time) (models.Message, error) {
+	return s.update(id, username, func(m *models.Message) {
+		m.Text = text
+		m.Edited = edited
+		m.Mentions = nil
+		m.Previews = nil
+	})
+}
+
+func (s *memoryStore) Delete(id int64, username string) (models.Message, error) {
+	return s.update(id, username, func(m *models.Message) {
+		m.Deleted = true
+	})
+}
+
+func (s *memoryStore) Remove(id int64) (models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, err := s.message(id)
+	if err != nil {
+		return models.Message{}, err
+	}
+	m.Deleted = true
+
+	return *m, nil
+}
+
+func (s *memoryStore) update(id int64, username string, change func(m *models.Message)) (models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, err := s.message(id)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if m.Username != username {
+		return models.Message{}, models.ErrNotAuthor
+	}
+	change(m)
+
+	return *m, nil
+}
+
+// rooms implements RoomInterface on top of the memoryStore.
+type rooms struct {
+	*memoryStore
+}
+
+func (s rooms) Insert(name, creator string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := int64(len(s.memoryStore.rooms) + 1)
+	s.memoryStore.rooms = append(s.memoryStore.rooms, models.Room{ID: id, Name: name, Creator: creator})
+	s.members[id] = map[string]bool{creator: true}
+
+	return id, nil
+}
+
+func (s rooms) Get(id int64) (models.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 1 || int(id) > len(s.memoryStore.rooms) {
+		return models.Room{}, models.ErrNoRecord
+	}
+
+	return s.memoryStore.rooms[id-1], nil
+}
+
+func (s rooms) SetTopic(id int64, topic string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 1 || int(id) > len(s.memoryStore.rooms) {
+		return models.ErrNoRecord
+	}
+	s.memoryStore.rooms[id-1].Topic = topic
+
+	return nil
+}
+
+func (s rooms) All() ([]models.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var all []models.Room
+	for _, r := range s.memoryStore.rooms {
+		if !r.Direct {
+			all = append(all, r)
+		}
+	}
+
+	return all, nil
+}
+
+func (s rooms) Joined(username string) ([]models.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var joined []models.Room
+	for _, r := range s.memoryStore.rooms {
+		if s.members[r.ID][username] {
+			joined = append(joined, r)
+		}
+	}
+
+	return joined, nil
+}
+
+func (s rooms) Direct(username, peer string) (int64, error) {
+	if peer == "" {
+		return 0, models.ErrInvalidUsername
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pair := [2]string{username, peer}
+	if username > peer {
+		pair = [2]string{peer, username}
+	}
+
+	id, ok := s.direct[pair]
+	if !ok {
+		id = int64(len(s.memoryStore.rooms) + 1)
+		s.memoryStore.rooms = append(s.memoryStore.rooms, models.Room{ID: id, Direct: true})
+		s.members[id] = make(map[string]bool)
+		s.direct[pair] = id
+	}
+	s.members[id][username] = true
+	s.members[id][peer] = true
+
+	return id, nil
+}
+
+func (s rooms) Join(roomID int64, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.members[roomID] == nil {
+		return models.ErrInvalidRoom
+	}
+	s.members[roomID][username] = true
+
+	return nil
+}
+
+func (s rooms) Leave(roomID int64, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.members[roomID][username] {
+		return models.ErrNoRecord
+	}
+	delete(s.members[roomID], username)
+
+	return nil
+}
+
+func (s rooms) IsMember(roomID int64, username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.members[roomID][username], nil
+}
+
+func (s rooms) Members(roomID int64, usernames []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var members []string
+	for _, username := range usernames {
+		if s.members[roomID][username] {
+			members = append(members, username)
+		}
+	}
+	sort.Strings(members)
+
+	return members, nil
+}
+
+func (s rooms) MarkRead(roomID int64, username string, messageID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.members[roomID][username] || messageID < 1 || int(messageID) > len(s.messages) ||
+		s.messages[messageID-1].RoomID != roomID {
+		return models.ErrNoRecord
+	}
+
+	if s.reads[roomID] == nil {
+		s.reads[roomID] = make(map[string]int64)
+	}
+	if messageID > s.reads[roomID][username] {
+		s.reads[roomID][username] = messageID
+	}
+
+	return nil
+}
+
+// users implements UserInterface on top of the memoryStore.
+// Members of any room exist.
+type users struct {
+	*memoryStore
+}
+
+func (s users) Get(username string) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, members := range s.members {
+		if members[username] {
+			role := s.roles[username]
+			if role == "" {
+				role = models.RoleMember
+			}
+			_, banned := s.sanctions[models.SanctionBan][username]
+			return models.User{
+				Username: username,
+				Nickname: s.nicknames[username],
+				Bot:      s.bots[username],
+				Role:     role,
+				Banned:   banned,
+			}, nil
+		}
+	}
+
+	return models.User{}, models.ErrNoRecord
+}
+
+func (s users) SetNickname(username, nickname string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if nickname == "" {
+		delete(s.nicknames, username)
+	} else {
+		s.nicknames[username] = nickname
+	}
+
+	return nil
+}
+
+func (s users) Sanction(sanction models.Sanction) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sanction.ID = int64(len(s.sanctions[sanction.Kind]) + 1)
+	sanction.Created = time.Now().UTC()
+	s.sanctions[sanction.Kind][sanction.Username] = sanction
+
+	return sanction.ID, nil
+}
+
+func (s users) ActiveSanction(username string, kind models.SanctionKind) (models.Sanction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sanction, ok := s.sanctions[kind][username]
+	if !ok {
+		return models.Sanction{}, models.ErrNoRecord
+	}
+
+	return sanction, nil
+}
+
+func (s users) LiftSanction(username string, kind models.SanctionKind) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sanctions[kind][username]; !ok {
+		return models.ErrNoRecord
+	}
+	delete(s.sanctions[kind], username)
+
+	return nil
+}
+
+// memoryBroker connects instances of the Hub in the same process.
+type memoryBroker struct {
+	mu        sync.Mutex
+	instances []chan []byte
+}
+
+// connect returns Broker for a new instance of the Hub.
+func (b *memoryBroker) connect() Broker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	messages := make(chan []byte, 256)
+	b.instances = append(b.instances, messages)
+
+	return memoryBrokerInstance{broker: b, messages: messages}
+}
+
+type memoryBrokerInstance struct {
+	broker   *memoryBroker
+	messages chan []byte
+}
+
+func (i memoryBrokerInstance) Publish(payload []byte) error {
+	// PostgreSQL rejects notifications this long
+	if len(payload) >= 8000 {
+		return errors.New("payload is too long")
+	}
+
+	i.broker.mu.Lock()
+	defer i.broker.mu.Unlock()
+
+	for _, messages := range i.broker.instances {
+		messages <- payload
+	}
+
+	return nil
+}
+
+func (i memoryBrokerInstance) Messages() <-chan []byte {
+	return i.messages
+}
+
+// newTestHubServer starts the hub and a test server that accepts
+// websocket connections on behalf of the user from the "user" query parameter
+// with the role from the "role" one.
+func newTestHubServer(t *testing.T, hub *Hub) *httptest.Server {
+	go hub.Run()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := models.User{Username: r.URL.Query().Get("user"), Role: models.Role(r.URL.Query().Get("role"))}
+		ServeWS(hub, w, r.WithContext(context.WithValue(r.Context(), ContextUserKey, user)))
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+// connect connects to the test server as the member and waits
+// until the client is registered in the hub.
+func connect(t *testing.T, ts *httptest.Server, username string) *websocket.Conn {
+	return connectAs(t, ts, username, models.RoleMember)
+}
+
+// connectAs connects to the test server as the user with the role.
+func connectAs(t *testing.T, ts *httptest.Server, username string, role models.Role) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?user=" + username + "&role=" + string(role)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	// client is registered before it starts reading requests,
+	// so getting any response means that it is ready
+	request(t, conn, Request{Action: listRoomsAction})
+	var resp Response
+	receive(t, conn, &resp)
+
+	return conn
+}
+
+// request sends the request to the hub.
+func request(t *testing.T, conn *websocket.Conn, req Request) {
+	err := conn.WriteJSON(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receive reads the next payload from the connection into v.
+func receive(t *testing.T, conn *websocket.Conn, v interface{}) {
+	err := conn.SetReadDeadline(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receiveNothing checks that nothing is sent to the connection for a while.
+// The connection can't be read from afterwards.
+func receiveNothing(t *testing.T, conn *websocket.Conn) {
+	err := conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, data, err := conn.ReadMessage()
+	if err == nil {
+		t.Fatalf("unexpected payload: %s", data)
+	}
+}
+
+// receiveSent reads the update with the message sent by the client
+// and the acknowledgement of it, which can come in any order.
+// Other responses to the request are skipped.
+func receiveSent(t *testing.T, conn *websocket.Conn) (Message, Ack) {
+	var (
+		msg *Message
+		ack *Ack
+	)
+	for msg == nil || ack == nil {
+		// updates are told apart from responses by the missing request
+		var payload Response
+		receive(t, conn, &payload)
+
+		switch {
+		case payload.Request.Action == "" && len(payload.Messages) == 1:
+			msg = &payload.Messages[0]
+		case payload.Ack != nil:
+			ack = payload.Ack
+		case payload.Error != nil:
+			t.Fatalf("unexpected error: %s", payload.Error.Message)
+		}
+	}
+
+	return *msg, *ack
+}
@@ -0,0 +1,318 @@
+// Package markdown renders the subset of Markdown supported in messages:
+// **bold**, *italics*, `inline code`, fenced code blocks, [links](url)
+// and > quotes. Bare http and https URLs are turned into links as well.
+//
+// The renderer never copies HTML from the source. All text is escaped,
+// and the only tags in the result are strong, em, code, pre, blockquote,
+// br and a, with href limited to http, https and mailto URLs.
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Quotes nested deeper are rendered as text.
+const maxQuoteDepth = 5
+
+// Characters that are shown as they are when preceded by a backslash.
+const escapable = "\\`*_[]()>"
+
+// Render returns HTML of the source.
+func Render(src string) string {
+	r := render(src)
+	return r.b.String()
+}
+
+// Links returns distinct URLs linked from the source in order
+// of appearance. URLs in code are not links.
+func Links(src string) []string {
+	r := render(src)
+
+	var links []string
+	seen := make(map[string]bool)
+	for _, link := range r.links {
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+
+	return links
+}
+
+// Escape returns the text with Markdown punctuation escaped,
+// so that it is rendered as it is.
+func Escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune(escapable, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// renderer accumulates HTML and the links in it.
+type renderer struct {
+	b     strings.Builder
+	links []string
+}
+
+func render(src string) *renderer {
+	r := &renderer{}
+	r.blocks(strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n"), 0)
+
+	return r
+}
+
+// blocks renders lines as code blocks, quotes and text
+// with lines separated by line breaks.
+func (r *renderer) blocks(lines []string, depth int) {
+	// blank lines around blocks are not shown
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	// whether the previous line was text and needs a line break
+	text := false
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case strings.HasPrefix(line, "```"):
+			// the block lasts until the closing fence or the end of the message
+			end := i + 1
+			for end < len(lines) && !strings.HasPrefix(lines[end], "```") {
+				end++
+			}
+
+			r.b.WriteString("<pre><code>")
+			r.b.WriteString(html.EscapeString(strings.Join(lines[i+1:end], "\n")))
+			r.b.WriteString("</code></pre>")
+			text = false
+			i = end + 1
+		case strings.HasPrefix(line, ">") && depth < maxQuoteDepth:
+			var quoted []string
+			for ; i < len(lines) && strings.HasPrefix(lines[i], ">"); i++ {
+				l := strings.TrimPrefix(lines[i], ">")
+				quoted = append(quoted, strings.TrimPrefix(l, " "))
+			}
+
+			r.b.WriteString("<blockquote>")
+			r.blocks(quoted, depth+1)
+			r.b.WriteString("</blockquote>")
+			text = false
+		default:
+			if text {
+				r.b.WriteString("<br>")
+			}
+			r.inline(line, true)
+			text = true
+			i++
+		}
+	}
+}
+
+// inline renders emphasis, code and links of the line.
+// Bare URLs are linked unless the text is a link already.
+func (r *renderer) inline(s string, autolink bool) {
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(escapable, s[i+1]) >= 0:
+			r.b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '`':
+			if end := strings.IndexByte(s[i+1:], '`'); end > 0 {
+				r.b.WriteString("<code>")
+				r.b.WriteString(html.EscapeString(s[i+1 : i+1+end]))
+				r.b.WriteString("</code>")
+				i += end + 2
+				continue
+			}
+		case c == '*' && strings.HasPrefix(s[i:], "**"):
+			if end := closing(s, i, "**"); end >= 0 {
+				r.b.WriteString("<strong>")
+				r.inline(s[i+2:end], autolink)
+				r.b.WriteString("</strong>")
+				i = end + 2
+				continue
+			}
+		case c == '*' || c == '_':
+			if end := closing(s, i, s[i:i+1]); end >= 0 {
+				r.b.WriteString("<em>")
+				r.inline(s[i+1:end], autolink)
+				r.b.WriteString("</em>")
+				i = end + 1
+				continue
+			}
+		case c == '[' && autolink:
+			if text, href, end, ok := link(s, i); ok {
+				r.link(href)
+				r.inline(text, false)
+				r.b.WriteString("</a>")
+				i = end
+				continue
+			}
+		case (c == 'h' || c == 'H') && autolink && (i == 0 || !isWordEnd(s[:i])):
+			if end := bareURL(s, i); end > 0 {
+				r.link(s[i:end])
+				r.b.WriteString(html.EscapeString(s[i:end]))
+				r.b.WriteString("</a>")
+				i = end
+				continue
+			}
+		}
+
+		_, size := utf8.DecodeRuneInString(s[i:])
+		r.b.WriteString(html.EscapeString(s[i : i+size]))
+		i += size
+	}
+}
+
+// link opens the anchor linking to the URL.
+func (r *renderer) link(href string) {
+	r.links = append(r.links, href)
+	r.b.WriteString(`<a href="`)
+	r.b.WriteString(html.EscapeString(href))
+	r.b.WriteString(`" rel="noopener nofollow" target="_blank">`)
+}
+
+// bareURL returns the index after the http or https URL at i,
+// or 0 if there is none. Punctuation at the end of the URL,
+// such as the period ending the sentence, is not part of it.
+func bareURL(s string, i int) int {
+	lower := strings.ToLower(s[i:min(len(s), i+8)])
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return 0
+	}
+
+	end := i
+	for end < len(s) {
+		r, size := utf8.DecodeRuneInString(s[end:])
+		if unicode.IsSpace(r) || strings.ContainsRune("<>\"`", r) {
+			break
+		}
+		end += size
+	}
+
+	for end > i {
+		last := s[end-1]
+		// parentheses are kept if they are balanced, as in Wikipedia links
+		if last == ')' && strings.Count(s[i:end], "(") >= strings.Count(s[i:end], ")") {
+			break
+		}
+		if !strings.ContainsRune(".,:;!?'*_)", rune(last)) {
+			break
+		}
+		end--
+	}
+
+	if !SafeURL(s[i:end]) {
+		return 0
+	}
+
+	return end
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// closing returns the index of the delimiter closing the one at i,
+// or -1 if there is none. Delimiters must be next to the emphasized text
+// and, unlike in snake_case or 2*3*4, not inside words.
+func closing(s string, i int, delim string) int {
+	start := i + len(delim)
+	if start >= len(s) || isSpace(s[start:]) || (i > 0 && isWordEnd(s[:i])) {
+		return -1
+	}
+
+	for j := start + 1; j+len(delim) <= len(s); j++ {
+		if !strings.HasPrefix(s[j:], delim) || isSpaceEnd(s[:j]) {
+			continue
+		}
+		if j+len(delim) < len(s) && isWord(s[j+len(delim):]) {
+			continue
+		}
+		// the delimiter is part of a longer one, e.g. ** when looking for *
+		if strings.HasPrefix(s[j+len(delim):], delim[:1]) {
+			continue
+		}
+		return j
+	}
+
+	return -1
+}
+
+// link parses [text](href) at i and returns the index after it.
+// Links to URLs with other schemes than allowed are rendered as text.
+func link(s string, i int) (text, href string, end int, ok bool) {
+	closeText := strings.Index(s[i:], "](")
+	if closeText < 0 {
+		return "", "", 0, false
+	}
+	closeText += i
+	closeHref := strings.IndexByte(s[closeText+2:], ')')
+	if closeHref < 0 {
+		return "", "", 0, false
+	}
+	closeHref += closeText + 2
+
+	text = s[i+1 : closeText]
+	href = strings.TrimSpace(s[closeText+2 : closeHref])
+	if text == "" || !SafeURL(href) {
+		return "", "", 0, false
+	}
+
+	return text, href, closeHref + 1, true
+}
+
+// SafeURL reports whether the URL can be linked to.
+func SafeURL(href string) bool {
+	u, err := url.Parse(href)
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	default:
+		return false
+	}
+}
+
+func isSpace(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsSpace(r)
+}
+
+func isSpaceEnd(s string) bool {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return unicode.IsSpace(r)
+}
+
+func isWord(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isWordEnd(s string) bool {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,112 @@
+package markdown
+
+import (
+	"html"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"Plain text", "hello world", "hello world"},
+		{"Line breaks", "first\nsecond\r\n\nthird", "first<br>second<br><br>third"},
+		{"Bold", "**bold** text", "<strong>bold</strong> text"},
+		{"Italics", "*one* and _two_", "<em>one</em> and <em>two</em>"},
+		{"Nested emphasis", "**bold *and italic***", "<strong>bold <em>and italic</em></strong>"},
+		{"Inside words", "snake_case_name and 2*3*4", "snake_case_name and 2*3*4"},
+		{"Spaces around delimiters", "a * b * c", "a * b * c"},
+		{"Unclosed", "**bold", "**bold"},
+		{"Inline code", "run `rm -rf *tmp*`", "run <code>rm -rf *tmp*</code>"},
+		{"Code block", "```go\nif a < b {\n\t**x**\n}\n```\nafter", "<pre><code>if a &lt; b {\n\t**x**\n}</code></pre>after"},
+		{"Unclosed code block", "```\ncode", "<pre><code>code</code></pre>"},
+		{"Quote", "> quoted\n> **text**\nreply", "<blockquote>quoted<br><strong>text</strong></blockquote>reply"},
+		{"Nested quote", ">> deep", "<blockquote><blockquote>deep</blockquote></blockquote>"},
+		{"Link", "see [the *docs*](https://example.com/a?b=1&c=2)",
+			`see <a href="https://example.com/a?b=1&amp;c=2" rel="noopener nofollow" target="_blank">the <em>docs</em></a>`},
+		{"Mail link", "[mail me](mailto:ann@example.com)",
+			`<a href="mailto:ann@example.com" rel="noopener nofollow" target="_blank">mail me</a>`},
+		{"Escaped delimiters", `\*not italic\*`, "*not italic*"},
+		{"Unicode", "**привет** 👋", "<strong>привет</strong> 👋"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Render(tt.src))
+		})
+	}
+}
+
+func TestRender_Unsafe(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"HTML", `<script>alert("x")</script>`, "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;"},
+		{"HTML in emphasis", "**<img src=x onerror=alert(1)>**", "<strong>&lt;img src=x onerror=alert(1)&gt;</strong>"},
+		{"JavaScript link", "[click](javascript:alert(1))", "[click](javascript:alert(1))"},
+		{"Data link", "[click](data:text/html,<b>)", "[click](data:text/html,&lt;b&gt;)"},
+		{"Relative link", "[click](/user/logout)", "[click](/user/logout)"},
+		{"Quotes in link", `[click](https://example.com/"onmouseover="alert(1))`,
+			`<a href="https://example.com/&#34;onmouseover=&#34;alert(1" rel="noopener nofollow" target="_blank">click</a>)`},
+		{"Deep quotes", ">>>>>>> deep", "<blockquote><blockquote><blockquote><blockquote><blockquote>&gt;&gt; deep" +
+			"</blockquote></blockquote></blockquote></blockquote></blockquote>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Render(tt.src))
+		})
+	}
+}
+
+func TestRender_Autolink(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"URL", "see https://example.com/a?b=1&c=2",
+			`see <a href="https://example.com/a?b=1&amp;c=2" rel="noopener nofollow" target="_blank">https://example.com/a?b=1&amp;c=2</a>`},
+		{"End of sentence", "Look: http://example.com.",
+			`Look: <a href="http://example.com" rel="noopener nofollow" target="_blank">http://example.com</a>.`},
+		{"In parentheses", "(https://example.com/a)",
+			`(<a href="https://example.com/a" rel="noopener nofollow" target="_blank">https://example.com/a</a>)`},
+		{"Balanced parentheses", "https://en.wikipedia.org/wiki/Go_(game)",
+			`<a href="https://en.wikipedia.org/wiki/Go_(game)" rel="noopener nofollow" target="_blank">https://en.wikipedia.org/wiki/Go_(game)</a>`},
+		{"In emphasis", "**https://example.com**",
+			`<strong><a href="https://example.com" rel="noopener nofollow" target="_blank">https://example.com</a></strong>`},
+		{"In link text", "[https://example.com](https://example.org)",
+			`<a href="https://example.org" rel="noopener nofollow" target="_blank">https://example.com</a>`},
+		{"In code", "`https://example.com`", "<code>https://example.com</code>"},
+		{"Inside word", "xhttps://example.com", "xhttps://example.com"},
+		{"Without host", "https:// is a scheme", "https:// is a scheme"},
+		{"Quotes", `https://example.com/"onclick="alert(1)`,
+			`<a href="https://example.com/" rel="noopener nofollow" target="_blank">https://example.com/</a>&#34;onclick=&#34;alert(1)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Render(tt.src))
+		})
+	}
+}
+
+func TestLinks(t *testing.T) {
+	src := "https://example.com and [docs](https://example.org/docs)\n" +
+		"`https://example.net` again https://example.com"
+
+	assert.Equal(t, []string{"https://example.com", "https://example.org/docs"}, Links(src))
+	assert.Nil(t, Links("no links"))
+}
+
+func TestEscape(t *testing.T) {
+	for _, text := range []string{"snake_case", "*not bold*", "[x](https://example.com)", "> not quoted", `back\slash`} {
+		assert.Equal(t, html.EscapeString(text), Render(Escape(text)), text)
+	}
+}
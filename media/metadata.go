@@ -0,0 +1,319 @@
+// Package media processes uploaded pictures: it removes metadata
+// that may reveal private details and makes thumbnails of them.
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+)
+
+// Notable errors.
+var (
+	ErrUnsupported = errors.New("media: unsupported image format")
+	ErrMalformed   = errors.New("media: malformed image")
+	ErrTooLarge    = errors.New("media: image is too large")
+)
+
+// Content types of the images the package can process.
+const (
+	PNG  = "image/png"
+	JPEG = "image/jpeg"
+	GIF  = "image/gif"
+)
+
+// Supported reports whether images of the content type can be processed.
+func Supported(contentType string) bool {
+	return contentType == PNG || contentType == JPEG || contentType == GIF
+}
+
+// StripMetadata returns the image without EXIF, XMP, IPTC, text
+// and comment blocks, which may contain locations, device serial numbers
+// or names. The pixels stay the same, except that JPEG images rotated
+// by the EXIF orientation are re-encoded in the upright position,
+// since the orientation is removed with the rest of EXIF.
+func StripMetadata(contentType string, data []byte) ([]byte, error) {
+	switch contentType {
+	case JPEG:
+		return stripJPEG(data)
+	case PNG:
+		return stripPNG(data)
+	case GIF:
+		return stripGIF(data)
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// JPEG markers.
+const (
+	markerSOI  = 0xd8
+	markerSOS  = 0xda
+	markerAPP1 = 0xe1
+	markerIPTC = 0xed // APP13
+	markerCOM  = 0xfe
+)
+
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != markerSOI {
+		return nil, ErrMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	orientation := 1
+
+	// segments before the start of scan carry metadata,
+	// the compressed data after it is copied as is
+	for i := 2; ; {
+		// markers may be preceded by any number of fill bytes
+		for i < len(data) && data[i] == 0xff && i+1 < len(data) && data[i+1] == 0xff {
+			i++
+		}
+		if i+4 > len(data) || data[i] != 0xff {
+			return nil, ErrMalformed
+		}
+
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, ErrMalformed
+		}
+
+		switch marker {
+		case markerSOS:
+			out = append(out, data[i:]...)
+			if orientation != 1 {
+				return reorientJPEG(out, orientation)
+			}
+			return out, nil
+		case markerAPP1:
+			if o, ok := exifOrientation(data[i+4 : end]); ok {
+				orientation = o
+			}
+		case markerIPTC, markerCOM:
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+}
+
+// exifOrientation returns the orientation tag of the APP1 segment.
+func exifOrientation(segment []byte) (int, bool) {
+	if !bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+		return 0, false
+	}
+	tiff := segment[6:]
+	if len(tiff) < 8 {
+		return 0, false
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, false
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0, false
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0, false
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			return o, o >= 1 && o <= 8
+		}
+	}
+
+	return 0, false
+}
+
+// reorientJPEG applies the EXIF orientation to the pixels.
+func reorientJPEG(data []byte, orientation int) ([]byte, error) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, orient(img, orientation), &jpeg.Options{Quality: 95})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// orient returns the image transformed according to the EXIF orientation.
+func orient(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if orientation >= 5 {
+		w, h = h, w
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = w-1-y, x
+			case 7:
+				dx, dy = w-1-y, h-1-x
+			case 8:
+				dx, dy = y, h-1-x
+			default:
+				dx, dy = x, y
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+
+	return dst
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// PNG chunks removed from images.
+var pngMetadata = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, ErrMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+
+	for i := len(pngSignature); ; {
+		// length, type, data and crc
+		if i+12 > len(data) {
+			return nil, ErrMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunk := string(data[i+4 : i+8])
+		end := i + 12 + length
+		if length < 0 || end > len(data) || end < i {
+			return nil, ErrMalformed
+		}
+
+		if !pngMetadata[chunk] {
+			out = append(out, data[i:end]...)
+		}
+		if chunk == "IEND" {
+			return out, nil
+		}
+		i = end
+	}
+}
+
+// GIF blocks.
+const (
+	gifExtension   = 0x21
+	gifImage       = 0x2c
+	gifTrailer     = 0x3b
+	gifComment     = 0xfe
+	gifApplication = 0xff
+)
+
+func stripGIF(data []byte) ([]byte, error) {
+	// header and logical screen descriptor
+	if len(data) < 13 || !(bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a"))) {
+		return nil, ErrMalformed
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	if i > len(data) {
+		return nil, ErrMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:i]...)
+
+	for i < len(data) {
+		start := i
+		keep := true
+
+		switch data[i] {
+		case gifTrailer:
+			return append(out, gifTrailer), nil
+		case gifExtension:
+			if i+2 > len(data) {
+				return nil, ErrMalformed
+			}
+			switch data[i+1] {
+			case gifComment:
+				keep = false
+			case gifApplication:
+				// only the looping of animations is kept
+				keep = i+14 <= len(data) && data[i+2] == 11 &&
+					(string(data[i+3:i+14]) == "NETSCAPE2.0" || string(data[i+3:i+14]) == "ANIMEXTS1.0")
+			}
+			i += 2
+		case gifImage:
+			// image descriptor, local color table and LZW code size
+			if i+10 > len(data) {
+				return nil, ErrMalformed
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i++
+		default:
+			return nil, ErrMalformed
+		}
+
+		// sub-blocks end with an empty one
+		for {
+			if i >= len(data) {
+				return nil, ErrMalformed
+			}
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+		if i > len(data) {
+			return nil, ErrMalformed
+		}
+
+		if keep {
+			out = append(out, data[start:i]...)
+		}
+	}
+
+	return nil, ErrMalformed
+}
@@ -0,0 +1,172 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testImage returns a white w×h image with the top left quarter painted red.
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 && y < h/2 {
+				img.Set(x, y, color.NRGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+
+	return img
+}
+
+func encode(t *testing.T, contentType string, img image.Image) []byte {
+	var buf bytes.Buffer
+	var err error
+	switch contentType {
+	case JPEG:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100})
+	case PNG:
+		err = png.Encode(&buf, img)
+	case GIF:
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// exifSegment returns APP1 segment with the GPS latitude reference
+// and the orientation.
+func exifSegment(orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = append(tiff, 0, 2)
+	tiff = append(tiff, 0x01, 0x12, 0, 3, 0, 0, 0, 1, byte(orientation>>8), byte(orientation), 0, 0)
+	tiff = append(tiff, 0x00, 0x01, 0, 2, 0, 0, 0, 2, 'N', 0, 0, 0)
+	tiff = append(tiff, 0, 0, 0, 0)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xff, markerAPP1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+func withJPEGSegment(data, segment []byte) []byte {
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func withPNGChunk(data []byte, chunk string, contents []byte) []byte {
+	c := make([]byte, 4)
+	binary.BigEndian.PutUint32(c, uint32(len(contents)))
+	c = append(c, chunk...)
+	c = append(c, contents...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(c[4:]))
+	c = append(c, crc...)
+
+	// right after IHDR
+	at := len(pngSignature) + 25
+	out := append([]byte{}, data[:at]...)
+	out = append(out, c...)
+	return append(out, data[at:]...)
+}
+
+func withGIFComment(data []byte, comment string) []byte {
+	// after the logical screen descriptor and the global color table
+	at := 13
+	if data[10]&0x80 != 0 {
+		at += 3 << (data[10]&0x07 + 1)
+	}
+	out := append([]byte{}, data[:at]...)
+	out = append(out, gifExtension, gifComment, byte(len(comment)))
+	out = append(out, comment...)
+	out = append(out, 0)
+	return append(out, data[at:]...)
+}
+
+func TestStripMetadata(t *testing.T) {
+	jpegData := encode(t, JPEG, testImage(4, 2))
+	pngData := encode(t, PNG, testImage(4, 2))
+	gifData := encode(t, GIF, testImage(4, 2))
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		want        []byte
+	}{
+		{"JPEG", JPEG, withJPEGSegment(jpegData, exifSegment(1)), jpegData},
+		{"JPEG comment", JPEG, withJPEGSegment(jpegData, []byte{0xff, markerCOM, 0, 6, 'J', 'o', 'h', 'n'}), jpegData},
+		{"PNG", PNG, withPNGChunk(pngData, "tEXt", []byte("Author\x00John")), pngData},
+		{"GIF", GIF, withGIFComment(gifData, "John"), gifData},
+		{"Without metadata", PNG, pngData, pngData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StripMetadata(tt.contentType, tt.data)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.NotContains(t, string(got), "John")
+		})
+	}
+}
+
+func TestStripMetadata_Orientation(t *testing.T) {
+	// rotated 90° clockwise to be viewed, the red quarter
+	// goes from the top left corner to the top right one
+	data := withJPEGSegment(encode(t, JPEG, testImage(32, 16)), exifSegment(6))
+
+	got, err := StripMetadata(JPEG, data)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, image.Rect(0, 0, 16, 32), img.Bounds())
+
+	r, g, _, _ := img.At(12, 4).RGBA()
+	assert.Greater(t, r, uint32(0xa000))
+	assert.Less(t, g, uint32(0x8000))
+}
+
+func TestStripMetadata_Invalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		wantErr     error
+	}{
+		{"Unsupported", "image/webp", []byte("RIFF"), ErrUnsupported},
+		{"Not JPEG", JPEG, []byte("hello"), ErrMalformed},
+		{"Truncated JPEG", JPEG, encode(t, JPEG, testImage(4, 2))[:20], ErrMalformed},
+		{"Truncated PNG", PNG, encode(t, PNG, testImage(4, 2))[:40], ErrMalformed},
+		{"Truncated GIF", GIF, encode(t, GIF, testImage(4, 2))[:20], ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := StripMetadata(tt.contentType, tt.data)
+			assert.Equal(t, tt.wantErr, err)
+		})
+	}
+}
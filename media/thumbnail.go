@@ -0,0 +1,168 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// ThumbnailSize is the maximum width and height of thumbnails.
+const ThumbnailSize = 320
+
+// maxPixels limits the size of images decoded to make thumbnails,
+// so that small files can't take a lot of memory once decoded.
+const maxPixels = 50_000_000
+
+// Thumbnail is a downscaled copy of an image.
+type Thumbnail struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+
+	// Dimensions of the original image.
+	SourceWidth  int
+	SourceHeight int
+}
+
+// ThumbnailContentType returns the format of thumbnails of the images
+// of the content type. Photos stay JPEG, others become PNG
+// to keep transparency and sharp edges.
+func ThumbnailContentType(contentType string) string {
+	if contentType == JPEG {
+		return JPEG
+	}
+	return PNG
+}
+
+// MakeThumbnail returns the image scaled down to fit into ThumbnailSize
+// preserving the aspect ratio. Smaller images keep their size.
+// Only the first frame of animated GIFs is used.
+func MakeThumbnail(contentType string, data []byte) (Thumbnail, error) {
+	if !Supported(contentType) {
+		return Thumbnail{}, ErrUnsupported
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Thumbnail{}, ErrMalformed
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return Thumbnail{}, ErrMalformed
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return Thumbnail{}, ErrTooLarge
+	}
+
+	var img image.Image
+	switch contentType {
+	case JPEG:
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case PNG:
+		img, err = png.Decode(bytes.NewReader(data))
+	case GIF:
+		img, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return Thumbnail{}, ErrMalformed
+	}
+
+	w, h := fit(cfg.Width, cfg.Height, ThumbnailSize)
+	thumb := scale(img, w, h)
+
+	t := Thumbnail{
+		ContentType:  ThumbnailContentType(contentType),
+		Width:        w,
+		Height:       h,
+		SourceWidth:  cfg.Width,
+		SourceHeight: cfg.Height,
+	}
+
+	var buf bytes.Buffer
+	if t.ContentType == JPEG {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return Thumbnail{}, err
+	}
+	t.Data = buf.Bytes()
+
+	return t, nil
+}
+
+// fit returns dimensions of the w×h rectangle scaled down
+// to fit into the size×size square.
+func fit(w, h, size int) (int, int) {
+	if w <= size && h <= size {
+		return w, h
+	}
+
+	if w >= h {
+		h = max(1, h*size/w)
+		w = size
+	} else {
+		w = max(1, w*size/h)
+		h = size
+	}
+
+	return w, h
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// scale resizes the image to w×h averaging the source pixels
+// covered by every pixel of the result.
+func scale(img image.Image, w, h int) *image.RGBA {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	if b.Dx() == w && b.Dy() == h {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*b.Dy()/h, (y+1)*b.Dy()/h
+		if y1 == y0 {
+			y1++
+		}
+
+		for x := 0; x < w; x++ {
+			x0, x1 := x*b.Dx()/w, (x+1)*b.Dx()/w
+			if x1 == x0 {
+				x1++
+			}
+
+			// premultiplied colors can be averaged directly
+			var r, g, bl, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += int(row[i])
+					g += int(row[i+1])
+					bl += int(row[i+2])
+					a += int(row[i+3])
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,97 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakeThumbnail(t *testing.T) {
+	tests := []struct {
+		name            string
+		contentType     string
+		width, height   int
+		wantContentType string
+		wantWidth       int
+		wantHeight      int
+	}{
+		{"Wide JPEG", JPEG, 1000, 500, JPEG, 320, 160},
+		{"Tall PNG", PNG, 100, 800, PNG, 40, 320},
+		{"GIF", GIF, 640, 640, PNG, 320, 320},
+		{"Small image", PNG, 20, 10, PNG, 20, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encode(t, tt.contentType, testImage(tt.width, tt.height))
+
+			thumb, err := MakeThumbnail(tt.contentType, data)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.Equal(t, tt.wantContentType, thumb.ContentType)
+			assert.Equal(t, tt.wantWidth, thumb.Width)
+			assert.Equal(t, tt.wantHeight, thumb.Height)
+			assert.Equal(t, tt.width, thumb.SourceWidth)
+			assert.Equal(t, tt.height, thumb.SourceHeight)
+
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(thumb.Data))
+			if assert.NoError(t, err) {
+				assert.Equal(t, "image/"+format, thumb.ContentType)
+				assert.Equal(t, tt.wantWidth, cfg.Width)
+				assert.Equal(t, tt.wantHeight, cfg.Height)
+			}
+		})
+	}
+}
+
+func TestMakeThumbnail_Colors(t *testing.T) {
+	thumb, err := MakeThumbnail(PNG, encode(t, PNG, testImage(1000, 1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(thumb.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, [4]uint32{0xffff, 0, 0, 0xffff}, rgba(img.At(10, 10)))
+	assert.Equal(t, [4]uint32{0xffff, 0xffff, 0xffff, 0xffff}, rgba(img.At(300, 300)))
+}
+
+func rgba(c interface{ RGBA() (r, g, b, a uint32) }) [4]uint32 {
+	r, g, b, a := c.RGBA()
+	return [4]uint32{r, g, b, a}
+}
+
+func TestMakeThumbnail_Invalid(t *testing.T) {
+	// the header claims the image is 100000×100000
+	huge := encode(t, PNG, testImage(1, 1))
+	copy(huge[16:24], []byte{0, 1, 0x86, 0xa0, 0, 1, 0x86, 0xa0})
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		wantErr     error
+	}{
+		{"Unsupported", "application/pdf", []byte("%PDF-"), ErrUnsupported},
+		{"Malformed", PNG, []byte("hello"), ErrMalformed},
+		{"Too many pixels", PNG, huge, ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := MakeThumbnail(tt.contentType, tt.data)
+			assert.Equal(t, tt.wantErr, err)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package mock
+
+import (
+	"time"
+
+	"github.com/lazy-void/chatapp/models"
+)
+
+// AttachmentMock is a mock of a file attached to MessageMock.
+var AttachmentMock = models.Attachment{
+	ID:          1,
+	MessageID:   MessageMock.ID,
+	Username:    UserMock.Username,
+	Key:         "attachment",
+	Filename:    "hello.txt",
+	ContentType: "text/plain; charset=utf-8",
+	Size:        11,
+	Created:     time.Now(),
+}
+
+// PendingAttachmentMock is a mock of an image uploaded
+// by UserMock that is not sent yet.
+var PendingAttachmentMock = models.Attachment{
+	ID:              2,
+	Username:        UserMock.Username,
+	Key:             "pending",
+	Filename:        "cat.png",
+	ContentType:     "image/png",
+	Size:            42,
+	Created:         time.Now(),
+	Width:           640,
+	Height:          480,
+	ThumbnailKey:    "pending_thumbnail",
+	ThumbnailWidth:  320,
+	ThumbnailHeight: 240,
+}
+
+// AttachmentModel implements mock methods for attachments table.
+type AttachmentModel struct{}
+
+// Insert mocks recording of the uploaded file.
+func (m *AttachmentModel) Insert(a models.Attachment) (models.Attachment, error) {
+	if a.Username == "invalidUsername" {
+		return models.Attachment{}, models.ErrInvalidUsername
+	}
+
+	a.ID = 3
+	a.Created = time.Now()
+	return a, nil
+}
+
+// Get mocks operation of getting the attachment. Attachment with
+// id 3 is uploaded by another user and is not sent yet.
+func (m *AttachmentModel) Get(id int64) (models.Attachment, error) {
+	switch id {
+	case AttachmentMock.ID:
+		return AttachmentMock, nil
+	case PendingAttachmentMock.ID:
+		return PendingAttachmentMock, nil
+	case 3:
+		a := PendingAttachmentMock
+		a.ID = 3
+		a.Username = "Ann"
+		return a, nil
+	default:
+		return models.Attachment{}, models.ErrNoRecord
+	}
+}
@@ -1,6 +1,8 @@
 package mock
 
 import (
+	"html"
+	"strings"
 	"time"
 
 	"github.com/lazy-void/chatapp/models"
@@ -9,30 +11,188 @@ import (
 // MessageMock is a mock of a message.
 var MessageMock = models.Message{
 	ID:       1,
+	RoomID:   models.DefaultRoomID,
 	Text:     "hello world",
 	Username: "Fenrir",
 	Created:  time.Now(),
+	Key:      "key",
 }
 
 // MessageModel implements mock methods for messages table.
 type MessageModel struct{}
 
 // Insert mocks insertion of message into a database.
-func (m *MessageModel) Insert(text, username string, created time.Time) (int, error) {
-	switch username {
-	case "invalidUsername":
-		return 0, models.ErrInvalidUsername
+func (m *MessageModel) Insert(roomID, parentID int64, text, username, key string, attachments []int64,
+	created time.Time) (models.Message, error) {
+	switch {
+	case username == "invalidUsername":
+		return models.Message{}, models.ErrInvalidUsername
+	case roomID != models.DefaultRoomID:
+		return models.Message{}, models.ErrInvalidRoom
+	case parentID != 0 && parentID != MessageMock.ID:
+		return models.Message{}, models.ErrInvalidParent
+	case key != "" && key == MessageMock.Key:
+		return MessageMock, models.ErrDuplicateKey
+	default:
+		return models.Message{
+			ID:       2,
+			RoomID:   roomID,
+			Username: username,
+			Text:     text,
+			Created:  created,
+			Key:      key,
+			ParentID: parentID,
+			Bot:      username == BotMock.Username,
+		}, nil
+	}
+}
+
+// Get mocks operation of getting the message from a database.
+func (m *MessageModel) Get(id int64) (models.Message, error) {
+	if id != MessageMock.ID {
+		return models.Message{}, models.ErrNoRecord
+	}
+
+	return MessageMock, nil
+}
+
+// Before mocks operation of getting messages from a database.
+func (m *MessageModel) Before(roomID, id int64, n int) ([]models.Message, error) {
+	switch {
+	case roomID == MessageMock.RoomID && n >= 1 && (id == 0 || id > MessageMock.ID):
+		return []models.Message{MessageMock}, nil
 	default:
-		return 2, nil
+		return nil, nil
 	}
 }
 
-// Latest mocks operation of getting messages from a database.
-func (m *MessageModel) Latest(n, offset int) ([]models.Message, error) {
+// After mocks operation of getting messages sent after the given one.
+func (m *MessageModel) After(username string, id int64, n int) ([]models.Message, error) {
 	switch {
-	case n >= 1 && offset == 0:
+	case username == MessageMock.Username && n >= 1 && id < MessageMock.ID:
 		return []models.Message{MessageMock}, nil
 	default:
 		return nil, nil
 	}
 }
+
+// Changed mocks getting of the changed messages, none are changed.
+func (m *MessageModel) Changed(username string, id int64, n int) ([]models.Message, error) {
+	return nil, nil
+}
+
+// Thread mocks operation of getting the thread started by the message.
+func (m *MessageModel) Thread(id int64, n int) ([]models.Message, error) {
+	if id != MessageMock.ID {
+		return nil, models.ErrNoRecord
+	}
+
+	return []models.Message{MessageMock}, nil
+}
+
+// React mocks adding of the reaction to the message.
+func (m *MessageModel) React(id int64, username, emoji string) (models.Message, error) {
+	if id != MessageMock.ID {
+		return models.Message{}, models.ErrNoRecord
+	}
+
+	msg := MessageMock
+	msg.Reactions = []models.Reaction{{Emoji: emoji, Count: 1, Usernames: []string{username}}}
+	return msg, nil
+}
+
+// Unreact mocks removal of the reaction from the message.
+func (m *MessageModel) Unreact(id int64, username, emoji string) (models.Message, error) {
+	if id != MessageMock.ID {
+		return models.Message{}, models.ErrNoRecord
+	}
+
+	return MessageMock, nil
+}
+
+// Search mocks search of the messages. MessageMock is found
+// by the words of its text.
+func (m *MessageModel) Search(username string, q models.SearchQuery, n int) ([]models.SearchResult, error) {
+	switch {
+	case n < 1 || !strings.Contains(MessageMock.Text, q.Text):
+		return nil, nil
+	case q.Author != "" && q.Author != MessageMock.Username:
+		return nil, nil
+	default:
+		headline := strings.ReplaceAll(html.EscapeString(MessageMock.Text), q.Text, "<mark>"+q.Text+"</mark>")
+		return []models.SearchResult{{Message: MessageMock, Headline: headline, Rank: 0.1}}, nil
+	}
+}
+
+// Edit mocks editing of the message.
+func (m *MessageModel) Edit(id int64, username, text string, edited time.Time) (models.Message, error) {
+	switch {
+	case id != MessageMock.ID:
+		return models.Message{}, models.ErrNoRecord
+	case username != MessageMock.Username:
+		return models.Message{}, models.ErrNotAuthor
+	default:
+		msg := MessageMock
+		msg.Text = text
+		msg.Edited = edited
+		return msg, nil
+	}
+}
+
+// Delete mocks deletion of the message.
+func (m *MessageModel) Delete(id int64, username string) (models.Message, error) {
+	switch {
+	case id != MessageMock.ID:
+		return models.Message{}, models.ErrNoRecord
+	case username != MessageMock.Username:
+		return models.Message{}, models.ErrNotAuthor
+	default:
+		msg := MessageMock
+		msg.Deleted = true
+		return msg, nil
+	}
+}
+
+// Remove mocks deletion of the message by a moderator.
+func (m *MessageModel) Remove(id int64) (models.Message, error) {
+	if id != MessageMock.ID {
+		return models.Message{}, models.ErrNoRecord
+	}
+
+	msg := MessageMock
+	msg.Deleted = true
+	return msg, nil
+}
+
+// AddPreviews mocks saving of the link previews.
+func (m *MessageModel) AddPreviews(id int64, urls []string) (models.Message, error) {
+	if id != MessageMock.ID {
+		return models.Message{}, models.ErrNoRecord
+	}
+
+	msg := MessageMock
+	for _, url := range urls {
+		msg.Previews = append(msg.Previews, models.Preview{URL: url, Title: url})
+	}
+	return msg, nil
+}
+
+// Mention mocks saving of the mentions.
+func (m *MessageModel) Mention(id int64, usernames []string) error {
+	if id != MessageMock.ID && id != 2 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// Mentioned mocks operation of getting messages that mention the user.
+func (m *MessageModel) Mentioned(username string, n int) ([]models.Message, error) {
+	if username != UserMock.Username || n < 1 {
+		return nil, nil
+	}
+
+	msg := MessageMock
+	msg.Mentions = []string{UserMock.Username}
+	return []models.Message{msg}, nil
+}
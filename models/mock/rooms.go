@@ -0,0 +1,127 @@
+package mock
+
+import (
+	"time"
+
+	"github.com/lazy-void/chatapp/models"
+)
+
+// DupeRoomName fails Insert method of the RoomModel.
+const DupeRoomName = "dupeRoom"
+
+// RoomMock is a mock of a room.
+var RoomMock = models.Room{
+	ID:      models.DefaultRoomID,
+	Name:    "general",
+	Creator: "",
+	Created: time.Now(),
+}
+
+// RoomModel implements mock methods for rooms table.
+type RoomModel struct{}
+
+// Insert mocks creation of the room.
+func (m *RoomModel) Insert(name, creator string) (int64, error) {
+	switch name {
+	case DupeRoomName:
+		return 0, models.ErrDuplicateRoomName
+	default:
+		return 2, nil
+	}
+}
+
+// Get mocks operation of getting room from the database.
+func (m *RoomModel) Get(id int64) (models.Room, error) {
+	switch id {
+	case RoomMock.ID:
+		return RoomMock, nil
+	default:
+		return models.Room{}, models.ErrNoRecord
+	}
+}
+
+// All mocks operation of getting all rooms from the database.
+func (m *RoomModel) All() ([]models.Room, error) {
+	return []models.Room{RoomMock}, nil
+}
+
+// Joined mocks operation of getting rooms the user is member of.
+func (m *RoomModel) Joined(username string) ([]models.Room, error) {
+	switch username {
+	case UserMock.Username:
+		return []models.Room{RoomMock}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// Direct mocks getting the direct room of two users.
+func (m *RoomModel) Direct(username, peer string) (int64, error) {
+	if peer != UserMock.Username {
+		return 0, models.ErrInvalidUsername
+	}
+
+	return 3, nil
+}
+
+// SetTopic mocks changing of the topic.
+func (m *RoomModel) SetTopic(id int64, topic string) error {
+	if id != RoomMock.ID {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// Join mocks joining the room.
+func (m *RoomModel) Join(roomID int64, username string) error {
+	if roomID != RoomMock.ID {
+		return models.ErrInvalidRoom
+	}
+
+	return nil
+}
+
+// Leave mocks leaving the room.
+func (m *RoomModel) Leave(roomID int64, username string) error {
+	if roomID != RoomMock.ID || username != UserMock.Username {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// IsMember mocks check of the room membership.
+func (m *RoomModel) IsMember(roomID int64, username string) (bool, error) {
+	return roomID == RoomMock.ID && (username == UserMock.Username || username == BotMock.Username), nil
+}
+
+// Members mocks selection of the room members from the list.
+func (m *RoomModel) Members(roomID int64, usernames []string) ([]string, error) {
+	var members []string
+	for _, username := range usernames {
+		if ok, _ := m.IsMember(roomID, username); ok {
+			members = append(members, username)
+		}
+	}
+
+	return members, nil
+}
+
+// MarkRead mocks marking messages of the room as read.
+func (m *RoomModel) MarkRead(roomID int64, username string, messageID int64) error {
+	if roomID != RoomMock.ID || username != UserMock.Username || messageID != MessageMock.ID {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// Unread mocks counting of unread messages.
+func (m *RoomModel) Unread(username string) (map[int64]int, error) {
+	if username != UserMock.Username {
+		return map[int64]int{}, nil
+	}
+
+	return map[int64]int{RoomMock.ID: 1}, nil
+}
@@ -16,6 +16,9 @@ const (
 
 	// DupeEmail fails Insert method.
 	DupeEmail = "dupe@google.com"
+
+	// BotToken authenticates BotMock.
+	BotToken = "bot-token"
 )
 
 // UserMock is a mock of a user.
@@ -24,6 +27,45 @@ var UserMock = models.User{
 	Email:          "fenrir@google.com",
 	HashedPassword: "$2a$12$6vzjkqafxBK8nFtvT83.ZuYKMCVAOa..lQDjySLQ6UIUo3m.2j.um",
 	Created:        time.Now(),
+	Role:           models.RoleMember,
+}
+
+// ModeratorMock is a mock of a user who moderates messages.
+var ModeratorMock = models.User{
+	Username:       "Loki",
+	Email:          "loki@google.com",
+	HashedPassword: "$2a$12$6vzjkqafxBK8nFtvT83.ZuYKMCVAOa..lQDjySLQ6UIUo3m.2j.um",
+	Created:        time.Now(),
+	Role:           models.RoleModerator,
+}
+
+// AdminMock is a mock of a user who manages roles, bots and webhooks.
+var AdminMock = models.User{
+	Username:       "Odin",
+	Email:          "odin@google.com",
+	HashedPassword: "$2a$12$6vzjkqafxBK8nFtvT83.ZuYKMCVAOa..lQDjySLQ6UIUo3m.2j.um",
+	Created:        time.Now(),
+	Role:           models.RoleAdmin,
+}
+
+// BannedMock is a mock of a user who is banned from the chat.
+var BannedMock = models.User{
+	Username:       "Jormungandr",
+	Email:          "jormungandr@google.com",
+	HashedPassword: "$2a$12$6vzjkqafxBK8nFtvT83.ZuYKMCVAOa..lQDjySLQ6UIUo3m.2j.um",
+	Created:        time.Now(),
+	Role:           models.RoleMember,
+	Banned:         true,
+}
+
+// BotMock is a mock of a bot created by AdminMock.
+var BotMock = models.User{
+	Username: "Huginn",
+	Created:  time.Now(),
+	Role:     models.RoleMember,
+	Bot:      true,
+	Creator:  "Odin",
+	HasToken: true,
 }
 
 // UserModel implements mock methods for users table.
@@ -44,10 +86,17 @@ func (m *UserModel) Insert(username, email, password string) error {
 // Authenticate mocks check for correctness of email and password.
 func (m *UserModel) Authenticate(email, password string) (string, error) {
 	switch {
-	case email != UserMock.Email:
+	case email != UserMock.Email && email != ModeratorMock.Email && email != AdminMock.Email &&
+		email != BannedMock.Email:
 		return "", models.ErrNoRecord
 	case password != ValidPassword:
 		return "", models.ErrInvalidPassword
+	case email == BannedMock.Email:
+		return "", models.ErrBanned
+	case email == ModeratorMock.Email:
+		return ModeratorMock.Username, nil
+	case email == AdminMock.Email:
+		return AdminMock.Username, nil
 	default:
 		return UserMock.Username, nil
 	}
@@ -58,7 +107,106 @@ func (m *UserModel) Get(username string) (models.User, error) {
 	switch username {
 	case UserMock.Username:
 		return UserMock, nil
+	case ModeratorMock.Username:
+		return ModeratorMock, nil
+	case AdminMock.Username:
+		return AdminMock, nil
+	case BannedMock.Username:
+		return BannedMock, nil
+	case BotMock.Username:
+		return BotMock, nil
 	default:
 		return models.User{}, models.ErrNoRecord
 	}
 }
+
+// SetNickname mocks changing of the nickname.
+func (m *UserModel) SetNickname(username, nickname string) error {
+	if username != UserMock.Username {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// InsertBot mocks creation of the bot.
+func (m *UserModel) InsertBot(username, creator string) (string, error) {
+	if username == DupeUsername || username == UserMock.Username || username == BotMock.Username {
+		return "", models.ErrDuplicateUsername
+	}
+
+	return BotToken, nil
+}
+
+// Bots mocks operation of getting all bots from the database.
+func (m *UserModel) Bots() ([]models.User, error) {
+	return []models.User{BotMock}, nil
+}
+
+// People mocks operation of getting all users except bots from the database.
+func (m *UserModel) People() ([]models.User, error) {
+	return []models.User{ModeratorMock, AdminMock, UserMock}, nil
+}
+
+// SetRole mocks changing of the role.
+func (m *UserModel) SetRole(username string, role models.Role) error {
+	if !role.Valid() {
+		return models.ErrInvalidRole
+	}
+	if username != UserMock.Username && username != ModeratorMock.Username && username != AdminMock.Username {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// NewToken mocks replacing of the bot token.
+func (m *UserModel) NewToken(username string) (string, error) {
+	if username != BotMock.Username {
+		return "", models.ErrNoRecord
+	}
+
+	return BotToken, nil
+}
+
+// RevokeToken mocks removal of the bot token.
+func (m *UserModel) RevokeToken(username string) error {
+	if username != BotMock.Username {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// AuthenticateToken mocks check of the bot token.
+func (m *UserModel) AuthenticateToken(token string) (string, error) {
+	if token != BotToken {
+		return "", models.ErrInvalidToken
+	}
+
+	return BotMock.Username, nil
+}
+
+// Sanction mocks imposing of the mute or the ban.
+func (m *UserModel) Sanction(s models.Sanction) (int64, error) {
+	if _, err := m.Get(s.Username); err != nil {
+		return 0, models.ErrInvalidUsername
+	}
+
+	return 1, nil
+}
+
+// ActiveSanction mocks getting of the ban of BannedMock.
+func (m *UserModel) ActiveSanction(username string, kind models.SanctionKind) (models.Sanction, error) {
+	if username != BannedMock.Username || kind != models.SanctionBan {
+		return models.Sanction{}, models.ErrNoRecord
+	}
+
+	return models.Sanction{ID: 1, Username: username, Kind: kind, Creator: ModeratorMock.Username}, nil
+}
+
+// LiftSanction mocks lifting of the ban of BannedMock.
+func (m *UserModel) LiftSanction(username string, kind models.SanctionKind) error {
+	_, err := m.ActiveSanction(username, kind)
+	return err
+}
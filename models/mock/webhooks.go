@@ -0,0 +1,113 @@
+package mock
+
+import (
+	"time"
+
+	"github.com/lazy-void/chatapp/models"
+)
+
+// WebhookMock is a mock of a webhook.
+var WebhookMock = models.Webhook{
+	ID:      1,
+	URL:     "https://example.com/hook",
+	Secret:  "secret",
+	Creator: "Odin",
+	Created: time.Now(),
+}
+
+// FailedDeliveryMock is a mock of a delivery to WebhookMock
+// that is no longer retried.
+var FailedDeliveryMock = models.Delivery{
+	ID:        1,
+	WebhookID: WebhookMock.ID,
+	URL:       WebhookMock.URL,
+	Secret:    WebhookMock.Secret,
+	MessageID: MessageMock.ID,
+	Attempts:  10,
+	LastError: "webhook: unexpected response status 502 Bad Gateway",
+	Failed:    true,
+	Created:   time.Now(),
+}
+
+// WebhookModel implements mock methods for webhooks table.
+type WebhookModel struct{}
+
+// Insert mocks creation of the webhook.
+func (m *WebhookModel) Insert(w models.Webhook) (int64, error) {
+	if w.RoomID != 0 && w.RoomID != RoomMock.ID {
+		return 0, models.ErrInvalidRoom
+	}
+
+	return 2, nil
+}
+
+// All mocks operation of getting all webhooks from the database.
+func (m *WebhookModel) All() ([]models.Webhook, error) {
+	return []models.Webhook{WebhookMock}, nil
+}
+
+// Delete mocks removal of the webhook.
+func (m *WebhookModel) Delete(id int64) error {
+	if id != WebhookMock.ID {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// Failed mocks operation of getting failed deliveries from the database.
+func (m *WebhookModel) Failed(n int) ([]models.Delivery, error) {
+	return []models.Delivery{FailedDeliveryMock}, nil
+}
+
+// IncomingWebhookToken authenticates IncomingWebhookMock.
+const IncomingWebhookToken = "hook-token"
+
+// IncomingWebhookMock is a mock of an incoming webhook
+// posting to RoomMock as BotMock.
+var IncomingWebhookMock = models.IncomingWebhook{
+	ID:       1,
+	RoomID:   RoomMock.ID,
+	Username: BotMock.Username,
+	Name:     "CI",
+	Creator:  "Odin",
+	Created:  time.Now(),
+}
+
+// IncomingWebhookModel implements mock methods for incoming_webhooks table.
+type IncomingWebhookModel struct{}
+
+// Insert mocks creation of the incoming webhook.
+func (m *IncomingWebhookModel) Insert(w models.IncomingWebhook) (string, error) {
+	if w.Username == DupeUsername || w.Username == UserMock.Username || w.Username == BotMock.Username {
+		return "", models.ErrDuplicateUsername
+	}
+	if w.RoomID != RoomMock.ID {
+		return "", models.ErrInvalidRoom
+	}
+
+	return IncomingWebhookToken, nil
+}
+
+// All mocks operation of getting all incoming webhooks from the database.
+func (m *IncomingWebhookModel) All() ([]models.IncomingWebhook, error) {
+	return []models.IncomingWebhook{IncomingWebhookMock}, nil
+}
+
+// Delete mocks removal of the incoming webhook.
+func (m *IncomingWebhookModel) Delete(id int64) error {
+	if id != IncomingWebhookMock.ID {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// Authenticate mocks operation of getting the incoming webhook by its token.
+func (m *IncomingWebhookModel) Authenticate(token string) (models.IncomingWebhook, error) {
+	if token != IncomingWebhookToken {
+		return models.IncomingWebhook{}, models.ErrInvalidToken
+	}
+
+	return IncomingWebhookMock, nil
+}
@@ -6,21 +6,254 @@ import (
 	"time"
 )
 
+// DefaultRoomID is the ID of the room that every new user joins.
+const DefaultRoomID = 1
+
 // Notable errors.
 var (
 	ErrNoRecord          = errors.New("models: no matching record found")
 	ErrInvalidUsername   = errors.New("models: username doesn't exist")
+	ErrInvalidRoom       = errors.New("models: room doesn't exist")
 	ErrInvalidPassword   = errors.New("models: invalid password")
 	ErrDuplicateEmail    = errors.New("models: duplicate email")
 	ErrDuplicateUsername = errors.New("models: duplicate username")
+	ErrDuplicateRoomName = errors.New("models: duplicate room name")
+	ErrNotAuthor         = errors.New("models: user is not the author of the message")
+	ErrDuplicateKey      = errors.New("models: duplicate message key")
+	ErrInvalidParent     = errors.New("models: parent message doesn't exist")
+	ErrInvalidAttachment = errors.New("models: attachment doesn't exist")
+	ErrInvalidToken      = errors.New("models: invalid API token")
+	ErrInvalidRole       = errors.New("models: role doesn't exist")
+	ErrBanned            = errors.New("models: user is banned")
 )
 
 // Message represents row from the messages table.
 type Message struct {
 	ID       int64
+	RoomID   int64
 	Username string
 	Text     string
 	Created  time.Time
+
+	// Current nickname of the author, empty if the author has none.
+	Nickname string
+
+	// Whether the author is a bot.
+	Bot bool
+
+	// Key chosen by the client to send the message only once,
+	// unique for the user. Empty if the client hasn't chosen any.
+	Key string
+
+	// Time of the last edit, zero if the message was never edited.
+	Edited time.Time
+
+	// Deleted messages are kept in the table, but must not be shown.
+	Deleted bool
+
+	// Usernames of the users mentioned in the message, sorted.
+	Mentions []string
+
+	// ID of the message that started the thread the message
+	// replies in, 0 if the message is not a reply.
+	ParentID int64
+
+	// Number of replies in the thread started by the message.
+	Replies int
+
+	// Reactions to the message sorted by emoji.
+	Reactions []Reaction
+
+	// Files attached to the message in order of uploading.
+	Attachments []Attachment
+
+	// Previews of the pages linked from the message in order of the links.
+	Previews []Preview
+}
+
+// Reaction represents reactions to a message with the same emoji
+// aggregated from the message_reactions table.
+type Reaction struct {
+	Emoji string
+	Count int
+
+	// Usernames of the users who have reacted, sorted.
+	Usernames []string
+}
+
+// Attachment represents row from the attachments table.
+type Attachment struct {
+	ID int64
+
+	// ID of the message the file is attached to,
+	// 0 until the message is sent.
+	MessageID int64
+
+	// The user who has uploaded the file.
+	Username string
+
+	// Key of the file contents in the blob storage.
+	Key string
+
+	Filename    string
+	ContentType string
+	Size        int64
+	Created     time.Time
+
+	// Dimensions of images, 0 for other files.
+	Width  int
+	Height int
+
+	// Key of the downscaled copy of the image in the blob storage,
+	// empty if the file has no thumbnail.
+	ThumbnailKey    string
+	ThumbnailWidth  int
+	ThumbnailHeight int
+}
+
+// Preview represents row from the link_previews table,
+// metadata of the linked page.
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+
+	// URL of the image representing the page, may be empty.
+	Image string
+
+	Fetched time.Time
+}
+
+// Webhook represents row from the webhooks table. Webhooks receive
+// new messages, optionally only the ones matching the filters.
+type Webhook struct {
+	ID  int64
+	URL string
+
+	// Key of the HMAC signature of the delivered payloads.
+	Secret string
+
+	// Room the messages are taken from, 0 for all rooms.
+	RoomID int64
+
+	// Text the messages must contain ignoring case, empty for any text.
+	Keyword string
+
+	Creator string
+	Created time.Time
+}
+
+// IncomingWebhook represents row from the incoming_webhooks table.
+// External systems post messages to the room with the token of the
+// webhook. Messages are sent by the bot created for the webhook.
+type IncomingWebhook struct {
+	ID     int64
+	RoomID int64
+
+	// Username of the bot sending the messages
+	// and the name shown instead of it.
+	Username string
+	Name     string
+
+	Creator string
+	Created time.Time
+}
+
+// Delivery represents row from the webhook_deliveries table,
+// a payload queued for delivery to the webhook.
+type Delivery struct {
+	ID        int64
+	WebhookID int64
+
+	// URL and Secret of the webhook.
+	URL    string
+	Secret string
+
+	// The message sent in the payload.
+	MessageID int64
+
+	// Number of failed attempts to deliver the payload.
+	Attempts int
+
+	// Why the last attempt has failed.
+	LastError string
+
+	// When the payload is delivered next, unless it has Failed
+	// after too many attempts and is no longer retried.
+	NextAttempt time.Time
+	Failed      bool
+
+	Created time.Time
+}
+
+// SearchQuery describes messages to search for.
+type SearchQuery struct {
+	// Words to search for, quoted phrases and -excluded words are supported.
+	Text string
+
+	// Optional filters, ignored if zero.
+	RoomID int64
+	Author string
+	Since  time.Time
+	Until  time.Time
+}
+
+// SearchResult represents a message found by the SearchQuery.
+type SearchResult struct {
+	Message Message
+
+	// HTML escaped fragment of the text with the found
+	// words wrapped into <mark></mark> tags.
+	Headline string
+
+	// The higher the rank, the better the message matches the query.
+	Rank float64
+}
+
+// Role defines what the user is allowed to do in the chat.
+// Every role is allowed everything the lower roles are.
+type Role string
+
+// Roles from the lowest to the highest.
+const (
+	// Members send messages and change their own ones.
+	RoleMember Role = "member"
+
+	// Moderators also delete messages of other users.
+	RoleModerator Role = "moderator"
+
+	// Admins also manage roles, bots and webhooks.
+	RoleAdmin Role = "admin"
+)
+
+// roleRanks orders the roles.
+var roleRanks = map[Role]int{
+	RoleMember:    1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// Roles returns all roles from the lowest to the highest.
+func Roles() []Role {
+	return []Role{RoleMember, RoleModerator, RoleAdmin}
+}
+
+// Valid reports whether the role is one of the defined roles.
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// AtLeast reports whether the role is allowed everything
+// the other role is. Unknown roles are allowed nothing.
+func (r Role) AtLeast(other Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[other]
+}
+
+// Outranks reports whether the role is higher than the other one,
+// e.g. moderators outrank members, but not other moderators.
+func (r Role) Outranks(other Role) bool {
+	return r.Valid() && roleRanks[r] > roleRanks[other]
 }
 
 // User represents row from the users table.
@@ -29,4 +262,68 @@ type User struct {
 	Email          string
 	HashedPassword string
 	Created        time.Time
+
+	// Name shown instead of the username, empty if the user hasn't chosen any.
+	Nickname string
+
+	// Role of the user in the chat, RoleMember by default.
+	Role Role
+
+	// Bots are accounts of programs created by admins. They have
+	// no email and password and authenticate with API tokens.
+	Bot bool
+
+	// Username of the admin who has created the bot.
+	Creator string
+
+	// Whether the bot has an API token. Bots without tokens
+	// can't connect until they get a new one.
+	HasToken bool
+
+	// Whether the user has an active ban.
+	Banned bool
+}
+
+// SanctionKind tells what the sanction stops the user from doing.
+type SanctionKind string
+
+// Kinds of the sanctions.
+const (
+	// Muted users can't send messages.
+	SanctionMute SanctionKind = "mute"
+
+	// Banned users can't log in or use the API.
+	SanctionBan SanctionKind = "ban"
+)
+
+// Sanction represents row from the sanctions table. Sanctions are
+// imposed by moderators and are active until they expire or are lifted.
+type Sanction struct {
+	ID       int64
+	Username string
+	Kind     SanctionKind
+	Reason   string
+
+	// Zero if the sanction never expires.
+	Expires time.Time
+
+	Creator string
+	Created time.Time
+}
+
+// Room represents row from the rooms table.
+type Room struct {
+	ID   int64
+	Name string
+
+	// Direct rooms are private conversations between two users.
+	// They don't have a name of their own, so when a room is listed
+	// for one of its members, Name holds username of the other one.
+	Direct bool
+
+	Creator string
+	Created time.Time
+
+	// What the room is about, empty if nobody has set it.
+	Topic string
 }
@@ -0,0 +1,64 @@
+package postgresql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+)
+
+// AttachmentModel implements methods for working with attachments table.
+// Attachments are linked to messages by MessageModel.Insert.
+type AttachmentModel struct {
+	DB *sql.DB
+}
+
+// Columns of the attachments table scanned by scanAttachment.
+const attachmentColumns = `id, COALESCE(message_id, 0), username, blob_key, filename, content_type, size, created,
+	width, height, COALESCE(thumbnail_key, ''), thumbnail_width, thumbnail_height`
+
+// Insert records the file uploaded by the user and returns it as it was stored.
+// ID, MessageID and Created of the attachment are ignored.
+func (m *AttachmentModel) Insert(a models.Attachment) (models.Attachment, error) {
+	stmt := `INSERT INTO attachments(username, blob_key, filename, content_type, size,
+		width, height, thumbnail_key, thumbnail_width, thumbnail_height)
+	VALUES($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10)
+	RETURNING ` + attachmentColumns + `;`
+
+	a, err := scanAttachment(m.DB.QueryRow(stmt, a.Username, a.Key, a.Filename, a.ContentType, a.Size,
+		a.Width, a.Height, a.ThumbnailKey, a.ThumbnailWidth, a.ThumbnailHeight))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation &&
+		pgErr.ConstraintName == "attachments_username_fkey" {
+		return models.Attachment{}, models.ErrInvalidUsername
+	}
+
+	return a, err
+}
+
+// Get returns the attachment with provided id.
+func (m *AttachmentModel) Get(id int64) (models.Attachment, error) {
+	stmt := `SELECT ` + attachmentColumns + ` FROM attachments WHERE id = $1;`
+
+	a, err := scanAttachment(m.DB.QueryRow(stmt, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Attachment{}, models.ErrNoRecord
+	}
+
+	return a, err
+}
+
+// scanAttachment scans attachmentColumns of the row into the attachment.
+func scanAttachment(row interface{ Scan(...interface{}) error }) (models.Attachment, error) {
+	a := models.Attachment{}
+	err := row.Scan(&a.ID, &a.MessageID, &a.Username, &a.Key, &a.Filename, &a.ContentType, &a.Size, &a.Created,
+		&a.Width, &a.Height, &a.ThumbnailKey, &a.ThumbnailWidth, &a.ThumbnailHeight)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+
+	return a, nil
+}
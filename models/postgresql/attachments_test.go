@@ -0,0 +1,136 @@
+package postgresql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttachmentModel_Insert(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		username  string
+		key       string
+		wantError error
+	}{
+		{"Correct attachment", testUser.Username, "key", nil},
+		{"Non-existent username", "random username", "key", models.ErrInvalidUsername},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := AttachmentModel{DB: db}
+
+			a, err := m.Insert(models.Attachment{
+				Username:        tt.username,
+				Key:             tt.key,
+				Filename:        "cat.png",
+				ContentType:     "image/png",
+				Size:            42,
+				Width:           640,
+				Height:          480,
+				ThumbnailKey:    tt.key + "_thumbnail",
+				ThumbnailWidth:  320,
+				ThumbnailHeight: 240,
+			})
+			assert.Equal(t, tt.wantError, err)
+			if err != nil {
+				return
+			}
+
+			got, err := m.Get(a.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, a, got)
+			assert.Equal(t, models.Attachment{
+				ID:              a.ID,
+				Username:        tt.username,
+				Key:             tt.key,
+				Filename:        "cat.png",
+				ContentType:     "image/png",
+				Size:            42,
+				Created:         a.Created,
+				Width:           640,
+				Height:          480,
+				ThumbnailKey:    tt.key + "_thumbnail",
+				ThumbnailWidth:  320,
+				ThumbnailHeight: 240,
+			}, got)
+		})
+	}
+}
+
+func TestAttachmentModel_GetNonExistent(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := AttachmentModel{DB: db}
+
+	_, err := m.Get(42)
+	assert.Equal(t, models.ErrNoRecord, err)
+}
+
+func TestMessageModel_InsertAttachments(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	attachments := AttachmentModel{DB: db}
+	messages := MessageModel{DB: db}
+
+	var ids []int64
+	for _, a := range []struct{ username, key string }{
+		{testUser.Username, "first"},
+		{testUser.Username, "second"},
+		{"Ann", "third"},
+	} {
+		attachment, err := attachments.Insert(models.Attachment{
+			Username:    a.username,
+			Key:         a.key,
+			Filename:    a.key + ".txt",
+			ContentType: "text/plain; charset=utf-8",
+			Size:        1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, attachment.ID)
+	}
+
+	// attachments of other users can't be sent
+	_, err := messages.Insert(firstTestMessage.RoomID, 0, "", testUser.Username, "", ids, time.Now())
+	assert.Equal(t, models.ErrInvalidAttachment, err)
+
+	msg, err := messages.Insert(firstTestMessage.RoomID, 0, "", testUser.Username, "", ids[:2], time.Now())
+	assert.NoError(t, err)
+	if assert.Len(t, msg.Attachments, 2) {
+		assert.Equal(t, ids[0], msg.Attachments[0].ID)
+		assert.Equal(t, msg.ID, msg.Attachments[0].MessageID)
+		assert.Equal(t, "first.txt", msg.Attachments[0].Filename)
+		assert.Equal(t, ids[1], msg.Attachments[1].ID)
+	}
+
+	// attachments can be sent only once
+	_, err = messages.Insert(firstTestMessage.RoomID, 0, "", testUser.Username, "", ids[:1], time.Now())
+	assert.Equal(t, models.ErrInvalidAttachment, err)
+
+	a, err := attachments.Get(ids[0])
+	assert.NoError(t, err)
+	assert.Equal(t, msg.ID, a.MessageID)
+}
@@ -0,0 +1,118 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/rs/zerolog/log"
+)
+
+// Time to wait before re-establishing the lost listening connection.
+const brokerReconnectWait = time.Second
+
+// Broker delivers payloads between application instances
+// that share the database using LISTEN/NOTIFY.
+type Broker struct {
+	db       *sql.DB
+	channel  string
+	messages chan []byte
+}
+
+// NewBroker initializes new instance of the Broker that
+// uses provided notification channel.
+func NewBroker(db *sql.DB, channel string) *Broker {
+	return &Broker{
+		db:       db,
+		channel:  channel,
+		messages: make(chan []byte, 256),
+	}
+}
+
+// Start starts listening on the notification channel. Notifications are
+// received in the background until ctx is done; if the connection is lost,
+// it is re-established and notifications sent in the meantime are lost.
+func (b *Broker) Start(ctx context.Context) error {
+	conn, err := b.listen(ctx)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			err := b.receive(ctx, conn)
+			b.release(conn)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Err(err).Msg("lost connection listening for notifications")
+
+			for {
+				time.Sleep(brokerReconnectWait)
+
+				conn, err = b.listen(ctx)
+				if err == nil {
+					break
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				log.Err(err).Msg("error listening for notifications")
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Publish sends the payload to all listening instances.
+// Payload must be shorter than 8000 bytes, longer ones are rejected.
+func (b *Broker) Publish(payload []byte) error {
+	_, err := b.db.Exec(`SELECT pg_notify($1, $2);`, b.channel, string(payload))
+	return err
+}
+
+// Messages returns channel of received payloads.
+func (b *Broker) Messages() <-chan []byte {
+	return b.messages
+}
+
+func (b *Broker) listen(ctx context.Context) (*pgx.Conn, error) {
+	conn, err := stdlib.AcquireConn(b.db)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{b.channel}.Sanitize())
+	if err != nil {
+		_ = stdlib.ReleaseConn(b.db, conn)
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// release returns the listening connection to the pool.
+func (b *Broker) release(conn *pgx.Conn) {
+	if !conn.IsClosed() {
+		_, _ = conn.Exec(context.Background(), "UNLISTEN *")
+	}
+	_ = stdlib.ReleaseConn(b.db, conn)
+}
+
+func (b *Broker) receive(ctx context.Context, conn *pgx.Conn) error {
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case b.messages <- []byte(n.Payload):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
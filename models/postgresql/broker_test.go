@@ -0,0 +1,130 @@
+package postgresql
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lazy-void/chatapp/chat"
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBroker_Publish(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	brokers := []*Broker{NewBroker(db, "test_chat"), NewBroker(db, "test_chat")}
+	for _, b := range brokers {
+		err := b.Start(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := brokers[0].Publish([]byte("payload"))
+	assert.NoError(t, err)
+
+	// the publisher receives its own payload too
+	for _, b := range brokers {
+		select {
+		case payload := <-b.Messages():
+			assert.Equal(t, "payload", string(payload))
+		case <-time.After(time.Second):
+			t.Fatal("payload was not received")
+		}
+	}
+}
+
+func TestBroker_SeveralHubs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// every hub gets its own server, as if they were different instances
+	var servers []*httptest.Server
+	for i := 0; i < 2; i++ {
+		broker := NewBroker(db, "test_chat")
+		err := broker.Start(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hub := chat.NewHub(&MessageModel{DB: db}, &RoomModel{DB: db}, &UserModel{DB: db}, chat.HubOptions{Broker: broker})
+		go hub.Run()
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := models.User{Username: r.URL.Query().Get("user")}
+			chat.ServeWS(hub, w, r.WithContext(context.WithValue(r.Context(), chat.ContextUserKey, user)))
+		}))
+		defer ts.Close()
+
+		servers = append(servers, ts)
+	}
+
+	george := dialTestHub(t, servers[0], testUser.Username)
+	ann := dialTestHub(t, servers[1], "Ann")
+
+	err := george.WriteJSON(chat.Request{Action: "direct", To: "Ann", Message: "Hi, Ann!"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var update chat.Update
+	err = ann.SetReadDeadline(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ann.ReadJSON(&update)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if assert.Len(t, update.Messages, 1) {
+		assert.Equal(t, "Hi, Ann!", update.Messages[0].Text)
+		assert.Equal(t, testUser.Username, update.Messages[0].Username)
+		assert.Equal(t, directTestRoom.ID, update.Messages[0].Room)
+	}
+}
+
+// dialTestHub connects to the hub as the user and waits until
+// the client is registered.
+func dialTestHub(t *testing.T, ts *httptest.Server, username string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "?user=" + username
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	err = conn.WriteJSON(chat.Request{Action: "listRooms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp chat.Response
+	err = conn.ReadJSON(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
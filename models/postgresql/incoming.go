@@ -0,0 +1,131 @@
+package postgresql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+)
+
+// IncomingWebhookModel implements methods for working
+// with incoming_webhooks table.
+type IncomingWebhookModel struct {
+	DB *sql.DB
+}
+
+// Insert creates the bot named after the webhook, makes it a member
+// of the room and adds the webhook. It returns the token of the webhook,
+// only its hash is stored. ErrDuplicateUsername is returned if the
+// username is taken, and ErrInvalidRoom if the room doesn't exist.
+func (m *IncomingWebhookModel) Insert(w models.IncomingWebhook) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO users (username, bot, creator, nickname) VALUES ($1, true, $2, NULLIF($3, ''));`
+	_, err = tx.Exec(stmt, w.Username, w.Creator, w.Name)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation && pgErr.ConstraintName == "users_pkey" {
+		return "", models.ErrDuplicateUsername
+	}
+	if err != nil {
+		return "", err
+	}
+
+	stmt = `INSERT INTO room_members (room_id, username) SELECT id, $2::varchar FROM rooms WHERE id = $1 AND NOT direct;`
+	res, err := tx.Exec(stmt, w.RoomID, w.Username)
+	if err != nil {
+		return "", err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", models.ErrInvalidRoom
+	}
+
+	stmt = `INSERT INTO incoming_webhooks (token_hash, room_id, username, creator) VALUES ($1, $2, $3, $4);`
+	_, err = tx.Exec(stmt, hash, w.RoomID, w.Username, w.Creator)
+	if err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit()
+}
+
+// All returns all incoming webhooks, oldest first.
+func (m *IncomingWebhookModel) All() ([]models.IncomingWebhook, error) {
+	stmt := `SELECT w.id, w.room_id, w.username, COALESCE(u.nickname, ''), w.creator, w.created
+	FROM incoming_webhooks w JOIN users u ON u.username = w.username
+	ORDER BY w.id;`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []models.IncomingWebhook
+	for rows.Next() {
+		var w models.IncomingWebhook
+		err := rows.Scan(&w.ID, &w.RoomID, &w.Username, &w.Name, &w.Creator, &w.Created)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Delete removes the webhook, so that its token no longer works.
+// The bot stays, since it is the author of the posted messages.
+func (m *IncomingWebhookModel) Delete(id int64) error {
+	res, err := m.DB.Exec(`DELETE FROM incoming_webhooks WHERE id = $1;`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// Authenticate returns the webhook with the token.
+// ErrInvalidToken is returned if no webhook has it.
+func (m *IncomingWebhookModel) Authenticate(token string) (models.IncomingWebhook, error) {
+	stmt := `SELECT w.id, w.room_id, w.username, COALESCE(u.nickname, ''), w.creator, w.created
+	FROM incoming_webhooks w JOIN users u ON u.username = w.username
+	WHERE w.token_hash = $1;`
+
+	var w models.IncomingWebhook
+	err := m.DB.QueryRow(stmt, hashToken(token)).Scan(&w.ID, &w.RoomID, &w.Username, &w.Name, &w.Creator, &w.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.IncomingWebhook{}, models.ErrInvalidToken
+	} else if err != nil {
+		return models.IncomingWebhook{}, err
+	}
+
+	return w, nil
+}
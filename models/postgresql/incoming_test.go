@@ -0,0 +1,87 @@
+package postgresql
+
+import (
+	"testing"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncomingWebhookModel_Insert(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		webhook   models.IncomingWebhook
+		wantError error
+	}{
+		{
+			name: "Valid",
+			webhook: models.IncomingWebhook{RoomID: models.DefaultRoomID, Username: "ci", Name: "CI",
+				Creator: testUser.Username},
+			wantError: nil,
+		},
+		{
+			name: "Duplicate username",
+			webhook: models.IncomingWebhook{RoomID: models.DefaultRoomID, Username: testUser.Username,
+				Creator: testUser.Username},
+			wantError: models.ErrDuplicateUsername,
+		},
+		{
+			name:      "Non-existent room",
+			webhook:   models.IncomingWebhook{RoomID: 42, Username: "ci", Creator: testUser.Username},
+			wantError: models.ErrInvalidRoom,
+		},
+		{
+			name:      "Direct room",
+			webhook:   models.IncomingWebhook{RoomID: 3, Username: "ci", Creator: testUser.Username},
+			wantError: models.ErrInvalidRoom,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := IncomingWebhookModel{DB: db}
+
+			token, err := m.Insert(tt.webhook)
+
+			assert.Equal(t, tt.wantError, err)
+			if err != nil {
+				// the bot is not created either
+				if tt.webhook.Username != testUser.Username {
+					_, err := (&UserModel{DB: db}).Get(tt.webhook.Username)
+					assert.Equal(t, models.ErrNoRecord, err)
+				}
+				return
+			}
+
+			w, err := m.Authenticate(token)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.webhook.RoomID, w.RoomID)
+			assert.Equal(t, tt.webhook.Username, w.Username)
+			assert.Equal(t, tt.webhook.Name, w.Name)
+
+			ok, err := (&RoomModel{DB: db}).IsMember(tt.webhook.RoomID, tt.webhook.Username)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+
+			all, err := m.All()
+			assert.NoError(t, err)
+			if assert.Len(t, all, 1) {
+				assert.Equal(t, w, all[0])
+			}
+
+			assert.NoError(t, m.Delete(w.ID))
+			assert.Equal(t, models.ErrNoRecord, m.Delete(w.ID))
+
+			_, err = m.Authenticate(token)
+			assert.Equal(t, models.ErrInvalidToken, err)
+		})
+	}
+}
@@ -2,7 +2,10 @@ package postgresql
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"html"
+	"strings"
 	"time"
 
 	"github.com/lazy-void/chatapp/models"
@@ -16,38 +19,517 @@ type MessageModel struct {
 	DB *sql.DB
 }
 
-// Insert adds message to the database. In case of success id of added message is returned.
-func (m *MessageModel) Insert(text string, username string, created time.Time) (int, error) {
-	stmt := `INSERT INTO messages(text, username, created)
-	VALUES($1, $2, $3)
-	RETURNING id;`
+// Columns of the messages table scanned by scanMessage.
+const messageColumns = `m.id, m.room_id, m.username, m.text, m.created, COALESCE(m.client_key, ''), m.edited, m.deleted,
+	(SELECT json_agg(mn.username ORDER BY mn.username) FROM mentions mn WHERE mn.message_id = m.id),
+	COALESCE(m.parent_id, 0), (SELECT count(*) FROM messages r WHERE r.parent_id = m.id AND NOT r.deleted),
+	(SELECT json_agg(json_build_object('emoji', r.emoji, 'count', r.count, 'usernames', r.usernames) ORDER BY r.emoji)
+		FROM (SELECT emoji, count(*), json_agg(username ORDER BY username) AS usernames
+			FROM message_reactions WHERE message_id = m.id GROUP BY emoji) r),
+	(SELECT json_agg(json_build_object('id', a.id, 'messageId', a.message_id, 'username', a.username,
+		'filename', a.filename, 'contentType', a.content_type, 'size', a.size, 'width', a.width, 'height', a.height,
+		'thumbnailKey', a.thumbnail_key, 'thumbnailWidth', a.thumbnail_width, 'thumbnailHeight', a.thumbnail_height)
+		ORDER BY a.id)
+		FROM attachments a WHERE a.message_id = m.id),
+	(SELECT json_agg(json_build_object('url', p.url, 'title', p.title, 'description', p.description, 'image', p.image)
+		ORDER BY mp.position)
+		FROM message_previews mp JOIN link_previews p ON p.url = mp.url WHERE mp.message_id = m.id),
+	(SELECT COALESCE(u.nickname, '') FROM users u WHERE u.username = m.username),
+	(SELECT u.bot FROM users u WHERE u.username = m.username)`
 
-	var id int
-	err := m.DB.QueryRow(stmt, text, username, created).Scan(&id)
+// Insert adds message to the room and returns it as it was stored.
+// If parentID is not 0, the message replies in the thread started by
+// that message, which must be in the same room. Replies to replies
+// are added to the same thread. Attachments must be uploaded by the
+// user and not attached to other messages. If key is not empty and
+// the user has already sent a message with the same key, nothing is
+// inserted: the earlier message is returned along with ErrDuplicateKey.
+// The message is queued for delivery to the webhooks matching it
+// in the same transaction, so that it is never lost.
+func (m *MessageModel) Insert(roomID, parentID int64, text, username, key string, attachments []int64,
+	created time.Time) (models.Message, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return models.Message{}, err
+	}
+	defer tx.Rollback()
+
+	if parentID != 0 {
+		var (
+			parentRoom int64
+			thread     sql.NullInt64
+		)
+		stmt := `SELECT room_id, parent_id FROM messages WHERE id = $1 AND NOT deleted;`
+		err := tx.QueryRow(stmt, parentID).Scan(&parentRoom, &thread)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && parentRoom != roomID {
+			return models.Message{}, models.ErrInvalidParent
+		} else if err != nil {
+			return models.Message{}, err
+		}
+
+		if thread.Valid {
+			parentID = thread.Int64
+		}
+	}
+
+	stmt := `INSERT INTO messages AS m (room_id, parent_id, text, username, client_key, created)
+	VALUES($1, NULLIF($2, 0), $3, $4, NULLIF($5, ''), $6)
+	ON CONFLICT (username, client_key) DO NOTHING
+	RETURNING ` + messageColumns + `;`
+
+	msg, err := scanMessage(tx.QueryRow(stmt, roomID, parentID, text, username, key, created))
 	var pgErr *pgconn.PgError
-	if errors.As(err, &pgErr) {
-		if pgErr.Code == pgerrcode.ForeignKeyViolation && pgErr.ConstraintName == "messages_username_fkey" {
-			return 0, models.ErrInvalidUsername
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+		switch pgErr.ConstraintName {
+		case "messages_username_fkey":
+			return models.Message{}, models.ErrInvalidUsername
+		case "messages_room_id_fkey":
+			return models.Message{}, models.ErrInvalidRoom
+		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		// the key is taken
+		stmt := `SELECT ` + messageColumns + ` FROM messages m WHERE username = $1 AND client_key = $2;`
+
+		msg, err = scanMessage(tx.QueryRow(stmt, username, key))
+		if err != nil {
+			return models.Message{}, err
+		}
+
+		return msg, models.ErrDuplicateKey
+	}
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	if len(attachments) > 0 {
+		stmt := `UPDATE attachments SET message_id = $1 WHERE id = $2 AND username = $3 AND message_id IS NULL;`
+		for _, id := range attachments {
+			res, err := tx.Exec(stmt, msg.ID, id, username)
+			if err != nil {
+				return models.Message{}, err
+			}
+
+			n, err := res.RowsAffected()
+			if err != nil {
+				return models.Message{}, err
+			}
+			if n == 0 {
+				return models.Message{}, models.ErrInvalidAttachment
+			}
+		}
+
+		// reload the message with its attachments
+		stmt = `SELECT ` + messageColumns + ` FROM messages m WHERE id = $1;`
+		msg, err = scanMessage(tx.QueryRow(stmt, msg.ID))
+		if err != nil {
+			return models.Message{}, err
 		}
 	}
+
+	// messages of direct rooms are private, so they are never queued
+	stmt = `INSERT INTO webhook_deliveries (webhook_id, message_id)
+	SELECT w.id, $1 FROM webhooks w
+	JOIN rooms r ON r.id = $2 AND NOT r.direct
+	WHERE (w.room_id IS NULL OR w.room_id = r.id)
+	AND (w.keyword = '' OR strpos(lower($3), lower(w.keyword)) > 0);`
+
+	_, err = tx.Exec(stmt, msg.ID, roomID, text)
 	if err != nil {
-		return 0, err
+		return models.Message{}, err
+	}
+
+	return msg, tx.Commit()
+}
+
+// Get returns the message with provided id.
+func (m *MessageModel) Get(id int64) (models.Message, error) {
+	stmt := `SELECT ` + messageColumns + ` FROM messages m WHERE id = $1;`
+
+	msg, err := scanMessage(m.DB.QueryRow(stmt, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Message{}, models.ErrNoRecord
 	}
 
-	return id, nil
+	return msg, err
 }
 
-// Latest sorts all messages in descending order of creation time,
-// skips the first few at the given offset, and returns n objects.
-// Useful for loading message history.
-func (m *MessageModel) Latest(n, offset int) ([]models.Message, error) {
-	stmt := `SELECT m.id, username, text, m.created
+// Before returns n latest messages of the room that were sent
+// before the message with provided id, newest first. If id is 0,
+// the latest messages of the room are returned. Replies are
+// left to their threads. Useful for loading message history.
+func (m *MessageModel) Before(roomID, id int64, n int) ([]models.Message, error) {
+	stmt := `SELECT ` + messageColumns + `
 	FROM messages m
-	ORDER BY created DESC
-	OFFSET $1
- 	LIMIT $2;`
+	WHERE room_id = $1 AND parent_id IS NULL AND ($2 = 0 OR id < $2)
+	ORDER BY id DESC
+	LIMIT $3;`
+
+	return m.query(stmt, roomID, id, n)
+}
 
-	rows, err := m.DB.Query(stmt, offset, n)
+// Thread returns the message with provided id followed by
+// n latest replies in the thread it has started, oldest first.
+func (m *MessageModel) Thread(id int64, n int) ([]models.Message, error) {
+	parent, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if parent.ParentID != 0 {
+		// the message is a reply itself
+		return nil, models.ErrNoRecord
+	}
+
+	stmt := `SELECT ` + messageColumns + `
+	FROM messages m
+	WHERE parent_id = $1
+	ORDER BY id DESC
+	LIMIT $2;`
+
+	replies, err := m.query(stmt, id, n)
+	if err != nil {
+		return nil, err
+	}
+
+	thread := make([]models.Message, 0, len(replies)+1)
+	thread = append(thread, parent)
+	for i := len(replies) - 1; i >= 0; i-- {
+		thread = append(thread, replies[i])
+	}
+
+	return thread, nil
+}
+
+// After returns n messages sent after the message with provided id
+// in all rooms the user is a member of, oldest first. Replies are
+// left to their threads.
+func (m *MessageModel) After(username string, id int64, n int) ([]models.Message, error) {
+	stmt := `SELECT ` + messageColumns + `
+	FROM messages m
+	JOIN room_members rm ON rm.room_id = m.room_id AND rm.username = $1
+	WHERE m.id > $2 AND m.parent_id IS NULL
+	ORDER BY m.id
+	LIMIT $3;`
+
+	return m.query(stmt, username, id, n)
+}
+
+// Changed returns n latest messages sent up to the message with provided id
+// in all rooms the user is a member of that have been edited, deleted,
+// reacted to, unfurled or replied to after it was stored, newest first.
+// Replies are left to their threads. Both times come from the database
+// clock, created can be set by the caller.
+func (m *MessageModel) Changed(username string, id int64, n int) ([]models.Message, error) {
+	stmt := `SELECT ` + messageColumns + `
+	FROM messages m
+	JOIN room_members rm ON rm.room_id = m.room_id AND rm.username = $1
+	WHERE m.id <= $2 AND m.parent_id IS NULL
+	AND (m.changed > (SELECT stored FROM messages WHERE id = $2)
+		OR EXISTS(SELECT 1 FROM messages r WHERE r.parent_id = m.id AND r.id > $2))
+	ORDER BY m.id DESC
+	LIMIT $3;`
+
+	return m.query(stmt, username, id, n)
+}
+
+// Mention remembers that the users are mentioned in the message.
+// Users that are already mentioned are skipped.
+func (m *MessageModel) Mention(id int64, usernames []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO mentions(message_id, username) VALUES($1, $2)
+	ON CONFLICT DO NOTHING;`
+
+	for _, username := range usernames {
+		_, err := tx.Exec(stmt, id, username)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+			switch pgErr.ConstraintName {
+			case "mentions_message_id_fkey":
+				return models.ErrNoRecord
+			case "mentions_username_fkey":
+				return models.ErrInvalidUsername
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AddPreviews links previews of the pages to the message in the given order
+// and returns the message. The previews must be saved by PreviewModel.
+// Deleted messages get no previews.
+func (m *MessageModel) AddPreviews(id int64, urls []string) (models.Message, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return models.Message{}, err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO message_previews(message_id, url, position)
+	SELECT id, $2, $3 FROM messages WHERE id = $1 AND NOT deleted
+	ON CONFLICT DO NOTHING;`
+
+	for i, url := range urls {
+		_, err := tx.Exec(stmt, id, url, i)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation &&
+			pgErr.ConstraintName == "message_previews_url_fkey" {
+			return models.Message{}, models.ErrNoRecord
+		}
+		if err != nil {
+			return models.Message{}, err
+		}
+	}
+
+	stmt = `UPDATE messages m SET changed = now()
+	WHERE id = $1 AND NOT deleted
+	RETURNING ` + messageColumns + `;`
+	msg, err := scanMessage(tx.QueryRow(stmt, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Message{}, models.ErrNoRecord
+	} else if err != nil {
+		return models.Message{}, err
+	}
+
+	return msg, tx.Commit()
+}
+
+// Mentioned returns n latest messages that mention the user, newest first.
+// Deleted messages and messages of the rooms the user is no longer
+// a member of are skipped.
+func (m *MessageModel) Mentioned(username string, n int) ([]models.Message, error) {
+	stmt := `SELECT ` + messageColumns + `
+	FROM messages m
+	JOIN mentions mn ON mn.message_id = m.id AND mn.username = $1
+	JOIN room_members rm ON rm.room_id = m.room_id AND rm.username = $1
+	WHERE NOT m.deleted
+	ORDER BY m.id DESC
+	LIMIT $2;`
+
+	return m.query(stmt, username, n)
+}
+
+// React adds reaction of the user with the emoji to the message
+// and returns the message. Reacting with the same emoji again
+// changes nothing. Deleted messages can't be reacted to.
+func (m *MessageModel) React(id int64, username, emoji string) (models.Message, error) {
+	stmt := `WITH reacted AS (
+		INSERT INTO message_reactions(message_id, username, emoji)
+		SELECT id, $2, $3 FROM messages WHERE id = $1 AND NOT deleted
+		ON CONFLICT DO NOTHING
+		RETURNING message_id
+	)
+	UPDATE messages SET changed = now() WHERE id IN (SELECT message_id FROM reacted);`
+
+	_, err := m.DB.Exec(stmt, id, username, emoji)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation &&
+		pgErr.ConstraintName == "message_reactions_username_fkey" {
+		return models.Message{}, models.ErrInvalidUsername
+	}
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	return m.getReacted(id)
+}
+
+// Unreact removes reaction of the user with the emoji from
+// the message and returns the message.
+func (m *MessageModel) Unreact(id int64, username, emoji string) (models.Message, error) {
+	stmt := `WITH unreacted AS (
+		DELETE FROM message_reactions WHERE message_id = $1 AND username = $2 AND emoji = $3
+		RETURNING message_id
+	)
+	UPDATE messages SET changed = now() WHERE id IN (SELECT message_id FROM unreacted);`
+
+	_, err := m.DB.Exec(stmt, id, username, emoji)
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	return m.getReacted(id)
+}
+
+// getReacted returns the message after its reactions have changed.
+func (m *MessageModel) getReacted(id int64) (models.Message, error) {
+	msg, err := m.Get(id)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if msg.Deleted {
+		return models.Message{}, models.ErrNoRecord
+	}
+
+	return msg, nil
+}
+
+// Search returns n messages that match the query best from the rooms
+// the user is a member of, with the found words highlighted.
+// Deleted messages are never found.
+func (m *MessageModel) Search(username string, q models.SearchQuery, n int) ([]models.SearchResult, error) {
+	stmt := `SELECT ` + messageColumns + `,
+		ts_headline('english', m.text, q, $8), ts_rank(m.search, q) AS rank
+	FROM messages m
+	JOIN room_members rm ON rm.room_id = m.room_id AND rm.username = $1,
+		websearch_to_tsquery('english', $2) q
+	WHERE m.search @@ q AND NOT m.deleted
+		AND ($3 = 0 OR m.room_id = $3)
+		AND ($4 = '' OR m.username = $4)
+		AND ($5::timestamptz IS NULL OR m.created >= $5)
+		AND ($6::timestamptz IS NULL OR m.created < $6)
+	ORDER BY rank DESC, m.id DESC
+	LIMIT $7;`
+
+	rows, err := m.DB.Query(stmt, username, q.Text, q.RoomID, q.Author, nullTime(q.Since), nullTime(q.Until), n,
+		"StartSel="+headlineStart+", StopSel="+headlineStop)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.SearchResult
+	for rows.Next() {
+		var r models.SearchResult
+		r.Message, err = scanMessage(rows, &r.Headline, &r.Rank)
+		if err != nil {
+			return nil, err
+		}
+		r.Headline = highlight(r.Headline)
+
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Found words are marked by control characters in headlines,
+// since the text itself may contain any tags.
+const (
+	headlineStart = "\x02"
+	headlineStop  = "\x03"
+)
+
+// highlight escapes the headline and replaces marks of the found words with tags.
+func highlight(headline string) string {
+	headline = html.EscapeString(headline)
+	headline = strings.ReplaceAll(headline, headlineStart, "<mark>")
+	return strings.ReplaceAll(headline, headlineStop, "</mark>")
+}
+
+// Edit replaces text of the message on behalf of the user and
+// returns the edited message. Only the author of the message can edit it.
+// Deleted messages can't be edited. Mentions and previews of the old text
+// are removed, the ones of the new text should be added again.
+func (m *MessageModel) Edit(id int64, username, text string, edited time.Time) (models.Message, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return models.Message{}, err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE messages SET text = $3, edited = $4, changed = now()
+	WHERE id = $1 AND username = $2 AND NOT deleted;`
+
+	res, err := tx.Exec(stmt, id, username, text, edited)
+	if err != nil {
+		return models.Message{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return models.Message{}, err
+	}
+	if n == 0 {
+		return models.Message{}, m.notUpdated(id)
+	}
+
+	for _, stmt := range []string{
+		`DELETE FROM mentions WHERE message_id = $1;`,
+		`DELETE FROM message_previews WHERE message_id = $1;`,
+	} {
+		_, err := tx.Exec(stmt, id)
+		if err != nil {
+			return models.Message{}, err
+		}
+	}
+
+	stmt = `SELECT ` + messageColumns + ` FROM messages m WHERE id = $1;`
+	msg, err := scanMessage(tx.QueryRow(stmt, id))
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	return msg, tx.Commit()
+}
+
+// Delete marks the message as deleted on behalf of the user and
+// returns it. Only the author of the message can delete it.
+func (m *MessageModel) Delete(id int64, username string) (models.Message, error) {
+	stmt := `UPDATE messages m SET deleted = true, changed = now()
+	WHERE id = $1 AND username = $2 AND NOT deleted
+	RETURNING ` + messageColumns + `;`
+
+	return m.update(id, username, stmt)
+}
+
+// Remove deletes the message regardless of its author, e.g. when
+// a moderator removes it. ErrNoRecord is returned if the message
+// doesn't exist or is already deleted.
+func (m *MessageModel) Remove(id int64) (models.Message, error) {
+	stmt := `UPDATE messages m SET deleted = true, changed = now()
+	WHERE id = $1 AND NOT deleted
+	RETURNING ` + messageColumns + `;`
+
+	msg, err := scanMessage(m.DB.QueryRow(stmt, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Message{}, models.ErrNoRecord
+	} else if err != nil {
+		return models.Message{}, err
+	}
+
+	return msg, nil
+}
+
+// update runs the statement that changes the message with provided id
+// written by the user. Statement must return the changed message.
+func (m *MessageModel) update(id int64, username, stmt string, args ...interface{}) (models.Message, error) {
+	msg, err := scanMessage(m.DB.QueryRow(stmt, append([]interface{}{id, username}, args...)...))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return msg, err
+	}
+
+	return models.Message{}, m.notUpdated(id)
+}
+
+// notUpdated finds out why the message with provided id
+// was not updated on behalf of the user.
+func (m *MessageModel) notUpdated(id int64) error {
+	var author string
+	err := m.DB.QueryRow(`SELECT username FROM messages WHERE id = $1 AND NOT deleted;`, id).Scan(&author)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return models.ErrNoRecord
+	case err != nil:
+		return err
+	default:
+		return models.ErrNotAuthor
+	}
+}
+
+// query runs the statement that selects messageColumns.
+func (m *MessageModel) query(stmt string, args ...interface{}) ([]models.Message, error) {
+	rows, err := m.DB.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -55,8 +537,7 @@ func (m *MessageModel) Latest(n, offset int) ([]models.Message, error) {
 
 	var messages []models.Message
 	for rows.Next() {
-		msg := models.Message{}
-		err := rows.Scan(&msg.ID, &msg.Username, &msg.Text, &msg.Created)
+		msg, err := scanMessage(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -69,3 +550,58 @@ func (m *MessageModel) Latest(n, offset int) ([]models.Message, error) {
 
 	return messages, nil
 }
+
+// scanMessage scans messageColumns of the row into the message.
+// Columns selected after them are scanned into extra.
+func scanMessage(row interface{ Scan(...interface{}) error }, extra ...interface{}) (models.Message, error) {
+	msg := models.Message{}
+	var (
+		edited      sql.NullTime
+		mentions    []byte
+		reactions   []byte
+		attachments []byte
+		previews    []byte
+	)
+	dest := []interface{}{&msg.ID, &msg.RoomID, &msg.Username, &msg.Text, &msg.Created, &msg.Key, &edited, &msg.Deleted,
+		&mentions, &msg.ParentID, &msg.Replies, &reactions, &attachments, &previews, &msg.Nickname, &msg.Bot}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return models.Message{}, err
+	}
+	msg.Edited = edited.Time
+
+	if mentions != nil {
+		err = json.Unmarshal(mentions, &msg.Mentions)
+		if err != nil {
+			return models.Message{}, err
+		}
+	}
+
+	// keys of the aggregated objects match the fields of models.Reaction,
+	// models.Attachment and models.Preview
+	if reactions != nil {
+		err = json.Unmarshal(reactions, &msg.Reactions)
+		if err != nil {
+			return models.Message{}, err
+		}
+	}
+	if attachments != nil {
+		err = json.Unmarshal(attachments, &msg.Attachments)
+		if err != nil {
+			return models.Message{}, err
+		}
+	}
+	if previews != nil {
+		err = json.Unmarshal(previews, &msg.Previews)
+		if err != nil {
+			return models.Message{}, err
+		}
+	}
+
+	return msg, nil
+}
+
+// nullTime converts zero time to NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
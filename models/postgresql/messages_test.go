@@ -12,12 +12,14 @@ import (
 var (
 	firstTestMessage = models.Message{
 		ID:       1,
+		RoomID:   1,
 		Username: testUser.Username,
 		Text:     "Hello World",
 		Created:  time.Date(2021, time.June, 13, 15, 0, 0, 0, time.UTC).Local(),
 	}
 	secondTestMessage = models.Message{
 		ID:       2,
+		RoomID:   1,
 		Username: testUser.Username,
 		Text:     "Very loooooooooooooooooooooooooooooooooooooooooooong message!",
 		Created:  time.Date(2021, time.June, 13, 15, 0, 0, 0, time.UTC).Local(),
@@ -31,12 +33,14 @@ func TestMessageModel_Insert(t *testing.T) {
 
 	tests := []struct {
 		name           string
+		roomID         int64
 		text, username string
 		created        time.Time
 		wantError      error
 	}{
 		{
 			name:      "Correct message",
+			roomID:    1,
 			text:      "Message text",
 			username:  testUser.Username,
 			created:   time.Now(),
@@ -44,6 +48,7 @@ func TestMessageModel_Insert(t *testing.T) {
 		},
 		{
 			name:      "Empty text",
+			roomID:    1,
 			text:      "",
 			username:  testUser.Username,
 			created:   time.Now(),
@@ -51,6 +56,7 @@ func TestMessageModel_Insert(t *testing.T) {
 		},
 		{
 			name:      "Empty username",
+			roomID:    1,
 			text:      "Message text",
 			username:  "",
 			created:   time.Now(),
@@ -58,6 +64,7 @@ func TestMessageModel_Insert(t *testing.T) {
 		},
 		{
 			name:      "Zero time",
+			roomID:    1,
 			text:      "Message text",
 			username:  testUser.Username,
 			created:   time.Time{},
@@ -65,11 +72,20 @@ func TestMessageModel_Insert(t *testing.T) {
 		},
 		{
 			name:      "Non-existent username",
+			roomID:    1,
 			text:      "Message text",
 			username:  "random username",
 			created:   time.Now(),
 			wantError: models.ErrInvalidUsername,
 		},
+		{
+			name:      "Non-existent room",
+			roomID:    42,
+			text:      "Message text",
+			username:  testUser.Username,
+			created:   time.Now(),
+			wantError: models.ErrInvalidRoom,
+		},
 	}
 
 	for _, tt := range tests {
@@ -79,27 +95,68 @@ func TestMessageModel_Insert(t *testing.T) {
 
 			m := MessageModel{DB: db}
 
-			_, err := m.Insert(tt.text, tt.username, tt.created)
+			msg, err := m.Insert(tt.roomID, 0, tt.text, tt.username, "", nil, tt.created)
 			assert.Equal(t, tt.wantError, err)
+			if err != nil {
+				return
+			}
+
+			assert.NotZero(t, msg.ID)
+			assert.Equal(t, tt.roomID, msg.RoomID)
+			assert.Equal(t, tt.text, msg.Text)
+			assert.Equal(t, tt.username, msg.Username)
 		})
 	}
 }
 
-func TestMessageModel_Latest(t *testing.T) {
+func TestMessageModel_InsertDuplicateKey(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := MessageModel{DB: db}
+
+	first, err := m.Insert(1, 0, "Message text", testUser.Username, "key", nil, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "key", first.Key)
+
+	// retry returns the stored message
+	retry, err := m.Insert(1, 0, "Message text", testUser.Username, "key", nil, time.Now())
+	assert.Equal(t, models.ErrDuplicateKey, err)
+	assert.Equal(t, first, retry)
+
+	// keys are unique for every user
+	other, err := m.Insert(1, 0, "Message text", "Ann", "key", nil, time.Now())
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.ID, other.ID)
+
+	// messages without keys are never duplicates
+	for i := 0; i < 2; i++ {
+		_, err := m.Insert(1, 0, "Message text", testUser.Username, "", nil, time.Now())
+		assert.NoError(t, err)
+	}
+}
+
+func TestMessageModel_Before(t *testing.T) {
 	if testing.Short() {
 		t.Skip("postgresql: skipping integration test")
 	}
 
 	tests := []struct {
 		name         string
-		n, offset    int
+		roomID, id   int64
+		n            int
 		wantMessages []models.Message
 		wantError    error
 	}{
 		{
 			name:   "n is smaller than number of elements",
+			roomID: 1,
+			id:     0,
 			n:      1,
-			offset: 0,
 			wantMessages: []models.Message{
 				secondTestMessage,
 			},
@@ -107,8 +164,9 @@ func TestMessageModel_Latest(t *testing.T) {
 		},
 		{
 			name:   "n is equal to number of elements",
+			roomID: 1,
+			id:     0,
 			n:      2,
-			offset: 0,
 			wantMessages: []models.Message{
 				secondTestMessage,
 				firstTestMessage,
@@ -117,8 +175,9 @@ func TestMessageModel_Latest(t *testing.T) {
 		},
 		{
 			name:   "n is bigger than number of elements",
+			roomID: 1,
+			id:     0,
 			n:      3,
-			offset: 0,
 			wantMessages: []models.Message{
 				secondTestMessage,
 				firstTestMessage,
@@ -126,25 +185,55 @@ func TestMessageModel_Latest(t *testing.T) {
 			wantError: nil,
 		},
 		{
-			name:   "Offset skips first",
+			name:   "Another room",
+			roomID: 2,
+			id:     0,
+			n:      3,
+			wantMessages: []models.Message{
+				{
+					ID:       3,
+					RoomID:   2,
+					Username: testUser.Username,
+					Text:     "Message in another room",
+					Created:  time.Date(2021, time.June, 13, 16, 0, 0, 0, time.UTC).Local(),
+				},
+			},
+			wantError: nil,
+		},
+		{
+			name:   "Cursor skips newer",
+			roomID: 1,
+			id:     secondTestMessage.ID,
 			n:      2,
-			offset: 1,
 			wantMessages: []models.Message{
 				firstTestMessage,
 			},
 			wantError: nil,
 		},
 		{
-			name:         "Offset skips all",
+			name:         "Cursor skips all",
+			roomID:       1,
+			id:           firstTestMessage.ID,
 			n:            2,
-			offset:       3,
 			wantMessages: nil,
 			wantError:    nil,
 		},
+		{
+			name:   "Cursor from another room",
+			roomID: 1,
+			id:     3,
+			n:      2,
+			wantMessages: []models.Message{
+				secondTestMessage,
+				firstTestMessage,
+			},
+			wantError: nil,
+		},
 		{
 			name:         "n is zero",
+			roomID:       1,
+			id:           0,
 			n:            0,
-			offset:       1,
 			wantMessages: nil,
 			wantError:    nil,
 		},
@@ -157,10 +246,779 @@ func TestMessageModel_Latest(t *testing.T) {
 
 			m := MessageModel{DB: db}
 
-			messages, err := m.Latest(tt.n, tt.offset)
+			messages, err := m.Before(tt.roomID, tt.id, tt.n)
 
 			assert.Equal(t, tt.wantError, err)
 			assert.Equal(t, tt.wantMessages, messages)
 		})
 	}
 }
+
+func TestMessageModel_After(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name         string
+		username     string
+		id           int64
+		n            int
+		wantMessages []models.Message
+	}{
+		{
+			name:     "All messages of joined rooms",
+			username: testUser.Username,
+			id:       0,
+			n:        10,
+			wantMessages: []models.Message{
+				firstTestMessage,
+				secondTestMessage,
+			},
+		},
+		{
+			name:     "Cursor skips older",
+			username: testUser.Username,
+			id:       firstTestMessage.ID,
+			n:        10,
+			wantMessages: []models.Message{
+				secondTestMessage,
+			},
+		},
+		{
+			name:     "n is smaller than number of elements",
+			username: testUser.Username,
+			id:       0,
+			n:        1,
+			wantMessages: []models.Message{
+				firstTestMessage,
+			},
+		},
+		{
+			name:         "Not a member of rooms with messages",
+			username:     "Ann",
+			id:           0,
+			n:            10,
+			wantMessages: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := MessageModel{DB: db}
+
+			messages, err := m.After(tt.username, tt.id, tt.n)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantMessages, messages)
+		})
+	}
+}
+
+func TestMessageModel_AfterSkipsReplies(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := MessageModel{DB: db}
+
+	_, err := m.Insert(firstTestMessage.RoomID, firstTestMessage.ID, "Reply", "Ann", "", nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := m.After(testUser.Username, secondTestMessage.ID, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestMessageModel_AfterSkipsOtherRooms(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := MessageModel{DB: db}
+
+	// George hasn't joined the room 2
+	_, err := m.Insert(2, 0, "Not joined", "Ann", "", nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined, err := m.Insert(firstTestMessage.RoomID, 0, "Joined", "Ann", "", nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := m.After(testUser.Username, secondTestMessage.ID, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, joined.ID, messages[0].ID)
+	}
+}
+
+func TestMessageModel_Changed(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := MessageModel{DB: db}
+
+	// the time of the message is set by the caller whose clock
+	// may be ahead of the database one
+	cursor, err := m.Insert(firstTestMessage.RoomID, 0, "Cursor", testUser.Username, "", nil, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := m.Changed(testUser.Username, cursor.ID, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+
+	// the first message gets a reply, the second one is deleted
+	_, err = m.Insert(firstTestMessage.RoomID, firstTestMessage.ID, "Reply", "Ann", "", nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Delete(secondTestMessage.ID, testUser.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err = m.Changed(testUser.Username, cursor.ID, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, secondTestMessage.ID, messages[0].ID)
+		assert.True(t, messages[0].Deleted)
+		assert.Equal(t, firstTestMessage.ID, messages[1].ID)
+		assert.Equal(t, 1, messages[1].Replies)
+	}
+
+	// users who are not members of the room don't see the changes
+	messages, err = m.Changed("Zed", cursor.ID, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+
+	// messages sent after the cursor are not changed ones
+	messages, err = m.Changed(testUser.Username, firstTestMessage.ID, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, firstTestMessage.ID, messages[0].ID)
+	}
+}
+
+func TestMessageModel_ChangedReactionsAndPreviews(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := MessageModel{DB: db}
+	previews := PreviewModel{DB: db}
+
+	err := previews.Save(models.Preview{URL: "https://example.org", Title: "Example", Fetched: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		change func() error
+	}{
+		{"React", func() error {
+			_, err := m.React(firstTestMessage.ID, "Ann", "👍")
+			return err
+		}},
+		{"Unreact", func() error {
+			_, err := m.Unreact(firstTestMessage.ID, "Ann", "👍")
+			return err
+		}},
+		{"AddPreviews", func() error {
+			_, err := m.AddPreviews(firstTestMessage.ID, []string{"https://example.org"})
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := m.Insert(firstTestMessage.RoomID, 0, "Cursor", testUser.Username, "", nil, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.NoError(t, tt.change())
+
+			messages, err := m.Changed(testUser.Username, cursor.ID, 10)
+			assert.NoError(t, err)
+			if assert.Len(t, messages, 1) {
+				assert.Equal(t, firstTestMessage.ID, messages[0].ID)
+			}
+		})
+	}
+}
+
+func TestMessageModel_Edit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	edited := time.Date(2021, time.June, 14, 15, 0, 0, 0, time.UTC).Local()
+	editedMessage := firstTestMessage
+	editedMessage.Text = "Hello, World!"
+	editedMessage.Edited = edited
+
+	tests := []struct {
+		name        string
+		id          int64
+		username    string
+		wantMessage models.Message
+		wantError   error
+	}{
+		{
+			name:        "Author edits message",
+			id:          firstTestMessage.ID,
+			username:    testUser.Username,
+			wantMessage: editedMessage,
+			wantError:   nil,
+		},
+		{
+			name:        "Not author edits message",
+			id:          firstTestMessage.ID,
+			username:    "Ann",
+			wantMessage: models.Message{},
+			wantError:   models.ErrNotAuthor,
+		},
+		{
+			name:        "Non-existent message",
+			id:          42,
+			username:    testUser.Username,
+			wantMessage: models.Message{},
+			wantError:   models.ErrNoRecord,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := MessageModel{DB: db}
+
+			msg, err := m.Edit(tt.id, tt.username, "Hello, World!", edited)
+
+			assert.Equal(t, tt.wantError, err)
+			assert.Equal(t, tt.wantMessage, msg)
+		})
+	}
+}
+
+func TestMessageModel_EditMentionsAndPreviews(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := MessageModel{DB: db}
+	rooms := RoomModel{DB: db}
+	previews := PreviewModel{DB: db}
+
+	err := previews.Save(models.Preview{URL: "https://example.org", Title: "Example", Fetched: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rooms.Join(firstTestMessage.RoomID, "Ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Mention(firstTestMessage.ID, []string{"Ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.AddPreviews(firstTestMessage.ID, []string{"https://example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// mentions and previews of the old text are gone
+	msg, err := m.Edit(firstTestMessage.ID, testUser.Username, "Hello", time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, msg.Mentions)
+	assert.Empty(t, msg.Previews)
+
+	mentioned, err := m.Mentioned("Ann", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, mentioned)
+}
+
+func TestMessageModel_Delete(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	deletedMessage := firstTestMessage
+	deletedMessage.Deleted = true
+
+	tests := []struct {
+		name        string
+		id          int64
+		username    string
+		wantMessage models.Message
+		wantError   error
+	}{
+		{
+			name:        "Author deletes message",
+			id:          firstTestMessage.ID,
+			username:    testUser.Username,
+			wantMessage: deletedMessage,
+			wantError:   nil,
+		},
+		{
+			name:        "Not author deletes message",
+			id:          firstTestMessage.ID,
+			username:    "Ann",
+			wantMessage: models.Message{},
+			wantError:   models.ErrNotAuthor,
+		},
+		{
+			name:        "Non-existent message",
+			id:          42,
+			username:    testUser.Username,
+			wantMessage: models.Message{},
+			wantError:   models.ErrNoRecord,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := MessageModel{DB: db}
+
+			msg, err := m.Delete(tt.id, tt.username)
+
+			assert.Equal(t, tt.wantError, err)
+			assert.Equal(t, tt.wantMessage, msg)
+		})
+	}
+}
+
+func TestMessageModel_Remove(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := MessageModel{DB: db}
+
+	deletedMessage := firstTestMessage
+	deletedMessage.Deleted = true
+
+	msg, err := m.Remove(firstTestMessage.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, deletedMessage, msg)
+
+	_, err = m.Remove(firstTestMessage.ID)
+	assert.Equal(t, models.ErrNoRecord, err)
+
+	_, err = m.Remove(42)
+	assert.Equal(t, models.ErrNoRecord, err)
+}
+
+func TestMessageModel_EditDeletedMessage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := MessageModel{DB: db}
+
+	_, err := m.Delete(firstTestMessage.ID, testUser.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Edit(firstTestMessage.ID, testUser.Username, "Hello, World!", time.Now())
+	assert.Equal(t, models.ErrNoRecord, err)
+
+	_, err = m.Delete(firstTestMessage.ID, testUser.Username)
+	assert.Equal(t, models.ErrNoRecord, err)
+
+	// deleted message is still returned, so that clients can show it was there
+	messages, err := m.Before(firstTestMessage.RoomID, 0, 2)
+	assert.NoError(t, err)
+	assert.Contains(t, messages, models.Message{
+		ID:       firstTestMessage.ID,
+		RoomID:   firstTestMessage.RoomID,
+		Username: firstTestMessage.Username,
+		Text:     firstTestMessage.Text,
+		Created:  firstTestMessage.Created,
+		Deleted:  true,
+	})
+}
+
+func TestMessageModel_Mention(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name         string
+		id           int64
+		usernames    []string
+		wantError    error
+		wantMentions []string
+	}{
+		{
+			name:         "Existing users",
+			id:           firstTestMessage.ID,
+			usernames:    []string{testUser.Username, "Ann", "Ann"},
+			wantError:    nil,
+			wantMentions: []string{"Ann", testUser.Username},
+		},
+		{
+			name:         "Non-existent user",
+			id:           firstTestMessage.ID,
+			usernames:    []string{"random username"},
+			wantError:    models.ErrInvalidUsername,
+			wantMentions: nil,
+		},
+		{
+			name:         "Non-existent message",
+			id:           42,
+			usernames:    []string{"Ann"},
+			wantError:    models.ErrNoRecord,
+			wantMentions: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := MessageModel{DB: db}
+
+			err := m.Mention(tt.id, tt.usernames)
+			assert.Equal(t, tt.wantError, err)
+
+			messages, err := m.Before(firstTestMessage.RoomID, secondTestMessage.ID, 1)
+			assert.NoError(t, err)
+			if assert.Len(t, messages, 1) {
+				assert.Equal(t, tt.wantMentions, messages[0].Mentions)
+			}
+		})
+	}
+}
+
+func TestMessageModel_Mentioned(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := MessageModel{DB: db}
+
+	// the third message is in the room George is not a member of
+	for _, id := range []int64{firstTestMessage.ID, secondTestMessage.ID, 3} {
+		err := m.Mention(id, []string{testUser.Username})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := m.Delete(secondTestMessage.ID, testUser.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := m.Mentioned(testUser.Username, 10)
+	assert.NoError(t, err)
+
+	want := firstTestMessage
+	want.Mentions = []string{testUser.Username}
+	assert.Equal(t, []models.Message{want}, messages)
+}
+
+func TestMessageModel_InsertReply(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name       string
+		roomID     int64
+		parentID   int64
+		wantParent int64
+		wantError  error
+	}{
+		{
+			name:       "Reply to message",
+			roomID:     firstTestMessage.RoomID,
+			parentID:   firstTestMessage.ID,
+			wantParent: firstTestMessage.ID,
+			wantError:  nil,
+		},
+		{
+			name:       "Reply to message in another room",
+			roomID:     firstTestMessage.RoomID,
+			parentID:   3,
+			wantParent: 0,
+			wantError:  models.ErrInvalidParent,
+		},
+		{
+			name:       "Reply to non-existent message",
+			roomID:     firstTestMessage.RoomID,
+			parentID:   42,
+			wantParent: 0,
+			wantError:  models.ErrInvalidParent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := MessageModel{DB: db}
+
+			msg, err := m.Insert(tt.roomID, tt.parentID, "Reply", testUser.Username, "", nil, time.Now())
+			assert.Equal(t, tt.wantError, err)
+			assert.Equal(t, tt.wantParent, msg.ParentID)
+		})
+	}
+}
+
+func TestMessageModel_Thread(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := MessageModel{DB: db}
+
+	var replies []models.Message
+	for _, text := range []string{"First reply", "Second reply"} {
+		reply, err := m.Insert(firstTestMessage.RoomID, firstTestMessage.ID, text, "Ann", "", nil, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		replies = append(replies, reply)
+	}
+
+	// replies to replies are added to the same thread
+	reply, err := m.Insert(firstTestMessage.RoomID, replies[0].ID, "Third reply", testUser.Username, "", nil, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, firstTestMessage.ID, reply.ParentID)
+
+	thread, err := m.Thread(firstTestMessage.ID, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, thread, 3) {
+		assert.Equal(t, firstTestMessage.ID, thread[0].ID)
+		assert.Equal(t, 3, thread[0].Replies)
+		assert.Equal(t, replies[1].ID, thread[1].ID)
+		assert.Equal(t, reply.ID, thread[2].ID)
+	}
+
+	// replies are not shown in the main timeline
+	messages, err := m.Before(firstTestMessage.RoomID, 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2)
+
+	// deleted replies are not counted
+	_, err = m.Delete(replies[0].ID, "Ann")
+	assert.NoError(t, err)
+	parent, err := m.Get(firstTestMessage.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, parent.Replies)
+
+	_, err = m.Thread(reply.ID, 2)
+	assert.Equal(t, models.ErrNoRecord, err)
+
+	_, err = m.Thread(42, 2)
+	assert.Equal(t, models.ErrNoRecord, err)
+}
+
+func TestMessageModel_React(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := MessageModel{DB: db}
+
+	for _, username := range []string{testUser.Username, "Ann", "Ann"} {
+		_, err := m.React(firstTestMessage.ID, username, "👍")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg, err := m.React(firstTestMessage.ID, "Ann", "🎉")
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Reaction{
+		{Emoji: "🎉", Count: 1, Usernames: []string{"Ann"}},
+		{Emoji: "👍", Count: 2, Usernames: []string{"Ann", testUser.Username}},
+	}, msg.Reactions)
+
+	msg, err = m.Unreact(firstTestMessage.ID, "Ann", "👍")
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Reaction{
+		{Emoji: "🎉", Count: 1, Usernames: []string{"Ann"}},
+		{Emoji: "👍", Count: 1, Usernames: []string{testUser.Username}},
+	}, msg.Reactions)
+
+	// reactions are loaded with the history
+	messages, err := m.Before(firstTestMessage.RoomID, secondTestMessage.ID, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, msg.Reactions, messages[0].Reactions)
+	}
+
+	_, err = m.React(firstTestMessage.ID, "random username", "👍")
+	assert.Equal(t, models.ErrInvalidUsername, err)
+
+	_, err = m.React(42, "Ann", "👍")
+	assert.Equal(t, models.ErrNoRecord, err)
+
+	_, err = m.Delete(secondTestMessage.ID, testUser.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.React(secondTestMessage.ID, "Ann", "👍")
+	assert.Equal(t, models.ErrNoRecord, err)
+}
+
+func TestMessageModel_Search(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := MessageModel{DB: db}
+
+	_, err := m.Insert(firstTestMessage.RoomID, 0, "Worlds & stars are colliding", "Ann", "", nil, firstTestMessage.Created.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Insert(3, 0, "A whole new world", "Ann", "", nil, firstTestMessage.Created.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		username     string
+		query        models.SearchQuery
+		wantIDs      []int64
+		wantHeadline string
+	}{
+		{
+			name:         "All words",
+			username:     testUser.Username,
+			query:        models.SearchQuery{Text: "hello world"},
+			wantIDs:      []int64{firstTestMessage.ID},
+			wantHeadline: "<mark>Hello</mark> <mark>World</mark>",
+		},
+		{
+			name:     "Stemmed words",
+			username: testUser.Username,
+			query:    models.SearchQuery{Text: "world"},
+			wantIDs:  []int64{firstTestMessage.ID, 4, 5},
+		},
+		{
+			name:     "By author",
+			username: testUser.Username,
+			query:    models.SearchQuery{Text: "world", Author: "Ann"},
+			wantIDs:  []int64{4, 5},
+		},
+		{
+			name:         "By room",
+			username:     testUser.Username,
+			query:        models.SearchQuery{Text: "world", RoomID: 3},
+			wantIDs:      []int64{5},
+			wantHeadline: "A whole new <mark>world</mark>",
+		},
+		{
+			name:         "Since",
+			username:     testUser.Username,
+			query:        models.SearchQuery{Text: "world", Since: firstTestMessage.Created.Add(2 * time.Hour)},
+			wantIDs:      []int64{5},
+			wantHeadline: "A whole new <mark>world</mark>",
+		},
+		{
+			name:         "Until",
+			username:     testUser.Username,
+			query:        models.SearchQuery{Text: "world", Until: firstTestMessage.Created.Add(time.Minute)},
+			wantIDs:      []int64{firstTestMessage.ID},
+			wantHeadline: "Hello <mark>World</mark>",
+		},
+		{
+			name:     "By date",
+			username: testUser.Username,
+			query: models.SearchQuery{
+				Text:  "world",
+				Since: firstTestMessage.Created.Add(time.Minute),
+				Until: firstTestMessage.Created.Add(2 * time.Hour),
+			},
+			wantIDs:      []int64{4},
+			wantHeadline: "<mark>Worlds</mark> &amp; stars are colliding",
+		},
+		{
+			name:     "Room of other users",
+			username: testUser.Username,
+			query:    models.SearchQuery{Text: "another room"},
+			wantIDs:  []int64{},
+		},
+		{
+			name:     "Not a member",
+			username: "Zed",
+			query:    models.SearchQuery{Text: "world"},
+			wantIDs:  []int64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := m.Search(tt.username, tt.query, 10)
+			assert.NoError(t, err)
+
+			ids := []int64{}
+			for _, r := range results {
+				ids = append(ids, r.Message.ID)
+			}
+			assert.ElementsMatch(t, tt.wantIDs, ids)
+			if len(results) == 1 {
+				assert.Equal(t, tt.wantHeadline, results[0].Headline)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package postgresql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lazy-void/chatapp/models"
+)
+
+// PreviewModel implements methods for working with link_previews table,
+// which caches metadata of the pages linked from messages.
+// Previews are linked to messages by MessageModel.AddPreviews.
+type PreviewModel struct {
+	DB *sql.DB
+}
+
+// Get returns the preview of the page at the URL.
+func (m *PreviewModel) Get(url string) (models.Preview, error) {
+	stmt := `SELECT url, title, description, image, fetched FROM link_previews WHERE url = $1;`
+
+	p := models.Preview{}
+	err := m.DB.QueryRow(stmt, url).Scan(&p.URL, &p.Title, &p.Description, &p.Image, &p.Fetched)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Preview{}, models.ErrNoRecord
+	}
+
+	return p, err
+}
+
+// Save inserts the preview or replaces the one with the same URL.
+func (m *PreviewModel) Save(p models.Preview) error {
+	stmt := `INSERT INTO link_previews(url, title, description, image, fetched)
+	VALUES($1, $2, $3, $4, $5)
+	ON CONFLICT (url) DO UPDATE
+	SET title = excluded.title, description = excluded.description, image = excluded.image, fetched = excluded.fetched;`
+
+	_, err := m.DB.Exec(stmt, p.URL, p.Title, p.Description, p.Image, p.Fetched)
+	return err
+}
@@ -0,0 +1,84 @@
+package postgresql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviewModel_Save(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := PreviewModel{DB: db}
+
+	_, err := m.Get("https://example.com")
+	assert.Equal(t, models.ErrNoRecord, err)
+
+	p := models.Preview{
+		URL:         "https://example.com",
+		Title:       "Example",
+		Description: "Example domain",
+		Fetched:     time.Now().UTC().Truncate(time.Millisecond),
+	}
+	for _, title := range []string{"Example", "Example Domain"} {
+		p.Title = title
+		err = m.Save(p)
+		assert.NoError(t, err)
+
+		got, err := m.Get(p.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, p.Title, got.Title)
+		assert.Equal(t, p.Description, got.Description)
+		assert.True(t, p.Fetched.Equal(got.Fetched))
+	}
+}
+
+func TestMessageModel_AddPreviews(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	previews := PreviewModel{DB: db}
+	m := MessageModel{DB: db}
+
+	urls := []string{"https://example.org", "https://example.com"}
+	for _, url := range urls {
+		err := previews.Save(models.Preview{URL: url, Title: url, Fetched: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg, err := m.AddPreviews(firstTestMessage.ID, urls)
+	assert.NoError(t, err)
+	if assert.Len(t, msg.Previews, 2) {
+		assert.Equal(t, "https://example.org", msg.Previews[0].URL)
+		assert.Equal(t, "https://example.com", msg.Previews[1].Title)
+	}
+
+	// previews are loaded with the messages
+	got, err := m.Get(firstTestMessage.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, msg.Previews, got.Previews)
+
+	_, err = m.AddPreviews(firstTestMessage.ID, []string{"https://not.saved"})
+	assert.Equal(t, models.ErrNoRecord, err)
+
+	_, err = m.Delete(firstTestMessage.ID, firstTestMessage.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.AddPreviews(firstTestMessage.ID, urls)
+	assert.Equal(t, models.ErrNoRecord, err)
+}
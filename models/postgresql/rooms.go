@@ -0,0 +1,352 @@
+package postgresql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+)
+
+// RoomModel implements methods for working with rooms, room_members,
+// direct_rooms and room_reads tables.
+type RoomModel struct {
+	DB *sql.DB
+}
+
+// Insert creates new room and makes its creator a member of it.
+// In case of success id of created room is returned.
+func (m *RoomModel) Insert(name, creator string) (int64, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO rooms(name, creator) VALUES($1, $2) RETURNING id;`
+
+	var id int64
+	err = tx.QueryRow(stmt, name, creator).Scan(&id)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == pgerrcode.UniqueViolation && pgErr.ConstraintName == "rooms_name_key":
+			return 0, models.ErrDuplicateRoomName
+		case pgErr.Code == pgerrcode.ForeignKeyViolation && pgErr.ConstraintName == "rooms_creator_fkey":
+			return 0, models.ErrInvalidUsername
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`INSERT INTO room_members(room_id, username) VALUES($1, $2);`, id, creator)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// Get gets room with the provided id from the database.
+func (m *RoomModel) Get(id int64) (models.Room, error) {
+	stmt := `SELECT id, COALESCE(name, ''), direct, COALESCE(creator, ''), created, topic FROM rooms WHERE id = $1;`
+
+	room := models.Room{}
+	err := m.DB.QueryRow(stmt, id).Scan(&room.ID, &room.Name, &room.Direct, &room.Creator, &room.Created, &room.Topic)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Room{}, models.ErrNoRecord
+	} else if err != nil {
+		return models.Room{}, err
+	}
+
+	return room, nil
+}
+
+// All returns all rooms except direct ones sorted by name.
+func (m *RoomModel) All() ([]models.Room, error) {
+	stmt := `SELECT id, name, direct, COALESCE(creator, ''), created, topic
+	FROM rooms
+	WHERE NOT direct
+	ORDER BY name;`
+
+	return m.query(stmt)
+}
+
+// Joined returns all rooms the user is a member of. Rooms are sorted by name,
+// direct rooms go after the others and are named after the other user.
+func (m *RoomModel) Joined(username string) ([]models.Room, error) {
+	stmt := `SELECT r.id,
+		COALESCE(r.name, CASE WHEN d.user1 = $1 THEN d.user2 ELSE d.user1 END) AS room_name,
+		r.direct, COALESCE(r.creator, ''), r.created, r.topic
+	FROM rooms r
+	JOIN room_members rm ON rm.room_id = r.id
+	LEFT JOIN direct_rooms d ON d.room_id = r.id
+	WHERE rm.username = $1
+	ORDER BY r.direct, room_name;`
+
+	return m.query(stmt, username)
+}
+
+// Direct returns id of the direct room of two users creating
+// it if necessary. Both users are made members of the room.
+func (m *RoomModel) Direct(username, peer string) (int64, error) {
+	// direct_rooms stores each pair of users only once, in sorted order
+	user1, user2 := username, peer
+	if user1 > user2 {
+		user1, user2 = user2, user1
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`SELECT room_id FROM direct_rooms WHERE user1 = $1 AND user2 = $2;`, user1, user2).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		id, err = m.insertDirect(tx, user1, user2)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO room_members(room_id, username) VALUES($1, $2), ($1, $3)
+	ON CONFLICT DO NOTHING;`
+
+	_, err = tx.Exec(stmt, id, user1, user2)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func (m *RoomModel) insertDirect(tx *sql.Tx, user1, user2 string) (int64, error) {
+	var id int64
+	err := tx.QueryRow(`INSERT INTO rooms(direct) VALUES(true) RETURNING id;`).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`INSERT INTO direct_rooms(room_id, user1, user2) VALUES($1, $2, $3);`, id, user1, user2)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+		return 0, models.ErrInvalidUsername
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// SetTopic changes the topic of the room.
+// ErrNoRecord is returned if the room doesn't exist.
+func (m *RoomModel) SetTopic(id int64, topic string) error {
+	res, err := m.DB.Exec(`UPDATE rooms SET topic = $2 WHERE id = $1;`, id, topic)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// Join makes the user a member of the room. Joining the room
+// the user is already a member of is not an error. Direct rooms
+// can't be joined, ErrInvalidRoom is returned for them.
+func (m *RoomModel) Join(roomID int64, username string) error {
+	var direct bool
+	err := m.DB.QueryRow(`SELECT direct FROM rooms WHERE id = $1;`, roomID).Scan(&direct)
+	if errors.Is(err, sql.ErrNoRows) || direct {
+		return models.ErrInvalidRoom
+	} else if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO room_members(room_id, username) VALUES($1, $2)
+	ON CONFLICT DO NOTHING;`
+
+	_, err = m.DB.Exec(stmt, roomID, username)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+		switch pgErr.ConstraintName {
+		case "room_members_room_id_fkey":
+			return models.ErrInvalidRoom
+		case "room_members_username_fkey":
+			return models.ErrInvalidUsername
+		}
+	}
+
+	return err
+}
+
+// Leave removes the user from the members of the room.
+// ErrNoRecord is returned if the user is not a member of the room.
+func (m *RoomModel) Leave(roomID int64, username string) error {
+	stmt := `DELETE FROM room_members WHERE room_id = $1 AND username = $2;`
+
+	res, err := m.DB.Exec(stmt, roomID, username)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// IsMember reports whether the user is a member of the room.
+func (m *RoomModel) IsMember(roomID int64, username string) (bool, error) {
+	stmt := `SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND username = $2);`
+
+	var ok bool
+	err := m.DB.QueryRow(stmt, roomID, username).Scan(&ok)
+	if err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+// Members returns the users from the list who are members of the room,
+// sorted by username.
+func (m *RoomModel) Members(roomID int64, usernames []string) ([]string, error) {
+	stmt := `SELECT username FROM room_members
+	WHERE room_id = $1 AND username = ANY($2)
+	ORDER BY username;`
+
+	rows, err := m.DB.Query(stmt, roomID, usernames)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []string
+	for rows.Next() {
+		var username string
+		err := rows.Scan(&username)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, username)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// MarkRead remembers that the user has read messages of the room up to the
+// message with provided id. Marking older messages as read doesn't move
+// the mark back. ErrNoRecord is returned if the user is not a member
+// of the room or the message is not in the room.
+func (m *RoomModel) MarkRead(roomID int64, username string, messageID int64) error {
+	stmt := `INSERT INTO room_reads(room_id, username, message_id)
+	SELECT $1, $2, $3
+	WHERE EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND username = $2)
+		AND EXISTS(SELECT 1 FROM messages WHERE id = $3 AND room_id = $1)
+	ON CONFLICT (room_id, username) DO UPDATE
+		SET message_id = GREATEST(room_reads.message_id, EXCLUDED.message_id), updated = now();`
+
+	res, err := m.DB.Exec(stmt, roomID, username, messageID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// Unread returns numbers of unread messages in the rooms the user
+// is a member of by room ids. Messages of the user and deleted ones are not
+// counted, nor are replies, since rooms are read on the main timeline.
+// Until the user reads anything in the room, only messages sent after
+// joining it are unread. Rooms without unread messages are omitted.
+func (m *RoomModel) Unread(username string) (map[int64]int, error) {
+	stmt := `SELECT rm.room_id, COUNT(*)
+	FROM room_members rm
+	LEFT JOIN room_reads rr ON rr.room_id = rm.room_id AND rr.username = rm.username
+	JOIN messages m ON m.room_id = rm.room_id
+	WHERE rm.username = $1
+		AND m.username <> rm.username
+		AND NOT m.deleted
+		AND m.parent_id IS NULL
+		AND (m.id > rr.message_id OR rr.message_id IS NULL AND m.created > rm.joined)
+	GROUP BY rm.room_id;`
+
+	rows, err := m.DB.Query(stmt, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	unread := make(map[int64]int)
+	for rows.Next() {
+		var (
+			room  int64
+			count int
+		)
+		err := rows.Scan(&room, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		unread[room] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return unread, nil
+}
+
+func (m *RoomModel) query(stmt string, args ...interface{}) ([]models.Room, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []models.Room
+	for rows.Next() {
+		room := models.Room{}
+		err := rows.Scan(&room.ID, &room.Name, &room.Direct, &room.Creator, &room.Created, &room.Topic)
+		if err != nil {
+			return nil, err
+		}
+
+		rooms = append(rooms, room)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rooms, nil
+}
@@ -0,0 +1,546 @@
+package postgresql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	generalTestRoom = models.Room{
+		ID:      1,
+		Name:    "general",
+		Creator: "",
+		Created: time.Date(2021, time.June, 12, 15, 0, 0, 0, time.UTC).Local(),
+	}
+	randomTestRoom = models.Room{
+		ID:      2,
+		Name:    "random",
+		Creator: testUser.Username,
+		Created: time.Date(2021, time.June, 12, 16, 0, 0, 0, time.UTC).Local(),
+	}
+	directTestRoom = models.Room{
+		ID:      3,
+		Name:    "",
+		Direct:  true,
+		Creator: "",
+		Created: time.Date(2021, time.June, 12, 17, 0, 0, 0, time.UTC).Local(),
+	}
+)
+
+// directTestRoomOf returns directTestRoom as it is listed for the user.
+func directTestRoomOf(username string) models.Room {
+	room := directTestRoom
+	room.Name = username
+	return room
+}
+
+func TestRoomModel_Insert(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name          string
+		room, creator string
+		wantError     error
+	}{
+		{
+			name:      "Correct room",
+			room:      "golang",
+			creator:   testUser.Username,
+			wantError: nil,
+		},
+		{
+			name:      "Duplicate name",
+			room:      generalTestRoom.Name,
+			creator:   testUser.Username,
+			wantError: models.ErrDuplicateRoomName,
+		},
+		{
+			name:      "Non-existent creator",
+			room:      "golang",
+			creator:   "random username",
+			wantError: models.ErrInvalidUsername,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := RoomModel{DB: db}
+
+			id, err := m.Insert(tt.room, tt.creator)
+			assert.Equal(t, tt.wantError, err)
+			if err != nil {
+				return
+			}
+
+			// creator must become a member of the room
+			ok, err := m.IsMember(id, tt.creator)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestRoomModel_Get(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		id        int64
+		wantRoom  models.Room
+		wantError error
+	}{
+		{
+			name:      "Existing room without creator",
+			id:        generalTestRoom.ID,
+			wantRoom:  generalTestRoom,
+			wantError: nil,
+		},
+		{
+			name:      "Existing room with creator",
+			id:        randomTestRoom.ID,
+			wantRoom:  randomTestRoom,
+			wantError: nil,
+		},
+		{
+			name:      "Direct room",
+			id:        directTestRoom.ID,
+			wantRoom:  directTestRoom,
+			wantError: nil,
+		},
+		{
+			name:      "Non-existent room",
+			id:        42,
+			wantRoom:  models.Room{},
+			wantError: models.ErrNoRecord,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := RoomModel{DB: db}
+
+			room, err := m.Get(tt.id)
+
+			assert.Equal(t, tt.wantError, err)
+			assert.Equal(t, tt.wantRoom, room)
+		})
+	}
+}
+
+func TestRoomModel_All(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := RoomModel{DB: db}
+
+	rooms, err := m.All()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Room{generalTestRoom, randomTestRoom}, rooms)
+}
+
+func TestRoomModel_Joined(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		username  string
+		wantRooms []models.Room
+	}{
+		{
+			name:      "Member of a room and a direct room",
+			username:  testUser.Username,
+			wantRooms: []models.Room{generalTestRoom, directTestRoomOf("Ann")},
+		},
+		{
+			name:      "Member of a direct room only",
+			username:  "Ann",
+			wantRooms: []models.Room{directTestRoomOf(testUser.Username)},
+		},
+		{
+			name:      "Non-existent user",
+			username:  "random username",
+			wantRooms: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := RoomModel{DB: db}
+
+			rooms, err := m.Joined(tt.username)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantRooms, rooms)
+		})
+	}
+}
+
+func TestRoomModel_SetTopic(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		roomID    int64
+		topic     string
+		wantError error
+	}{
+		{
+			name:      "Set topic",
+			roomID:    generalTestRoom.ID,
+			topic:     "Anything goes",
+			wantError: nil,
+		},
+		{
+			name:      "Clear topic",
+			roomID:    generalTestRoom.ID,
+			topic:     "",
+			wantError: nil,
+		},
+		{
+			name:      "Non-existent room",
+			roomID:    42,
+			topic:     "Anything goes",
+			wantError: models.ErrNoRecord,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := RoomModel{DB: db}
+
+			err := m.SetTopic(tt.roomID, tt.topic)
+			assert.Equal(t, tt.wantError, err)
+			if err != nil {
+				return
+			}
+
+			room, err := m.Get(tt.roomID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.topic, room.Topic)
+		})
+	}
+}
+
+func TestRoomModel_Join(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		roomID    int64
+		username  string
+		wantError error
+	}{
+		{
+			name:      "Join new room",
+			roomID:    randomTestRoom.ID,
+			username:  testUser.Username,
+			wantError: nil,
+		},
+		{
+			name:      "Join already joined room",
+			roomID:    generalTestRoom.ID,
+			username:  testUser.Username,
+			wantError: nil,
+		},
+		{
+			name:      "Non-existent room",
+			roomID:    42,
+			username:  testUser.Username,
+			wantError: models.ErrInvalidRoom,
+		},
+		{
+			name:      "Non-existent user",
+			roomID:    randomTestRoom.ID,
+			username:  "random username",
+			wantError: models.ErrInvalidUsername,
+		},
+		{
+			name:      "Direct room of other users",
+			roomID:    directTestRoom.ID,
+			username:  "Zed",
+			wantError: models.ErrInvalidRoom,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := RoomModel{DB: db}
+
+			err := m.Join(tt.roomID, tt.username)
+			assert.Equal(t, tt.wantError, err)
+			if err != nil {
+				return
+			}
+
+			ok, err := m.IsMember(tt.roomID, tt.username)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestRoomModel_Leave(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		roomID    int64
+		username  string
+		wantError error
+	}{
+		{
+			name:      "Leave joined room",
+			roomID:    generalTestRoom.ID,
+			username:  testUser.Username,
+			wantError: nil,
+		},
+		{
+			name:      "Leave not joined room",
+			roomID:    randomTestRoom.ID,
+			username:  testUser.Username,
+			wantError: models.ErrNoRecord,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := RoomModel{DB: db}
+
+			err := m.Leave(tt.roomID, tt.username)
+			assert.Equal(t, tt.wantError, err)
+
+			ok, err := m.IsMember(tt.roomID, tt.username)
+			assert.NoError(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestRoomModel_Direct(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name           string
+		username, peer string
+		wantID         int64
+		wantError      error
+	}{
+		{
+			name:      "Existing direct room",
+			username:  testUser.Username,
+			peer:      "Ann",
+			wantID:    directTestRoom.ID,
+			wantError: nil,
+		},
+		{
+			name:      "Existing direct room (reversed order)",
+			username:  "Ann",
+			peer:      testUser.Username,
+			wantID:    directTestRoom.ID,
+			wantError: nil,
+		},
+		{
+			name:      "New direct room",
+			username:  testUser.Username,
+			peer:      "Zed",
+			wantID:    4,
+			wantError: nil,
+		},
+		{
+			name:      "Non-existent peer",
+			username:  testUser.Username,
+			peer:      "random username",
+			wantID:    0,
+			wantError: models.ErrInvalidUsername,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := RoomModel{DB: db}
+
+			id, err := m.Direct(tt.username, tt.peer)
+			assert.Equal(t, tt.wantError, err)
+			assert.Equal(t, tt.wantID, id)
+			if err != nil {
+				return
+			}
+
+			for _, username := range []string{tt.username, tt.peer} {
+				ok, err := m.IsMember(id, username)
+				assert.NoError(t, err)
+				assert.True(t, ok)
+			}
+		})
+	}
+}
+
+func TestRoomModel_Members(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := RoomModel{DB: db}
+
+	// room 3 is the direct room of Ann and George
+	members, err := m.Members(3, []string{testUser.Username, "Zed", "Ann", "Nobody"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Ann", testUser.Username}, members)
+
+	members, err = m.Members(generalTestRoom.ID, []string{"Ann"})
+	assert.NoError(t, err)
+	assert.Empty(t, members)
+}
+
+func TestRoomModel_MarkRead(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		roomID    int64
+		username  string
+		messageID int64
+		wantError error
+	}{
+		{
+			name:      "Message in the room",
+			roomID:    generalTestRoom.ID,
+			username:  testUser.Username,
+			messageID: 2,
+			wantError: nil,
+		},
+		{
+			name:      "Message in another room",
+			roomID:    generalTestRoom.ID,
+			username:  testUser.Username,
+			messageID: 3,
+			wantError: models.ErrNoRecord,
+		},
+		{
+			name:      "Not a member",
+			roomID:    generalTestRoom.ID,
+			username:  "Ann",
+			messageID: 2,
+			wantError: models.ErrNoRecord,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := RoomModel{DB: db}
+
+			err := m.MarkRead(tt.roomID, tt.username, tt.messageID)
+			assert.Equal(t, tt.wantError, err)
+		})
+	}
+}
+
+func TestRoomModel_Unread(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := RoomModel{DB: db}
+	messages := MessageModel{DB: db}
+
+	// messages sent before joining the room are not unread
+	unread, err := m.Unread(testUser.Username)
+	assert.NoError(t, err)
+	assert.Empty(t, unread)
+
+	var ids []int64
+	for _, username := range []string{"Ann", "Ann", testUser.Username} {
+		msg, err := messages.Insert(generalTestRoom.ID, 0, "Message text", username, "", nil, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, msg.ID)
+	}
+
+	// own messages are not counted
+	unread, err = m.Unread(testUser.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]int{generalTestRoom.ID: 2}, unread)
+
+	err = m.MarkRead(generalTestRoom.ID, testUser.Username, ids[0])
+	assert.NoError(t, err)
+
+	unread, err = m.Unread(testUser.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]int{generalTestRoom.ID: 1}, unread)
+
+	// the mark doesn't move back
+	err = m.MarkRead(generalTestRoom.ID, testUser.Username, ids[2])
+	assert.NoError(t, err)
+	err = m.MarkRead(generalTestRoom.ID, testUser.Username, ids[0])
+	assert.NoError(t, err)
+
+	unread, err = m.Unread(testUser.Username)
+	assert.NoError(t, err)
+	assert.Empty(t, unread)
+
+	// replies are not counted
+	_, err = messages.Insert(generalTestRoom.ID, ids[0], "Reply text", "Ann", "", nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unread, err = m.Unread(testUser.Username)
+	assert.NoError(t, err)
+	assert.Empty(t, unread)
+}
@@ -1,32 +1,214 @@
 CREATE TABLE users
 (
     username        varchar(50) PRIMARY KEY,
-    email           varchar(255) UNIQUE       NOT NULL,
-    hashed_password char(60)                  NOT NULL,
-    created         timestamptz default now() NOT NULL
+    email           varchar(255) UNIQUE,
+    hashed_password char(60),
+    created         timestamptz default now() NOT NULL,
+    nickname        varchar(50),
+    role            varchar(10) default 'member' NOT NULL CHECK (role IN ('member', 'moderator', 'admin')),
+    bot             boolean     default false NOT NULL,
+    creator         varchar(50) REFERENCES users (username),
+    token_hash      char(64) UNIQUE,
+    CHECK (bot OR email IS NOT NULL AND hashed_password IS NOT NULL)
 );
 
-CREATE TABLE messages
+CREATE TABLE sanctions
 (
     id       serial PRIMARY KEY,
-    username varchar(50) REFERENCES users (username) NOT NULL,
-    text     text                                    NOT NULL,
-    created  timestamptz default now()               NOT NULL
+    username varchar(50) REFERENCES users (username) ON DELETE CASCADE NOT NULL,
+    kind     varchar(10)                                            NOT NULL CHECK (kind IN ('mute', 'ban')),
+    reason   varchar(500) default ''                                NOT NULL,
+    expires  timestamptz,
+    creator  varchar(50) REFERENCES users (username)                NOT NULL,
+    created  timestamptz  default now()                             NOT NULL
+);
+
+CREATE INDEX idx_test_sanctions_username_kind ON sanctions (username, kind);
+
+CREATE TABLE rooms
+(
+    id      serial PRIMARY KEY,
+    name    varchar(50) UNIQUE,
+    direct  boolean     default false         NOT NULL,
+    creator varchar(50) REFERENCES users (username),
+    created timestamptz default now()         NOT NULL,
+    topic   varchar(250) default ''           NOT NULL,
+    CHECK (direct OR name IS NOT NULL)
+);
+
+CREATE TABLE room_members
+(
+    room_id  integer REFERENCES rooms (id) ON DELETE CASCADE NOT NULL,
+    username varchar(50) REFERENCES users (username)         NOT NULL,
+    joined   timestamptz default now()                       NOT NULL,
+    PRIMARY KEY (room_id, username)
+);
+
+CREATE TABLE direct_rooms
+(
+    room_id integer PRIMARY KEY REFERENCES rooms (id) ON DELETE CASCADE,
+    user1   varchar(50) REFERENCES users (username) NOT NULL,
+    user2   varchar(50) REFERENCES users (username) NOT NULL,
+    UNIQUE (user1, user2),
+    CHECK (user1 <= user2)
 );
 
-CREATE INDEX idx_test_messages_created ON messages (created);
+CREATE TABLE messages
+(
+    id         serial PRIMARY KEY,
+    room_id    integer REFERENCES rooms (id)           NOT NULL,
+    parent_id  integer REFERENCES messages (id),
+    username   varchar(50) REFERENCES users (username) NOT NULL,
+    text       text                                    NOT NULL,
+    created    timestamptz default now()               NOT NULL,
+    client_key varchar(64),
+    edited     timestamptz,
+    deleted    boolean     default false               NOT NULL,
+    stored     timestamptz default now()               NOT NULL,
+    changed    timestamptz,
+    search     tsvector GENERATED ALWAYS AS (to_tsvector('english', text)) STORED,
+    UNIQUE (username, client_key)
+);
+
+CREATE INDEX idx_test_messages_room_id ON messages (room_id, id);
+CREATE INDEX idx_test_messages_parent_id ON messages (parent_id, id);
+CREATE INDEX idx_test_messages_search ON messages USING GIN (search);
+
+CREATE TABLE attachments
+(
+    id               serial PRIMARY KEY,
+    message_id       integer REFERENCES messages (id) ON DELETE CASCADE,
+    username         varchar(50) REFERENCES users (username) NOT NULL,
+    blob_key         varchar(128) UNIQUE                     NOT NULL,
+    filename         varchar(255)                            NOT NULL,
+    content_type     varchar(100)                            NOT NULL,
+    size             bigint                                  NOT NULL,
+    created          timestamptz default now()               NOT NULL,
+    width            integer     default 0                   NOT NULL,
+    height           integer     default 0                   NOT NULL,
+    thumbnail_key    varchar(128) UNIQUE,
+    thumbnail_width  integer     default 0                   NOT NULL,
+    thumbnail_height integer     default 0                   NOT NULL
+);
+
+CREATE INDEX idx_test_attachments_message_id ON attachments (message_id);
+
+CREATE TABLE link_previews
+(
+    url         varchar(2048) PRIMARY KEY,
+    title       varchar(300)              NOT NULL,
+    description varchar(1000)             NOT NULL,
+    image       varchar(2048)             NOT NULL,
+    fetched     timestamptz default now() NOT NULL
+);
+
+CREATE TABLE message_previews
+(
+    message_id integer       REFERENCES messages (id) ON DELETE CASCADE NOT NULL,
+    url        varchar(2048) REFERENCES link_previews (url)             NOT NULL,
+    position   integer                                                NOT NULL,
+    PRIMARY KEY (message_id, url)
+);
+
+CREATE TABLE room_reads
+(
+    room_id    integer     REFERENCES rooms (id) ON DELETE CASCADE NOT NULL,
+    username   varchar(50) REFERENCES users (username)             NOT NULL,
+    message_id integer     REFERENCES messages (id)                NOT NULL,
+    updated    timestamptz default now()                           NOT NULL,
+    PRIMARY KEY (room_id, username)
+);
+
+CREATE TABLE mentions
+(
+    message_id integer     REFERENCES messages (id) ON DELETE CASCADE NOT NULL,
+    username   varchar(50) REFERENCES users (username)                NOT NULL,
+    PRIMARY KEY (message_id, username)
+);
+
+CREATE INDEX idx_test_mentions_username ON mentions (username, message_id);
+
+CREATE TABLE message_reactions
+(
+    message_id integer     REFERENCES messages (id) ON DELETE CASCADE NOT NULL,
+    username   varchar(50) REFERENCES users (username)                NOT NULL,
+    emoji      varchar(32)                                            NOT NULL,
+    created    timestamptz default now()                              NOT NULL,
+    PRIMARY KEY (message_id, username, emoji)
+);
+
+CREATE TABLE webhooks
+(
+    id      serial PRIMARY KEY,
+    url     varchar(2048)                                 NOT NULL,
+    secret  varchar(64)                                   NOT NULL,
+    room_id integer REFERENCES rooms (id) ON DELETE CASCADE,
+    keyword varchar(100) default ''                       NOT NULL,
+    creator varchar(50) REFERENCES users (username)       NOT NULL,
+    created timestamptz default now()                     NOT NULL
+);
+
+CREATE TABLE incoming_webhooks
+(
+    id         serial PRIMARY KEY,
+    token_hash char(64) UNIQUE                                  NOT NULL,
+    room_id    integer REFERENCES rooms (id) ON DELETE CASCADE  NOT NULL,
+    username   varchar(50) REFERENCES users (username)          NOT NULL,
+    creator    varchar(50) REFERENCES users (username)          NOT NULL,
+    created    timestamptz default now()                        NOT NULL
+);
+
+CREATE TABLE webhook_deliveries
+(
+    id           bigserial PRIMARY KEY,
+    webhook_id   integer REFERENCES webhooks (id) ON DELETE CASCADE NOT NULL,
+    message_id   integer REFERENCES messages (id) ON DELETE CASCADE NOT NULL,
+    attempts     integer     default 0                             NOT NULL,
+    last_error   text        default ''                            NOT NULL,
+    next_attempt timestamptz default now()                         NOT NULL,
+    failed       boolean     default false                         NOT NULL,
+    created      timestamptz default now()                         NOT NULL
+);
+
+CREATE INDEX idx_test_webhook_deliveries_next_attempt ON webhook_deliveries (next_attempt) WHERE NOT failed;
 
 INSERT INTO users(username, email, hashed_password, created)
 VALUES ('George',
         'geor@example.com',
         '$2a$12$6vzjkqafxBK8nFtvT83.ZuYKMCVAOa..lQDjySLQ6UIUo3m.2j.um',
-        '2021-06-12 15:00:00+0000');
+        '2021-06-12 15:00:00+0000'),
+       ('Ann',
+        'ann@example.com',
+        '$2a$12$6vzjkqafxBK8nFtvT83.ZuYKMCVAOa..lQDjySLQ6UIUo3m.2j.um',
+        '2021-06-12 15:30:00+0000'),
+       ('Zed',
+        'zed@example.com',
+        '$2a$12$6vzjkqafxBK8nFtvT83.ZuYKMCVAOa..lQDjySLQ6UIUo3m.2j.um',
+        '2021-06-12 15:30:00+0000');
 
-INSERT INTO messages(username, text, created)
-VALUES ('George',
+INSERT INTO rooms(name, direct, creator, created)
+VALUES ('general', false, NULL, '2021-06-12 15:00:00+0000'),
+       ('random', false, 'George', '2021-06-12 16:00:00+0000'),
+       (NULL, true, NULL, '2021-06-12 17:00:00+0000');
+
+INSERT INTO direct_rooms(room_id, user1, user2)
+VALUES (3, 'Ann', 'George');
+
+INSERT INTO room_members(room_id, username)
+VALUES (1, 'George'),
+       (3, 'George'),
+       (3, 'Ann');
+
+INSERT INTO messages(room_id, username, text, created)
+VALUES (1,
+        'George',
         'Hello World',
         '2021-06-13 15:00:00+0000'),
-       ('George',
+       (1,
+        'George',
         'Very loooooooooooooooooooooooooooooooooooooooooooong message!',
-        '2021-06-13 15:00:00+0000');
-
+        '2021-06-13 15:00:00+0000'),
+       (2,
+        'George',
+        'Message in another room',
+        '2021-06-13 16:00:00+0000');
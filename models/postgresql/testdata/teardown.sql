@@ -1,2 +1,15 @@
+DROP TABLE IF EXISTS webhook_deliveries CASCADE;
+DROP TABLE IF EXISTS webhooks CASCADE;
+DROP TABLE IF EXISTS incoming_webhooks CASCADE;
+DROP TABLE IF EXISTS message_previews CASCADE;
+DROP TABLE IF EXISTS link_previews CASCADE;
+DROP TABLE IF EXISTS attachments CASCADE;
+DROP TABLE IF EXISTS message_reactions CASCADE;
+DROP TABLE IF EXISTS mentions CASCADE;
+DROP TABLE IF EXISTS room_reads CASCADE;
 DROP TABLE IF EXISTS messages CASCADE;
+DROP TABLE IF EXISTS direct_rooms CASCADE;
+DROP TABLE IF EXISTS room_members CASCADE;
+DROP TABLE IF EXISTS rooms CASCADE;
+DROP TABLE IF EXISTS sanctions CASCADE;
 DROP TABLE IF EXISTS users CASCADE;
@@ -2,7 +2,11 @@
 package postgresql
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 
 	"github.com/lazy-void/chatapp/models"
@@ -44,13 +48,15 @@ func (m *UserModel) Insert(username, email, password string) error {
 }
 
 // Authenticate checks for correctness provided pair of email and password.
-// In case of success username will be returned.
+// In case of success username will be returned. ErrBanned is returned
+// if the password is correct, but the user is banned.
 func (m *UserModel) Authenticate(email, password string) (string, error) {
-	stmt := `SELECT username, hashed_password FROM users WHERE email = $1;`
+	stmt := `SELECT username, hashed_password, ` + bannedColumn + ` FROM users WHERE email = $1;`
 
 	var username string
 	var hashedPassword string
-	err := m.DB.QueryRow(stmt, email).Scan(&username, &hashedPassword)
+	var banned bool
+	err := m.DB.QueryRow(stmt, email).Scan(&username, &hashedPassword, &banned)
 	if errors.Is(err, sql.ErrNoRows) {
 		return "", models.ErrNoRecord
 	} else if err != nil {
@@ -64,15 +70,37 @@ func (m *UserModel) Authenticate(email, password string) (string, error) {
 		return "", err
 	}
 
+	if banned {
+		return "", models.ErrBanned
+	}
+
 	return username, nil
 }
 
+// bannedColumn tells whether the user selected from the users table has an active ban.
+const bannedColumn = `EXISTS(SELECT 1 FROM sanctions s WHERE s.username = users.username AND s.kind = 'ban'
+	AND (s.expires IS NULL OR s.expires > now()))`
+
+// userColumns are scanned by scanUser.
+const userColumns = `username, COALESCE(email, ''), COALESCE(hashed_password, ''), created, COALESCE(nickname, ''),
+	role, bot, COALESCE(creator, ''), token_hash IS NOT NULL, ` + bannedColumn
+
+func scanUser(row interface{ Scan(...interface{}) error }) (models.User, error) {
+	user := models.User{}
+	err := row.Scan(&user.Username, &user.Email, &user.HashedPassword, &user.Created, &user.Nickname,
+		&user.Role, &user.Bot, &user.Creator, &user.HasToken, &user.Banned)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
 // Get gets user with provided the username from the database.
 func (m *UserModel) Get(username string) (models.User, error) {
-	stmt := `SELECT username, email, hashed_password, created FROM users WHERE username = $1;`
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE username = $1;`
 
-	user := models.User{}
-	err := m.DB.QueryRow(stmt, username).Scan(&user.Username, &user.Email, &user.HashedPassword, &user.Created)
+	user, err := scanUser(m.DB.QueryRow(stmt, username))
 	if errors.Is(err, sql.ErrNoRows) {
 		return models.User{}, models.ErrNoRecord
 	} else if err != nil {
@@ -81,3 +109,254 @@ func (m *UserModel) Get(username string) (models.User, error) {
 
 	return user, nil
 }
+
+// SetNickname changes the nickname of the user, an empty
+// nickname removes it. ErrNoRecord is returned if the user
+// doesn't exist.
+func (m *UserModel) SetNickname(username, nickname string) error {
+	stmt := `UPDATE users SET nickname = NULLIF($2, '') WHERE username = $1;`
+
+	res, err := m.DB.Exec(stmt, username, nickname)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// InsertBot adds the bot created by the admin and returns its API token.
+// Only the hash of the token is stored, so it can't be shown again.
+func (m *UserModel) InsertBot(username, creator string) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO users (username, bot, creator, token_hash) VALUES ($1, true, $2, $3);`
+
+	_, err = m.DB.Exec(stmt, username, creator, hash)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == pgerrcode.UniqueViolation && pgErr.ConstraintName == "users_pkey":
+			return "", models.ErrDuplicateUsername
+		case pgErr.Code == pgerrcode.ForeignKeyViolation && pgErr.ConstraintName == "users_creator_fkey":
+			return "", models.ErrInvalidUsername
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Bots returns all bots sorted by username.
+func (m *UserModel) Bots() ([]models.User, error) {
+	return m.query(`SELECT ` + userColumns + ` FROM users WHERE bot ORDER BY username;`)
+}
+
+// People returns all users except bots sorted by username.
+func (m *UserModel) People() ([]models.User, error) {
+	return m.query(`SELECT ` + userColumns + ` FROM users WHERE NOT bot ORDER BY username;`)
+}
+
+// query runs the statement that selects userColumns.
+func (m *UserModel) query(stmt string, args ...interface{}) ([]models.User, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// SetRole changes the role of the user. Bots are always members.
+// ErrInvalidRole is returned if the role doesn't exist, and
+// ErrNoRecord if there is no user with the username.
+func (m *UserModel) SetRole(username string, role models.Role) error {
+	if !role.Valid() {
+		return models.ErrInvalidRole
+	}
+
+	res, err := m.DB.Exec(`UPDATE users SET role = $2 WHERE username = $1 AND NOT bot;`, username, string(role))
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// NewToken replaces the API token of the bot and returns the new one.
+// ErrNoRecord is returned if there is no bot with the username.
+func (m *UserModel) NewToken(username string) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = m.setTokenHash(username, sql.NullString{String: hash, Valid: true})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RevokeToken removes the API token of the bot, so that the bot
+// can't connect until it gets a new one. ErrNoRecord is returned
+// if there is no bot with the username.
+func (m *UserModel) RevokeToken(username string) error {
+	return m.setTokenHash(username, sql.NullString{})
+}
+
+func (m *UserModel) setTokenHash(username string, hash sql.NullString) error {
+	res, err := m.DB.Exec(`UPDATE users SET token_hash = $2 WHERE username = $1 AND bot;`, username, hash)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// AuthenticateToken returns the username of the bot with the API token.
+// ErrInvalidToken is returned if no bot has it.
+func (m *UserModel) AuthenticateToken(token string) (string, error) {
+	stmt := `SELECT username FROM users WHERE token_hash = $1 AND bot;`
+
+	var username string
+	err := m.DB.QueryRow(stmt, hashToken(token)).Scan(&username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", models.ErrInvalidToken
+	} else if err != nil {
+		return "", err
+	}
+
+	return username, nil
+}
+
+// Sanction imposes the sanction on the user and returns its id.
+// ErrInvalidUsername is returned if the user or the creator doesn't exist.
+func (m *UserModel) Sanction(s models.Sanction) (int64, error) {
+	stmt := `INSERT INTO sanctions (username, kind, reason, expires, creator)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id;`
+
+	var expires sql.NullTime
+	if !s.Expires.IsZero() {
+		expires = sql.NullTime{Time: s.Expires, Valid: true}
+	}
+
+	var id int64
+	err := m.DB.QueryRow(stmt, s.Username, string(s.Kind), s.Reason, expires, s.Creator).Scan(&id)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+		return 0, models.ErrInvalidUsername
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// ActiveSanction returns the active sanction of the kind that lasts
+// the longest. ErrNoRecord is returned if the user has none.
+func (m *UserModel) ActiveSanction(username string, kind models.SanctionKind) (models.Sanction, error) {
+	stmt := `SELECT id, username, kind, reason, expires, creator, created
+	FROM sanctions
+	WHERE username = $1 AND kind = $2 AND (expires IS NULL OR expires > now())
+	ORDER BY expires DESC NULLS FIRST
+	LIMIT 1;`
+
+	var s models.Sanction
+	var expires sql.NullTime
+	err := m.DB.QueryRow(stmt, username, string(kind)).Scan(&s.ID, &s.Username, &s.Kind, &s.Reason, &expires,
+		&s.Creator, &s.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Sanction{}, models.ErrNoRecord
+	} else if err != nil {
+		return models.Sanction{}, err
+	}
+	s.Expires = expires.Time
+
+	return s, nil
+}
+
+// LiftSanction ends the active sanctions of the kind, they are kept
+// as expired. ErrNoRecord is returned if the user has none.
+func (m *UserModel) LiftSanction(username string, kind models.SanctionKind) error {
+	stmt := `UPDATE sanctions SET expires = now()
+	WHERE username = $1 AND kind = $2 AND (expires IS NULL OR expires > now());`
+
+	res, err := m.DB.Exec(stmt, username, string(kind))
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// newToken returns a random API token and its hash. Tokens are random
+// enough for a fast hash, unlike passwords they can't be guessed.
+func newToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -15,6 +15,7 @@ var testUser = models.User{
 	Username:       "George",
 	Email:          "geor@example.com",
 	HashedPassword: "$2a$12$6vzjkqafxBK8nFtvT83.ZuYKMCVAOa..lQDjySLQ6UIUo3m.2j.um",
+	Role:           models.RoleMember,
 	Created:        time.Date(2021, time.June, 12, 15, 0, 0, 0, time.UTC).Local(),
 }
 
@@ -200,3 +201,290 @@ func TestUserModel_Get(t *testing.T) {
 		})
 	}
 }
+
+func TestUserModel_SetNickname(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		username  string
+		nickname  string
+		wantError error
+	}{
+		{
+			name:      "Set nickname",
+			username:  testUser.Username,
+			nickname:  "Georgie",
+			wantError: nil,
+		},
+		{
+			name:      "Remove nickname",
+			username:  testUser.Username,
+			nickname:  "",
+			wantError: nil,
+		},
+		{
+			name:      "Non-existing username",
+			username:  "Emma",
+			nickname:  "Em",
+			wantError: models.ErrNoRecord,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := UserModel{DB: db}
+
+			err := m.SetNickname(tt.username, tt.nickname)
+			assert.Equal(t, tt.wantError, err)
+			if err != nil {
+				return
+			}
+
+			user, err := m.Get(tt.username)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.nickname, user.Nickname)
+
+			// messages show the current nickname of the author
+			msg, err := (&MessageModel{DB: db}).Get(1)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.nickname, msg.Nickname)
+		})
+	}
+}
+
+func TestUserModel_SetRole(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		username  string
+		role      models.Role
+		wantError error
+	}{
+		{
+			name:      "Make admin",
+			username:  testUser.Username,
+			role:      models.RoleAdmin,
+			wantError: nil,
+		},
+		{
+			name:      "Make moderator",
+			username:  testUser.Username,
+			role:      models.RoleModerator,
+			wantError: nil,
+		},
+		{
+			name:      "Unknown role",
+			username:  testUser.Username,
+			role:      "owner",
+			wantError: models.ErrInvalidRole,
+		},
+		{
+			name:      "Non-existing username",
+			username:  "Emma",
+			role:      models.RoleAdmin,
+			wantError: models.ErrNoRecord,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := UserModel{DB: db}
+
+			err := m.SetRole(tt.username, tt.role)
+			assert.Equal(t, tt.wantError, err)
+			if err != nil {
+				return
+			}
+
+			people, err := m.People()
+			assert.NoError(t, err)
+			for _, user := range people {
+				if user.Username == tt.username {
+					assert.Equal(t, tt.role, user.Role)
+				} else {
+					assert.Equal(t, models.RoleMember, user.Role)
+				}
+			}
+		})
+	}
+}
+
+func TestUserModel_InsertBot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		username  string
+		creator   string
+		wantError error
+	}{
+		{
+			name:      "Correct bot",
+			username:  "robot",
+			creator:   testUser.Username,
+			wantError: nil,
+		},
+		{
+			name:      "Duplicate username",
+			username:  testUser.Username,
+			creator:   testUser.Username,
+			wantError: models.ErrDuplicateUsername,
+		},
+		{
+			name:      "Non-existing creator",
+			username:  "robot",
+			creator:   "Emma",
+			wantError: models.ErrInvalidUsername,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := UserModel{DB: db}
+
+			token, err := m.InsertBot(tt.username, tt.creator)
+
+			assert.Equal(t, tt.wantError, err)
+			if err != nil {
+				return
+			}
+
+			username, err := m.AuthenticateToken(token)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.username, username)
+
+			bots, err := m.Bots()
+			assert.NoError(t, err)
+			if assert.Len(t, bots, 1) {
+				assert.Equal(t, tt.username, bots[0].Username)
+				assert.Equal(t, tt.creator, bots[0].Creator)
+				assert.True(t, bots[0].Bot)
+				assert.True(t, bots[0].HasToken)
+			}
+
+			// bots can't get other roles
+			assert.Equal(t, models.ErrNoRecord, m.SetRole(tt.username, models.RoleAdmin))
+		})
+	}
+}
+
+func TestUserModel_Tokens(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := UserModel{DB: db}
+
+	old, err := m.InsertBot("robot", testUser.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := m.NewToken("robot")
+	assert.NoError(t, err)
+	assert.NotEqual(t, old, token)
+
+	// the old token no longer works
+	_, err = m.AuthenticateToken(old)
+	assert.Equal(t, models.ErrInvalidToken, err)
+	username, err := m.AuthenticateToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "robot", username)
+
+	err = m.RevokeToken("robot")
+	assert.NoError(t, err)
+	_, err = m.AuthenticateToken(token)
+	assert.Equal(t, models.ErrInvalidToken, err)
+
+	// only bots have tokens
+	_, err = m.NewToken(testUser.Username)
+	assert.Equal(t, models.ErrNoRecord, err)
+	err = m.RevokeToken("Emma")
+	assert.Equal(t, models.ErrNoRecord, err)
+}
+
+func TestUserModel_Sanctions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := UserModel{DB: db}
+
+	_, err := m.ActiveSanction(testUser.Username, models.SanctionMute)
+	assert.Equal(t, models.ErrNoRecord, err)
+
+	// expired sanctions are not active
+	_, err = m.Sanction(models.Sanction{Username: testUser.Username, Kind: models.SanctionMute,
+		Expires: time.Now().Add(-time.Minute), Creator: "Ann"})
+	assert.NoError(t, err)
+	_, err = m.ActiveSanction(testUser.Username, models.SanctionMute)
+	assert.Equal(t, models.ErrNoRecord, err)
+	assert.Equal(t, models.ErrNoRecord, m.LiftSanction(testUser.Username, models.SanctionMute))
+
+	expires := time.Now().Add(time.Hour)
+	id, err := m.Sanction(models.Sanction{Username: testUser.Username, Kind: models.SanctionMute,
+		Reason: "spam", Expires: expires, Creator: "Ann"})
+	assert.NoError(t, err)
+
+	mute, err := m.ActiveSanction(testUser.Username, models.SanctionMute)
+	assert.NoError(t, err)
+	assert.Equal(t, id, mute.ID)
+	assert.Equal(t, models.SanctionMute, mute.Kind)
+	assert.Equal(t, "spam", mute.Reason)
+	assert.WithinDuration(t, expires, mute.Expires, time.Millisecond)
+	assert.Equal(t, "Ann", mute.Creator)
+
+	// muted users can still log in
+	user, err := m.Get(testUser.Username)
+	assert.NoError(t, err)
+	assert.False(t, user.Banned)
+
+	_, err = m.Sanction(models.Sanction{Username: testUser.Username, Kind: models.SanctionBan, Creator: "Ann"})
+	assert.NoError(t, err)
+
+	ban, err := m.ActiveSanction(testUser.Username, models.SanctionBan)
+	assert.NoError(t, err)
+	assert.True(t, ban.Expires.IsZero())
+
+	user, err = m.Get(testUser.Username)
+	assert.NoError(t, err)
+	assert.True(t, user.Banned)
+
+	_, err = m.Authenticate(testUser.Email, testUserPassword)
+	assert.Equal(t, models.ErrBanned, err)
+	_, err = m.Authenticate(testUser.Email, "incorrect password")
+	assert.Equal(t, models.ErrInvalidPassword, err)
+
+	assert.NoError(t, m.LiftSanction(testUser.Username, models.SanctionBan))
+	assert.Equal(t, models.ErrNoRecord, m.LiftSanction(testUser.Username, models.SanctionBan))
+
+	username, err := m.Authenticate(testUser.Email, testUserPassword)
+	assert.NoError(t, err)
+	assert.Equal(t, testUser.Username, username)
+
+	_, err = m.Sanction(models.Sanction{Username: "Emma", Kind: models.SanctionBan, Creator: "Ann"})
+	assert.Equal(t, models.ErrInvalidUsername, err)
+}
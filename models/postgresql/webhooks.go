@@ -0,0 +1,170 @@
+package postgresql
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+)
+
+// WebhookModel implements methods for working with webhooks table
+// and the queue of their deliveries in webhook_deliveries table.
+// Deliveries are queued by MessageModel.Insert along with the message.
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+// Insert adds the webhook and returns its id. RoomID is 0 if the webhook
+// receives messages of all rooms. ErrInvalidRoom is returned if the room
+// doesn't exist.
+func (m *WebhookModel) Insert(w models.Webhook) (int64, error) {
+	stmt := `INSERT INTO webhooks (url, secret, room_id, keyword, creator)
+	VALUES($1, $2, NULLIF($3, 0), $4, $5)
+	RETURNING id;`
+
+	var id int64
+	err := m.DB.QueryRow(stmt, w.URL, w.Secret, w.RoomID, w.Keyword, w.Creator).Scan(&id)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+		switch pgErr.ConstraintName {
+		case "webhooks_room_id_fkey":
+			return 0, models.ErrInvalidRoom
+		case "webhooks_creator_fkey":
+			return 0, models.ErrInvalidUsername
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// All returns all webhooks, oldest first.
+func (m *WebhookModel) All() ([]models.Webhook, error) {
+	stmt := `SELECT id, url, secret, COALESCE(room_id, 0), keyword, creator, created
+	FROM webhooks
+	ORDER BY id;`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []models.Webhook
+	for rows.Next() {
+		var w models.Webhook
+		err := rows.Scan(&w.ID, &w.URL, &w.Secret, &w.RoomID, &w.Keyword, &w.Creator, &w.Created)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Delete removes the webhook along with its queued deliveries.
+func (m *WebhookModel) Delete(id int64) error {
+	res, err := m.DB.Exec(`DELETE FROM webhooks WHERE id = $1;`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// Claim returns at most n deliveries that are due at the time, oldest first,
+// and postpones them until the lease expires, so that other instances
+// don't deliver them meanwhile. A delivery is attempted again after
+// the lease unless it is marked as delivered or is retried.
+func (m *WebhookModel) Claim(now, lease time.Time, n int) ([]models.Delivery, error) {
+	stmt := `WITH due AS (
+		UPDATE webhook_deliveries SET next_attempt = $2
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE NOT failed AND next_attempt <= $1
+			ORDER BY next_attempt
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, webhook_id, message_id, attempts, last_error, next_attempt, failed, created
+	)
+	SELECT due.id, due.webhook_id, w.url, w.secret, due.message_id, due.attempts, due.last_error,
+	due.next_attempt, due.failed, due.created
+	FROM due JOIN webhooks w ON w.id = due.webhook_id
+	ORDER BY due.created, due.id;`
+
+	return m.query(stmt, now, lease, n)
+}
+
+// Failed returns at most n deliveries that are no longer retried, newest first.
+func (m *WebhookModel) Failed(n int) ([]models.Delivery, error) {
+	stmt := `SELECT d.id, d.webhook_id, w.url, w.secret, d.message_id, d.attempts, d.last_error,
+	d.next_attempt, d.failed, d.created
+	FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+	WHERE d.failed
+	ORDER BY d.id DESC
+	LIMIT $1;`
+
+	return m.query(stmt, n)
+}
+
+func (m *WebhookModel) query(stmt string, args ...interface{}) ([]models.Delivery, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.Delivery
+	for rows.Next() {
+		var d models.Delivery
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.URL, &d.Secret, &d.MessageID, &d.Attempts, &d.LastError,
+			&d.NextAttempt, &d.Failed, &d.Created)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// Delivered removes the delivered payload from the queue.
+func (m *WebhookModel) Delivered(id int64) error {
+	_, err := m.DB.Exec(`DELETE FROM webhook_deliveries WHERE id = $1;`, id)
+	return err
+}
+
+// Retry records the failed attempt to deliver the payload and schedules
+// the next one. If failed is true, the payload is no longer retried.
+func (m *WebhookModel) Retry(id int64, lastError string, next time.Time, failed bool) error {
+	stmt := `UPDATE webhook_deliveries
+	SET attempts = attempts + 1, last_error = $2, next_attempt = $3, failed = $4
+	WHERE id = $1;`
+
+	_, err := m.DB.Exec(stmt, id, lastError, next, failed)
+	return err
+}
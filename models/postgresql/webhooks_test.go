@@ -0,0 +1,245 @@
+package postgresql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookModel_Insert(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		webhook   models.Webhook
+		wantError error
+	}{
+		{
+			name:      "All rooms",
+			webhook:   models.Webhook{URL: "https://example.com", Secret: "secret", Creator: testUser.Username},
+			wantError: nil,
+		},
+		{
+			name: "Room and keyword",
+			webhook: models.Webhook{URL: "https://example.com", Secret: "secret", RoomID: models.DefaultRoomID,
+				Keyword: "deploy", Creator: testUser.Username},
+			wantError: nil,
+		},
+		{
+			name:      "Non-existent room",
+			webhook:   models.Webhook{URL: "https://example.com", Secret: "secret", RoomID: 42, Creator: testUser.Username},
+			wantError: models.ErrInvalidRoom,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := WebhookModel{DB: db}
+
+			id, err := m.Insert(tt.webhook)
+
+			assert.Equal(t, tt.wantError, err)
+			if err != nil {
+				return
+			}
+
+			all, err := m.All()
+			assert.NoError(t, err)
+			if assert.Len(t, all, 1) {
+				assert.Equal(t, id, all[0].ID)
+				assert.Equal(t, tt.webhook.RoomID, all[0].RoomID)
+				assert.Equal(t, tt.webhook.Keyword, all[0].Keyword)
+			}
+
+			assert.NoError(t, m.Delete(id))
+			assert.Equal(t, models.ErrNoRecord, m.Delete(id))
+		})
+	}
+}
+
+func TestWebhookModel_Queue(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := WebhookModel{DB: db}
+	messages := MessageModel{DB: db}
+
+	all, err := m.Insert(models.Webhook{URL: "https://example.com/all", Secret: "s1", Creator: testUser.Username})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Insert(models.Webhook{URL: "https://example.com/deploys", Secret: "s2", RoomID: models.DefaultRoomID,
+		Keyword: "Deploy", Creator: testUser.Username})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := messages.Insert(models.DefaultRoomID, 0, "deployed v1.2", testUser.Username, "", nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := messages.Insert(2, 0, "deployed v1.2", testUser.Username, "", nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	lease := now.Add(time.Minute)
+	claimed, err := m.Claim(now, lease, 10)
+	assert.NoError(t, err)
+	// the first message matches both webhooks, the second only the one of all rooms
+	if assert.Len(t, claimed, 3) {
+		for _, d := range claimed {
+			assert.True(t, lease.Sub(d.NextAttempt) < time.Millisecond)
+		}
+	}
+
+	// claimed deliveries are not claimed again until the lease expires
+	again, err := m.Claim(now, lease, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, again)
+
+	for _, d := range claimed {
+		switch {
+		case d.MessageID == second.ID:
+			assert.NoError(t, m.Delivered(d.ID))
+		case d.WebhookID == all:
+			assert.NoError(t, m.Retry(d.ID, "503 Service Unavailable", now, false))
+		default:
+			assert.NoError(t, m.Retry(d.ID, "502 Bad Gateway", now, true))
+		}
+	}
+
+	failed, err := m.Failed(10)
+	assert.NoError(t, err)
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, first.ID, failed[0].MessageID)
+		assert.Equal(t, 1, failed[0].Attempts)
+		assert.Equal(t, "502 Bad Gateway", failed[0].LastError)
+		assert.True(t, failed[0].Failed)
+	}
+
+	retried, err := m.Claim(now, lease, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, retried, 1) {
+		assert.Equal(t, first.ID, retried[0].MessageID)
+		assert.Equal(t, "https://example.com/all", retried[0].URL)
+		assert.Equal(t, "s1", retried[0].Secret)
+		assert.Equal(t, 1, retried[0].Attempts)
+	}
+}
+
+func TestWebhookModel_QueueOnce(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := WebhookModel{DB: db}
+	messages := MessageModel{DB: db}
+
+	_, err := m.Insert(models.Webhook{URL: "https://example.com/all", Secret: "s1", Creator: testUser.Username})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// retried messages and the ones that weren't stored are not queued
+	for i := 0; i < 2; i++ {
+		_, err := messages.Insert(models.DefaultRoomID, 0, "hi", testUser.Username, "k1", nil, time.Now())
+		if i == 1 {
+			assert.Equal(t, models.ErrDuplicateKey, err)
+		}
+	}
+	_, err = messages.Insert(models.DefaultRoomID, 0, "hi", testUser.Username, "", []int64{100}, time.Now())
+	assert.Equal(t, models.ErrInvalidAttachment, err)
+
+	now := time.Now()
+	claimed, err := m.Claim(now, now.Add(time.Minute), 10)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 1)
+}
+
+func TestWebhookModel_QueueDirect(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := WebhookModel{DB: db}
+	messages := MessageModel{DB: db}
+
+	_, err := m.Insert(models.Webhook{URL: "https://example.com/all", Secret: "s1", Creator: testUser.Username})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// room 3 is the direct room of Ann and George
+	_, err = messages.Insert(3, 0, "hi", testUser.Username, "", nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	claimed, err := m.Claim(now, now.Add(time.Minute), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+func TestWebhookModel_QueueFilters(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgresql: skipping integration test")
+	}
+
+	tests := []struct {
+		name   string
+		roomID int64
+		text   string
+		want   int
+	}{
+		{"Matching", models.DefaultRoomID, "Deployed v1.2", 1},
+		{"No keyword", models.DefaultRoomID, "hello", 0},
+		{"Other room", 2, "deploying", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := WebhookModel{DB: db}
+			messages := MessageModel{DB: db}
+
+			_, err := m.Insert(models.Webhook{URL: "https://example.com/deploys", Secret: "s1",
+				RoomID: models.DefaultRoomID, Keyword: "deploy", Creator: testUser.Username})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = messages.Insert(tt.roomID, 0, tt.text, testUser.Username, "", nil, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			now := time.Now()
+			claimed, err := m.Claim(now, now.Add(time.Minute), 10)
+			assert.NoError(t, err)
+			assert.Len(t, claimed, tt.want)
+		})
+	}
+}
@@ -1,27 +1,183 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"html/template"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/lazy-void/chatapp/blob"
+	"github.com/lazy-void/chatapp/chat"
 	"github.com/lazy-void/chatapp/forms"
+	"github.com/lazy-void/chatapp/markdown"
+	"github.com/lazy-void/chatapp/media"
 	"github.com/lazy-void/chatapp/models"
+	"github.com/lazy-void/chatapp/webhook"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/justinas/nosurf"
 )
 
+// Number of messages shown on the mentions page.
+const mentionsPageSize = 100
+
+// Number of the latest failed deliveries shown on the webhooks page.
+const failedDeliveriesPageSize = 50
+
+// Maximum size of the request to send a message via the HTTP API.
+const messageRequestMaxSize = 8192
+
+// Maximum size of the request to the incoming webhook. Slack messages
+// may carry fields that are ignored, so it is larger than the above.
+const incomingWebhookMaxSize = 64 << 10
+
+const (
+	// Maximum size of the uploaded file.
+	attachmentMaxSize = 10 << 20
+
+	// Thumbnails are stored under the key of the image with the suffix.
+	thumbnailKeySuffix = "_thumbnail"
+
+	// Size of the multipart form without the file that is enough
+	// for the headers of the file and the csrf token.
+	multipartOverhead = 64 << 10
+
+	// Maximum length of the uploaded file name in characters.
+	filenameMaxLength = 255
+)
+
+// Content types of the files that can be uploaded. The type is
+// detected from the contents, not taken from the request.
+var attachmentContentTypes = map[string]bool{
+	"image/png":                 true,
+	"image/jpeg":                true,
+	"image/gif":                 true,
+	"image/webp":                true,
+	"application/pdf":           true,
+	"application/zip":           true,
+	"text/plain; charset=utf-8": true,
+}
+
 type templateData struct {
 	Username     string
 	CSRFToken    string
 	Form         forms.Form
 	SuccessFlash string
 	ErrorFlash   string
+	Mentions     []mention
+	Role         models.Role
+	Bots         []models.User
+	People       []models.User
+	Roles        []models.Role
+
+	// Token of the bot shown once after it is generated.
+	Token *botToken
+
+	Webhooks []webhookItem
+	Failed   []models.Delivery
+	Rooms    []models.Room
+
+	IncomingForm     forms.Form
+	IncomingWebhooks []incomingWebhookItem
+
+	// URL of the incoming webhook shown once after it is created.
+	HookURL string
+}
+
+// webhookItem is a webhook as it is shown on the webhooks page.
+type webhookItem struct {
+	models.Webhook
+
+	// Name of the room the messages are taken from, empty for all rooms.
+	Room string
+}
+
+// incomingWebhookItem is an incoming webhook as it is shown
+// on the webhooks page.
+type incomingWebhookItem struct {
+	models.IncomingWebhook
+
+	// Name of the room the messages are posted to.
+	Room string
+}
+
+// botToken is a newly generated API token of the bot.
+type botToken struct {
+	Username string
+	Token    string
+}
+
+// incomingWebhookRequest is the body of the request to the incoming
+// webhook. Other fields of Slack messages are ignored.
+type incomingWebhookRequest struct {
+	Text string `json:"text"`
+}
+
+// messageRequest is the body of the request to send a message
+// via the HTTP API. Its fields mean the same as in chat.Request.
+type messageRequest struct {
+	Message     string  `json:"message"`
+	Parent      int64   `json:"parent"`
+	Key         string  `json:"key"`
+	Attachments []int64 `json:"attachments"`
+}
+
+// mention is a message that mentions the user
+// as it is shown on the mentions page.
+type mention struct {
+	Room     string
+	Username string
+	HTML     template.HTML
+	Created  time.Time
 }
 
 func (app *Application) home(w http.ResponseWriter, r *http.Request) {
 	app.render(w, r, "chat.page.gohtml", templateData{})
 }
 
+func (app *Application) mentions(w http.ResponseWriter, r *http.Request) {
+	username := app.authenticatedUser(r).Username
+	messages, err := app.Messages.Mentioned(username, mentionsPageSize)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	joined, err := app.Rooms.Joined(username)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	names := make(map[int64]string, len(joined))
+	for _, room := range joined {
+		if room.Direct {
+			names[room.ID] = "@" + room.Name
+		} else {
+			names[room.ID] = "#" + room.Name
+		}
+	}
+
+	mentions := make([]mention, len(messages))
+	for i, m := range messages {
+		mentions[i] = mention{
+			Room:     names[m.RoomID],
+			Username: m.Username,
+			// rendered Markdown is safe to be shown as is
+			HTML:    template.HTML(markdown.Render(m.Text)),
+			Created: m.Created,
+		}
+	}
+
+	app.render(w, r, "mentions.page.gohtml", templateData{Mentions: mentions})
+}
+
 func (app *Application) signupUserForm(w http.ResponseWriter, r *http.Request) {
 	app.render(w, r, "signup.page.gohtml", templateData{})
 }
@@ -71,6 +227,12 @@ func (app *Application) signupUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = app.Rooms.Join(models.DefaultRoomID, form.Get("username"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
 	s := app.getUserSession(r)
 	s.AddFlash("Your signup was successful. Please log in.", "success_flash")
 	err = s.Save(r, w)
@@ -119,6 +281,13 @@ func (app *Application) loginUser(w http.ResponseWriter, r *http.Request) {
 	case errors.Is(err, models.ErrInvalidPassword):
 		s.AddFlash("Incorrect password.", "error_flash")
 
+		app.render(w, r, "login.page.gohtml", templateData{
+			Form: form,
+		})
+		return
+	case errors.Is(err, models.ErrBanned):
+		s.AddFlash("Your account is banned.", "error_flash")
+
 		app.render(w, r, "login.page.gohtml", templateData{
 			Form: form,
 		})
@@ -142,3 +311,743 @@ func (app *Application) logoutUser(w http.ResponseWriter, r *http.Request) {
 	app.deleteAuthCookie(w, r)
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
+
+func (app *Application) listRooms(hub *chat.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rooms, err := hub.ListRooms(app.authenticatedUser(r).Username)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		app.writeJSON(w, http.StatusOK, rooms)
+	}
+}
+
+func (app *Application) unreadCounts(w http.ResponseWriter, r *http.Request) {
+	unread, err := app.Rooms.Unread(app.authenticatedUser(r).Username)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, unread)
+}
+
+func (app *Application) createRoom(hub *chat.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+
+		form := forms.New(r.PostForm)
+
+		// names are trimmed the same way as in the websocket requests
+		form.Set("name", strings.TrimSpace(form.Get("name")))
+		form.Required("name")
+		form.MaxLength("name", 50)
+
+		if !form.Valid() {
+			app.writeJSON(w, http.StatusUnprocessableEntity, form.Errors)
+			return
+		}
+
+		username := app.authenticatedUser(r).Username
+		id, err := app.Rooms.Insert(form.Get("name"), username)
+		if errors.Is(err, models.ErrDuplicateRoomName) {
+			app.clientError(w, http.StatusConflict)
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		hub.Subscribe(username, id)
+		app.writeJSON(w, http.StatusCreated, chat.Room{ID: id, Name: form.Get("name"), Joined: true})
+	}
+}
+
+func (app *Application) joinRoom(hub *chat.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		username := app.authenticatedUser(r).Username
+		err = app.Rooms.Join(id, username)
+		if errors.Is(err, models.ErrInvalidRoom) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		hub.Subscribe(username, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (app *Application) leaveRoom(hub *chat.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		username := app.authenticatedUser(r).Username
+		err = app.Rooms.Leave(id, username)
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		hub.Unsubscribe(username, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (app *Application) roomHistory(hub *chat.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		var before int64
+		if v := r.URL.Query().Get("before"); v != "" {
+			before, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				app.clientError(w, http.StatusBadRequest)
+				return
+			}
+		}
+
+		// rooms of other users are reported as missing
+		ok, err := app.Rooms.IsMember(id, app.authenticatedUser(r).Username)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		if !ok {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		messages, err := hub.LoadMore(id, before)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		app.writeJSON(w, http.StatusOK, messages)
+	}
+}
+
+// sendMessage broadcasts the message to the room as if it was sent via
+// websocket. It lets bots post without keeping a connection open.
+func (app *Application) sendMessage(hub *chat.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		var req messageRequest
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+
+		msg, err := hub.Post(app.authenticatedUser(r).Username, chat.Request{
+			Room:        id,
+			Message:     req.Message,
+			Parent:      req.Parent,
+			Key:         req.Key,
+			Attachments: req.Attachments,
+		})
+		var chatErr *chat.Error
+		if errors.As(err, &chatErr) {
+			app.writeJSON(w, chatErr.StatusCode(), chatErr)
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		app.writeJSON(w, http.StatusCreated, msg)
+	}
+}
+
+func (app *Application) searchMessages(hub *chat.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		form := forms.New(r.URL.Query())
+
+		form.Required("q")
+		form.MaxLength("q", 256)
+
+		if !form.Valid() {
+			app.writeJSON(w, http.StatusUnprocessableEntity, form.Errors)
+			return
+		}
+
+		q := models.SearchQuery{Text: form.Get("q"), Author: form.Get("author")}
+
+		var err error
+		if v := form.Get("room"); v != "" {
+			q.RoomID, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				app.clientError(w, http.StatusBadRequest)
+				return
+			}
+		}
+		for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+			if v := form.Get(name); v != "" {
+				*t, err = time.Parse(time.RFC3339, v)
+				if err != nil {
+					app.clientError(w, http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		found, err := hub.Search(app.authenticatedUser(r).Username, q)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		app.writeJSON(w, http.StatusOK, found)
+	}
+}
+
+func (app *Application) uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(multipartOverhead)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		// the body is cut at the limit
+		app.clientError(w, http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > attachmentMaxSize {
+		app.clientError(w, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	head = head[:n]
+
+	contentType := http.DetectContentType(head)
+	if !attachmentContentTypes[contentType] {
+		app.clientError(w, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	key, err := blob.NewKey()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	a := models.Attachment{
+		Username:    app.authenticatedUser(r).Username,
+		Key:         key,
+		Filename:    attachmentFilename(header.Filename),
+		ContentType: contentType,
+		Size:        header.Size,
+	}
+	contents := io.MultiReader(bytes.NewReader(head), file)
+
+	// pictures are stored without metadata and get thumbnails
+	// to be shown in the chat instead of the full images
+	if media.Supported(contentType) {
+		data, err := io.ReadAll(contents)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+
+		data, err = media.StripMetadata(contentType, data)
+		if err != nil {
+			app.clientError(w, http.StatusUnsupportedMediaType)
+			return
+		}
+		a.Size = int64(len(data))
+		contents = bytes.NewReader(data)
+
+		thumb, err := media.MakeThumbnail(contentType, data)
+		switch {
+		case errors.Is(err, media.ErrMalformed) || errors.Is(err, media.ErrTooLarge):
+			// the image is sent as a regular file
+		case err != nil:
+			app.serverError(w, err)
+			return
+		default:
+			a.Width, a.Height = thumb.SourceWidth, thumb.SourceHeight
+			a.ThumbnailKey = key + thumbnailKeySuffix
+			a.ThumbnailWidth, a.ThumbnailHeight = thumb.Width, thumb.Height
+
+			err = app.Blobs.Put(a.ThumbnailKey, bytes.NewReader(thumb.Data))
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+		}
+	}
+
+	err = app.Blobs.Put(key, contents)
+	if err != nil {
+		app.deleteBlobs(a)
+		app.serverError(w, err)
+		return
+	}
+
+	stored, err := app.Attachments.Insert(a)
+	if err != nil {
+		app.deleteBlobs(a)
+		app.serverError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, chat.NewAttachment(stored))
+}
+
+func (app *Application) downloadAttachment(w http.ResponseWriter, r *http.Request) {
+	a, ok := app.attachment(w, r)
+	if !ok {
+		return
+	}
+
+	disposition := "attachment"
+	if strings.HasPrefix(a.ContentType, "image/") {
+		disposition = "inline"
+	}
+
+	app.sendBlob(w, a.Key, a.ContentType, mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename}))
+}
+
+func (app *Application) downloadThumbnail(w http.ResponseWriter, r *http.Request) {
+	a, ok := app.attachment(w, r)
+	if !ok {
+		return
+	}
+	if a.ThumbnailKey == "" {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	app.sendBlob(w, a.ThumbnailKey, media.ThumbnailContentType(a.ContentType), "inline")
+}
+
+func (app *Application) listUsers(w http.ResponseWriter, r *http.Request) {
+	people, err := app.Users.People()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "users.page.gohtml", templateData{People: people, Roles: models.Roles()})
+}
+
+// setRole changes the role of the user. Admins can't change their own
+// role, so that there is always an admin left.
+func (app *Application) setRole(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	username := chi.URLParam(r, "username")
+	if username == app.authenticatedUser(r).Username {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	err = app.Users.SetRole(username, models.Role(r.PostForm.Get("role")))
+	switch {
+	case errors.Is(err, models.ErrInvalidRole):
+		app.clientError(w, http.StatusBadRequest)
+		return
+	case errors.Is(err, models.ErrNoRecord):
+		app.clientError(w, http.StatusNotFound)
+		return
+	case err != nil:
+		app.serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/users", http.StatusSeeOther)
+}
+
+func (app *Application) listBots(w http.ResponseWriter, r *http.Request) {
+	app.renderBots(w, r, templateData{Form: forms.New(nil)})
+}
+
+// createBot adds the bot to the default room and shows its token.
+// Only the hash of the token is stored, so it is shown once.
+func (app *Application) createBot(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	form.Required("username")
+	form.ContainsOnlyAllowedChars("username")
+	form.MaxLength("username", 50)
+
+	if !form.Valid() {
+		app.renderBots(w, r, templateData{Form: form})
+		return
+	}
+
+	username := form.Get("username")
+	token, err := app.Users.InsertBot(username, app.authenticatedUser(r).Username)
+	if errors.Is(err, models.ErrDuplicateUsername) {
+		form.Errors["username"] = "Username is already taken."
+		app.renderBots(w, r, templateData{Form: form})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.Rooms.Join(models.DefaultRoomID, username)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.renderBots(w, r, templateData{
+		Form:  forms.New(nil),
+		Token: &botToken{Username: username, Token: token},
+	})
+}
+
+// newBotToken replaces the token of the bot and shows the new one.
+func (app *Application) newBotToken(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	token, err := app.Users.NewToken(username)
+	if errors.Is(err, models.ErrNoRecord) {
+		app.clientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.renderBots(w, r, templateData{
+		Form:  forms.New(nil),
+		Token: &botToken{Username: username, Token: token},
+	})
+}
+
+// revokeBotToken removes the token of the bot, so that the bot
+// can't authenticate until it gets a new one.
+func (app *Application) revokeBotToken(w http.ResponseWriter, r *http.Request) {
+	err := app.Users.RevokeToken(chi.URLParam(r, "username"))
+	if errors.Is(err, models.ErrNoRecord) {
+		app.clientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/bots", http.StatusSeeOther)
+}
+
+// renderBots shows the bots page with the list of all bots.
+func (app *Application) renderBots(w http.ResponseWriter, r *http.Request, td templateData) {
+	bots, err := app.Users.Bots()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	td.Bots = bots
+	app.render(w, r, "bots.page.gohtml", td)
+}
+
+func (app *Application) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	app.renderWebhooks(w, r, templateData{Form: forms.New(nil), IncomingForm: forms.New(nil)})
+}
+
+// createWebhook registers the webhook with a new secret. Messages of all
+// rooms are delivered to it unless the room is chosen.
+func (app *Application) createWebhook(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	form.Required("url")
+	form.MaxLength("url", 2048)
+	form.MaxLength("keyword", 100)
+	if form.Errors["url"] == "" && !validWebhookURL(form.Get("url")) {
+		form.Errors["url"] = "URL must be an absolute http or https URL."
+	}
+
+	var room int64
+	if v := form.Get("room"); v != "" {
+		room, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			form.Errors["room"] = "Room doesn't exist."
+		}
+	}
+
+	if !form.Valid() {
+		app.renderWebhooks(w, r, templateData{Form: form, IncomingForm: forms.New(nil)})
+		return
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	_, err = app.Webhooks.Insert(models.Webhook{
+		URL:     form.Get("url"),
+		Secret:  secret,
+		RoomID:  room,
+		Keyword: strings.TrimSpace(form.Get("keyword")),
+		Creator: app.authenticatedUser(r).Username,
+	})
+	if errors.Is(err, models.ErrInvalidRoom) {
+		form.Errors["room"] = "Room doesn't exist."
+		app.renderWebhooks(w, r, templateData{Form: form, IncomingForm: forms.New(nil)})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/webhooks", http.StatusSeeOther)
+}
+
+// deleteWebhook removes the webhook along with its queued deliveries.
+func (app *Application) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = app.Webhooks.Delete(id)
+	if errors.Is(err, models.ErrNoRecord) {
+		app.clientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/webhooks", http.StatusSeeOther)
+}
+
+// createIncomingWebhook creates the bot posting the messages of the webhook
+// to the room and shows the URL of the webhook. Only the hash of its token
+// is stored, so the URL is shown once.
+func (app *Application) createIncomingWebhook(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	form.Required("room")
+	form.Required("username")
+	form.ContainsOnlyAllowedChars("username")
+	form.MaxLength("username", 50)
+	form.MaxLength("name", 50)
+	if !validDisplayName(form.Get("name")) {
+		form.Errors["name"] = "Characters <, >, &, ' and \" are not allowed in names."
+	}
+
+	room, err := strconv.ParseInt(form.Get("room"), 10, 64)
+	if err != nil && form.Errors["room"] == "" {
+		form.Errors["room"] = "Room doesn't exist."
+	}
+
+	if !form.Valid() {
+		app.renderWebhooks(w, r, templateData{Form: forms.New(nil), IncomingForm: form})
+		return
+	}
+
+	token, err := app.IncomingWebhooks.Insert(models.IncomingWebhook{
+		RoomID:   room,
+		Username: form.Get("username"),
+		Name:     strings.TrimSpace(form.Get("name")),
+		Creator:  app.authenticatedUser(r).Username,
+	})
+	switch {
+	case errors.Is(err, models.ErrDuplicateUsername):
+		form.Errors["username"] = "Username is already taken."
+	case errors.Is(err, models.ErrInvalidRoom):
+		form.Errors["room"] = "Room doesn't exist."
+	case err != nil:
+		app.serverError(w, err)
+		return
+	default:
+		form = forms.New(nil)
+	}
+
+	td := templateData{Form: forms.New(nil), IncomingForm: form}
+	if form.Valid() {
+		td.HookURL = absoluteURL(r, "/hooks/"+token)
+	}
+	app.renderWebhooks(w, r, td)
+}
+
+// deleteIncomingWebhook removes the incoming webhook, so that its URL
+// no longer works. The bot and its messages stay.
+func (app *Application) deleteIncomingWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = app.IncomingWebhooks.Delete(id)
+	if errors.Is(err, models.ErrNoRecord) {
+		app.clientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/webhooks", http.StatusSeeOther)
+}
+
+// postIncomingWebhook sends the message posted by an external system
+// to the room of the webhook on behalf of its bot. The request has
+// the shape of a Slack incoming webhook: a JSON body with the text,
+// or a form with the JSON in the payload field.
+func (app *Application) postIncomingWebhook(hub *chat.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hook, err := app.IncomingWebhooks.Authenticate(chi.URLParam(r, "token"))
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		body := io.Reader(r.Body)
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+			err = r.ParseForm()
+			if err != nil {
+				app.clientError(w, http.StatusBadRequest)
+				return
+			}
+			body = strings.NewReader(r.PostForm.Get("payload"))
+		}
+
+		var req incomingWebhookRequest
+		err = json.NewDecoder(body).Decode(&req)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+
+		_, err = hub.Post(hook.Username, chat.Request{Room: hook.RoomID, Message: req.Text})
+		var chatErr *chat.Error
+		if errors.As(err, &chatErr) {
+			http.Error(w, chatErr.Message, chatErr.StatusCode())
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("ok"))
+	}
+}
+
+// renderWebhooks shows the webhooks page with all webhooks
+// and the latest deliveries that have failed.
+func (app *Application) renderWebhooks(w http.ResponseWriter, r *http.Request, td templateData) {
+	webhooks, err := app.Webhooks.All()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	failed, err := app.Webhooks.Failed(failedDeliveriesPageSize)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	rooms, err := app.Rooms.All()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	names := make(map[int64]string, len(rooms))
+	for _, room := range rooms {
+		names[room.ID] = room.Name
+	}
+
+	td.Webhooks = make([]webhookItem, len(webhooks))
+	for i, wh := range webhooks {
+		td.Webhooks[i] = webhookItem{Webhook: wh, Room: names[wh.RoomID]}
+	}
+
+	incoming, err := app.IncomingWebhooks.All()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	td.IncomingWebhooks = make([]incomingWebhookItem, len(incoming))
+	for i, wh := range incoming {
+		td.IncomingWebhooks[i] = incomingWebhookItem{IncomingWebhook: wh, Room: names[wh.RoomID]}
+	}
+
+	td.Failed = failed
+	td.Rooms = rooms
+	app.render(w, r, "webhooks.page.gohtml", td)
+}
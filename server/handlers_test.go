@@ -1,11 +1,19 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"html"
+	"image"
+	"image/png"
+	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 
+	"github.com/lazy-void/chatapp/chat"
 	"github.com/lazy-void/chatapp/models/mock"
 
 	"github.com/stretchr/testify/assert"
@@ -43,6 +51,20 @@ func TestApplication_Home(t *testing.T) {
 	}
 }
 
+func TestApplication_Mentions(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+
+	ts := newTestServer(t, app.NewRouter())
+	ts.authenticate(t)
+
+	code, _, body := ts.get(t, "/mentions")
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, mock.MessageMock.Text)
+	assert.Contains(t, body, "#"+mock.RoomMock.Name)
+}
+
 func TestApplication_SignupUser(t *testing.T) {
 	app := newTestApp()
 
@@ -230,6 +252,13 @@ func TestApplication_LoginUser(t *testing.T) {
 			http.StatusOK,
 			"Incorrect password.",
 		},
+		{
+			"Banned user",
+			mock.BannedMock.Email,
+			mock.ValidPassword,
+			http.StatusOK,
+			"Your account is banned.",
+		},
 	}
 
 	for _, tt := range tests {
@@ -417,3 +446,689 @@ func TestApplicationWhenAuthenticatedUserRequestsPathThatRequiresToBeNonAuthenti
 		})
 	}
 }
+
+func TestApplication_ListRooms(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+
+	ts := newTestServer(t, app.NewRouter())
+	ts.authenticate(t)
+
+	code, header, body := ts.get(t, "/rooms")
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.JSONEq(t, fmt.Sprintf(`[{"id":%d,"name":%q,"direct":false,"joined":true}]`, mock.RoomMock.ID, mock.RoomMock.Name), body)
+}
+
+func TestApplication_UnreadCounts(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+
+	ts := newTestServer(t, app.NewRouter())
+	ts.authenticate(t)
+
+	code, header, body := ts.get(t, "/rooms/unread")
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.JSONEq(t, fmt.Sprintf(`{"%d":1}`, mock.RoomMock.ID), body)
+}
+
+func TestApplication_CreateRoom(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name     string
+		room     string
+		wantCode int
+	}{
+		{"Valid room", "golang", http.StatusCreated},
+		{"Padded name", "  golang  ", http.StatusCreated},
+		{"Empty name", "", http.StatusUnprocessableEntity},
+		{"Blank name", "   ", http.StatusUnprocessableEntity},
+		{"Name is too long", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", http.StatusUnprocessableEntity},
+		{"Duplicate name", mock.DupeRoomName, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+			ts.authenticate(t)
+
+			form := url.Values{}
+			form.Add("name", tt.room)
+			form.Add("csrf_token", ts.csrfToken(t))
+
+			code, _, body := ts.post(t, "/rooms", form)
+
+			assert.Equal(t, tt.wantCode, code)
+			if code == http.StatusCreated {
+				assert.Contains(t, body, `"name":"golang"`)
+			}
+		})
+	}
+}
+
+func TestApplication_JoinRoom(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+	}{
+		{"Existing room", fmt.Sprintf("/rooms/%d/join", mock.RoomMock.ID), http.StatusNoContent},
+		{"Non-existent room", "/rooms/42/join", http.StatusNotFound},
+		{"Invalid id", "/rooms/abc/join", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+			ts.authenticate(t)
+
+			form := url.Values{}
+			form.Add("csrf_token", ts.csrfToken(t))
+
+			code, _, _ := ts.post(t, tt.path, form)
+
+			assert.Equal(t, tt.wantCode, code)
+		})
+	}
+}
+
+func TestApplication_LeaveRoom(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+	}{
+		{"Joined room", fmt.Sprintf("/rooms/%d/leave", mock.RoomMock.ID), http.StatusNoContent},
+		{"Non-existent room", "/rooms/42/leave", http.StatusNotFound},
+		{"Invalid id", "/rooms/abc/leave", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+			ts.authenticate(t)
+
+			form := url.Values{}
+			form.Add("csrf_token", ts.csrfToken(t))
+
+			code, _, _ := ts.post(t, tt.path, form)
+
+			assert.Equal(t, tt.wantCode, code)
+		})
+	}
+}
+
+func TestApplication_RoomHistory(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name         string
+		path         string
+		wantCode     int
+		wantMessages []int64
+	}{
+		{"Latest messages", fmt.Sprintf("/rooms/%d/messages", mock.RoomMock.ID), http.StatusOK, []int64{mock.MessageMock.ID}},
+		{"Messages before cursor", fmt.Sprintf("/rooms/%d/messages?before=%d", mock.RoomMock.ID, mock.MessageMock.ID), http.StatusOK, []int64{}},
+		{"Invalid cursor", fmt.Sprintf("/rooms/%d/messages?before=abc", mock.RoomMock.ID), http.StatusBadRequest, nil},
+		{"Not joined room", "/rooms/42/messages", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+			ts.authenticate(t)
+
+			code, _, body := ts.get(t, tt.path)
+
+			assert.Equal(t, tt.wantCode, code)
+			if tt.wantMessages == nil {
+				return
+			}
+
+			var messages []chat.Message
+			err := json.Unmarshal([]byte(body), &messages)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []int64{}
+			for _, m := range messages {
+				ids = append(ids, m.ID)
+			}
+			assert.Equal(t, tt.wantMessages, ids)
+		})
+	}
+}
+
+func TestApplication_SendMessage(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name     string
+		path     string
+		token    string
+		body     string
+		wantCode int
+	}{
+		{"Valid message", fmt.Sprintf("/rooms/%d/messages", mock.RoomMock.ID), mock.BotToken, `{"message": "deployed"}`, http.StatusCreated},
+		{"Invalid token", fmt.Sprintf("/rooms/%d/messages", mock.RoomMock.ID), "wrong", `{"message": "deployed"}`, http.StatusUnauthorized},
+		{"Invalid JSON", fmt.Sprintf("/rooms/%d/messages", mock.RoomMock.ID), mock.BotToken, `{"message":`, http.StatusBadRequest},
+		{"Empty message", fmt.Sprintf("/rooms/%d/messages", mock.RoomMock.ID), mock.BotToken, `{"message": " "}`, http.StatusBadRequest},
+		{"Invalid parent", fmt.Sprintf("/rooms/%d/messages", mock.RoomMock.ID), mock.BotToken, `{"message": "hi", "parent": 42}`, http.StatusNotFound},
+		{"Not joined room", "/rooms/42/messages", mock.BotToken, `{"message": "deployed"}`, http.StatusForbidden},
+		{"Invalid id", "/rooms/abc/messages", mock.BotToken, `{"message": "deployed"}`, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+
+			code, header, body := ts.postJSON(t, tt.path, tt.token, tt.body)
+
+			assert.Equal(t, tt.wantCode, code)
+			if code == http.StatusUnauthorized {
+				assert.Contains(t, header.Get("WWW-Authenticate"), "Bearer")
+			}
+			if code != http.StatusCreated {
+				return
+			}
+
+			var msg chat.Message
+			err := json.Unmarshal([]byte(body), &msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, "deployed", msg.Text)
+			assert.Equal(t, mock.BotMock.Username, msg.Username)
+			assert.True(t, msg.Bot)
+		})
+	}
+}
+
+func TestApplication_ListUsers(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name     string
+		email    string
+		wantCode int
+	}{
+		{"Member", mock.UserMock.Email, http.StatusForbidden},
+		{"Moderator", mock.ModeratorMock.Email, http.StatusForbidden},
+		{"Admin", mock.AdminMock.Email, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+			ts.authenticateAs(t, tt.email)
+
+			code, _, body := ts.get(t, "/users")
+
+			assert.Equal(t, tt.wantCode, code)
+			if code == http.StatusOK {
+				assert.Contains(t, body, mock.ModeratorMock.Username)
+				assert.Contains(t, body, fmt.Sprintf(`action="/users/%s/role"`, mock.UserMock.Username))
+				// admins can't change their own role
+				assert.NotContains(t, body, fmt.Sprintf(`action="/users/%s/role"`, mock.AdminMock.Username))
+			}
+		})
+	}
+}
+
+func TestApplication_SetRole(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name     string
+		email    string
+		username string
+		role     string
+		wantCode int
+	}{
+		{"Member", mock.UserMock.Email, mock.ModeratorMock.Username, "admin", http.StatusForbidden},
+		{"Moderator", mock.ModeratorMock.Email, mock.UserMock.Username, "moderator", http.StatusForbidden},
+		{"Make moderator", mock.AdminMock.Email, mock.UserMock.Username, "moderator", http.StatusSeeOther},
+		{"Make member", mock.AdminMock.Email, mock.ModeratorMock.Username, "member", http.StatusSeeOther},
+		{"Own role", mock.AdminMock.Email, mock.AdminMock.Username, "member", http.StatusForbidden},
+		{"Unknown role", mock.AdminMock.Email, mock.UserMock.Username, "owner", http.StatusBadRequest},
+		{"Non-existent user", mock.AdminMock.Email, "Emma", "moderator", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+			ts.authenticateAs(t, tt.email)
+
+			form := url.Values{"role": {tt.role}, "csrf_token": {ts.csrfToken(t)}}
+			code, _, _ := ts.post(t, "/users/"+tt.username+"/role", form)
+
+			assert.Equal(t, tt.wantCode, code)
+		})
+	}
+}
+
+func TestApplication_Bots(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name     string
+		email    string
+		path     string
+		form     url.Values
+		wantCode int
+		wantBody string
+	}{
+		{"Not admin", mock.UserMock.Email, "/bots", url.Values{"username": {"deployer"}}, http.StatusForbidden, ""},
+		{"Create bot", mock.AdminMock.Email, "/bots", url.Values{"username": {"deployer"}}, http.StatusOK, mock.BotToken},
+		{"Duplicate username", mock.AdminMock.Email, "/bots", url.Values{"username": {mock.BotMock.Username}}, http.StatusOK, "Username is already taken."},
+		{"Empty username", mock.AdminMock.Email, "/bots", url.Values{"username": {""}}, http.StatusOK, "This field cannot be empty."},
+		{"New token", mock.AdminMock.Email, "/bots/" + mock.BotMock.Username + "/token", url.Values{}, http.StatusOK, mock.BotToken},
+		{"New token of non-existent bot", mock.AdminMock.Email, "/bots/" + mock.UserMock.Username + "/token", url.Values{}, http.StatusNotFound, ""},
+		{"Revoke token", mock.AdminMock.Email, "/bots/" + mock.BotMock.Username + "/revoke", url.Values{}, http.StatusSeeOther, ""},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+			ts.authenticateAs(t, tt.email)
+
+			tt.form.Add("csrf_token", ts.csrfToken(t))
+
+			code, _, body := ts.post(t, tt.path, tt.form)
+
+			assert.Equal(t, tt.wantCode, code)
+			assert.Contains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestApplication_Webhooks(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name     string
+		email    string
+		path     string
+		form     url.Values
+		wantCode int
+		wantBody string
+	}{
+		{"Not admin", mock.UserMock.Email, "/webhooks", url.Values{"url": {"https://example.com"}}, http.StatusForbidden, ""},
+		{"Create webhook", mock.AdminMock.Email, "/webhooks", url.Values{"url": {"https://example.com"}}, http.StatusSeeOther, ""},
+		{"Create webhook of the room", mock.AdminMock.Email, "/webhooks", url.Values{"url": {"https://example.com"}, "room": {fmt.Sprint(mock.RoomMock.ID)}, "keyword": {"deploy"}}, http.StatusSeeOther, ""},
+		{"Empty URL", mock.AdminMock.Email, "/webhooks", url.Values{"url": {""}}, http.StatusOK, "This field cannot be empty."},
+		{"Relative URL", mock.AdminMock.Email, "/webhooks", url.Values{"url": {"/hook"}}, http.StatusOK, "URL must be an absolute http or https URL."},
+		{"Non-existent room", mock.AdminMock.Email, "/webhooks", url.Values{"url": {"https://example.com"}, "room": {"42"}}, http.StatusOK, "Room doesn&#39;t exist."},
+		{"Delete webhook", mock.AdminMock.Email, fmt.Sprintf("/webhooks/%d/delete", mock.WebhookMock.ID), url.Values{}, http.StatusSeeOther, ""},
+		{"Delete non-existent webhook", mock.AdminMock.Email, "/webhooks/42/delete", url.Values{}, http.StatusNotFound, ""},
+		{"Create incoming webhook", mock.AdminMock.Email, "/webhooks/incoming", url.Values{"room": {fmt.Sprint(mock.RoomMock.ID)}, "username": {"ci"}, "name": {"CI"}}, http.StatusOK, "/hooks/" + mock.IncomingWebhookToken},
+		{"Incoming webhook of taken username", mock.AdminMock.Email, "/webhooks/incoming", url.Values{"room": {fmt.Sprint(mock.RoomMock.ID)}, "username": {mock.BotMock.Username}}, http.StatusOK, "Username is already taken."},
+		{"Incoming webhook of non-existent room", mock.AdminMock.Email, "/webhooks/incoming", url.Values{"room": {"42"}, "username": {"ci"}}, http.StatusOK, "Room doesn&#39;t exist."},
+		{"Incoming webhook with HTML in name", mock.AdminMock.Email, "/webhooks/incoming", url.Values{"room": {fmt.Sprint(mock.RoomMock.ID)}, "username": {"ci"}, "name": {"<b>CI</b>"}}, http.StatusOK, "are not allowed in names."},
+		{"Delete incoming webhook", mock.AdminMock.Email, fmt.Sprintf("/webhooks/incoming/%d/delete", mock.IncomingWebhookMock.ID), url.Values{}, http.StatusSeeOther, ""},
+		{"Delete non-existent incoming webhook", mock.AdminMock.Email, "/webhooks/incoming/42/delete", url.Values{}, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+			ts.authenticateAs(t, tt.email)
+
+			tt.form.Add("csrf_token", ts.csrfToken(t))
+
+			code, _, body := ts.post(t, tt.path, tt.form)
+
+			assert.Equal(t, tt.wantCode, code)
+			assert.Contains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestApplication_ListWebhooks(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+
+	ts := newTestServer(t, app.NewRouter())
+	ts.authenticateAs(t, mock.AdminMock.Email)
+
+	code, _, body := ts.get(t, "/webhooks")
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, mock.WebhookMock.URL)
+	assert.Contains(t, body, mock.WebhookMock.Secret)
+	assert.Contains(t, body, mock.FailedDeliveryMock.LastError)
+	assert.Contains(t, body, mock.IncomingWebhookMock.Name)
+	assert.NotContains(t, body, mock.IncomingWebhookToken)
+}
+
+func TestApplication_PostIncomingWebhook(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name        string
+		token       string
+		contentType string
+		body        string
+		wantCode    int
+	}{
+		{"JSON", mock.IncomingWebhookToken, "application/json", `{"text": "Build #42 passed"}`, http.StatusOK},
+		{"Form payload", mock.IncomingWebhookToken, "application/x-www-form-urlencoded",
+			url.Values{"payload": {`{"text": "Build #42 passed"}`}}.Encode(), http.StatusOK},
+		{"Unknown fields", mock.IncomingWebhookToken, "application/json", `{"text": "Build #42 passed", "icon_emoji": ":ghost:"}`, http.StatusOK},
+		{"Invalid token", "wrong", "application/json", `{"text": "Build #42 passed"}`, http.StatusNotFound},
+		{"Malformed JSON", mock.IncomingWebhookToken, "application/json", `{"text": `, http.StatusBadRequest},
+		{"Empty text", mock.IncomingWebhookToken, "application/json", `{"text": ""}`, http.StatusBadRequest},
+		{"Too long text", mock.IncomingWebhookToken, "application/json", `{"text": "` + strings.Repeat("a", 5000) + `"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+
+			resp, err := ts.Client().Post(ts.URL+"/hooks/"+tt.token, tt.contentType, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, tt.wantCode, resp.StatusCode)
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, "ok", string(body))
+			}
+		})
+	}
+}
+
+func TestApplicationAuthenticatesBotsByToken(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name     string
+		path     string
+		token    string
+		wantCode int
+	}{
+		{"Valid token", "/rooms", mock.BotToken, http.StatusOK},
+		{"Invalid token", "/rooms", "wrong", http.StatusUnauthorized},
+		{"Bots are not admins", "/bots", mock.BotToken, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			assert.Equal(t, tt.wantCode, resp.StatusCode)
+		})
+	}
+}
+
+func TestApplication_SearchMessages(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name      string
+		path      string
+		wantCode  int
+		wantFound []int64
+	}{
+		{"Found", "/search?q=" + url.QueryEscape(mock.MessageMock.Text), http.StatusOK, []int64{mock.MessageMock.ID}},
+		{"By author", "/search?q=hello&author=" + mock.MessageMock.Username, http.StatusOK, []int64{mock.MessageMock.ID}},
+		{"By other author", "/search?q=hello&author=Ann", http.StatusOK, []int64{}},
+		{"Time range", "/search?q=hello&since=2021-06-13T15:00:00Z&until=2100-01-01T00:00:00Z", http.StatusOK, []int64{mock.MessageMock.ID}},
+		{"Nothing found", "/search?q=bye", http.StatusOK, []int64{}},
+		{"Empty query", "/search?q=", http.StatusUnprocessableEntity, nil},
+		{"Invalid time", "/search?q=hello&since=yesterday", http.StatusBadRequest, nil},
+		{"Invalid room", "/search?q=hello&room=abc", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+			ts.authenticate(t)
+
+			code, _, body := ts.get(t, tt.path)
+
+			assert.Equal(t, tt.wantCode, code)
+			if tt.wantFound == nil {
+				return
+			}
+
+			var found []chat.Found
+			err := json.Unmarshal([]byte(body), &found)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []int64{}
+			for _, f := range found {
+				ids = append(ids, f.Message.ID)
+				assert.Contains(t, f.Headline, "<mark>")
+			}
+			assert.Equal(t, tt.wantFound, ids)
+		})
+	}
+}
+
+func TestApplication_UploadAttachment(t *testing.T) {
+	app := newTestApp()
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 640, 480)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	picture := buf.Bytes()
+	malformed := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 100)...)
+
+	tests := []struct {
+		name            string
+		filename        string
+		contents        []byte
+		withoutToken    bool
+		wantCode        int
+		wantContentType string
+		wantThumbnail   *chat.Thumbnail
+	}{
+		{"Image", "../cat.png", picture, false, http.StatusCreated, "image/png",
+			&chat.Thumbnail{URL: "/attachments/3/thumbnail", Width: 320, Height: 240}},
+		{"Text", "notes.txt", []byte("hello world"), false, http.StatusCreated, "text/plain; charset=utf-8", nil},
+		{"Malformed image", "cat.png", malformed, false, http.StatusUnsupportedMediaType, "", nil},
+		{"Unsupported type", "app.exe", []byte("MZ\x90\x00\x03\x00\x00\x00"), false, http.StatusUnsupportedMediaType, "", nil},
+		{"Too large", "big.txt", bytes.Repeat([]byte("a"), attachmentMaxSize+1), false, http.StatusRequestEntityTooLarge, "", nil},
+		{"Body too large", "big.txt", bytes.Repeat([]byte("a"), attachmentMaxSize+multipartOverhead), false,
+			http.StatusRequestEntityTooLarge, "", nil},
+		{"No file", "", nil, false, http.StatusBadRequest, "", nil},
+		{"No csrf token", "cat.png", picture, true, http.StatusBadRequest, "", nil},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+			ts.authenticate(t)
+
+			token := ts.csrfToken(t)
+			if tt.withoutToken {
+				token = ""
+			}
+
+			code, _, body := ts.upload(t, "/attachments", tt.filename, tt.contents, token)
+
+			assert.Equal(t, tt.wantCode, code)
+			if code != http.StatusCreated {
+				return
+			}
+
+			var a chat.Attachment
+			err := json.Unmarshal([]byte(body), &a)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, tt.wantContentType, a.ContentType)
+			assert.Equal(t, int64(len(tt.contents)), a.Size)
+			assert.Equal(t, strings.TrimPrefix(tt.filename, "../"), a.Filename)
+			assert.Equal(t, "/attachments/3", a.URL)
+			assert.Equal(t, tt.wantThumbnail, a.Thumbnail)
+		})
+	}
+}
+
+func TestApplication_UploadMalformedAttachment(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"Not multipart", "text/plain", "hello world"},
+		{"Malformed multipart", "multipart/form-data; boundary=xyz", "--xyz\r\nContent-Disposition: form-data"},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+			ts.authenticate(t)
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/attachments", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			// the token is sent in the header as the body can't be parsed
+			req.Header.Set("X-CSRF-Token", ts.csrfToken(t))
+
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
+
+func TestApplication_DownloadAttachment(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name            string
+		path            string
+		wantCode        int
+		wantBody        string
+		wantDisposition string
+	}{
+		{"Sent file", "/attachments/1", http.StatusOK, "hello world", `attachment; filename=hello.txt`},
+		{"Own pending image", "/attachments/2", http.StatusOK, "\x89PNG\r\n\x1a\n", `inline; filename=cat.png`},
+		{"Thumbnail", "/attachments/2/thumbnail", http.StatusOK, "thumbnail", "inline"},
+		{"File without thumbnail", "/attachments/1/thumbnail", http.StatusNotFound, "", ""},
+		{"Pending file of other user", "/attachments/3", http.StatusNotFound, "", ""},
+		{"Non-existent file", "/attachments/42", http.StatusNotFound, "", ""},
+		{"Invalid id", "/attachments/abc", http.StatusNotFound, "", ""},
+	}
+
+	for _, tt := range tests {
+		tt := tt // create new variable for each closure
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := newTestServer(t, app.NewRouter())
+			ts.authenticate(t)
+
+			code, header, body := ts.get(t, tt.path)
+
+			assert.Equal(t, tt.wantCode, code)
+			if code != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, tt.wantBody, body)
+			assert.Equal(t, tt.wantDisposition, header.Get("Content-Disposition"))
+			assert.Equal(t, "nosniff", header.Get("X-Content-Type-Options"))
+		})
+	}
+}
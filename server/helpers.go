@@ -2,14 +2,23 @@ package server
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/sessions"
 
+	"github.com/lazy-void/chatapp/blob"
 	"github.com/lazy-void/chatapp/chat"
 	"github.com/lazy-void/chatapp/models"
 
@@ -42,9 +51,25 @@ func (app *Application) clientError(w http.ResponseWriter, code int) {
 	http.Error(w, http.StatusText(code), code)
 }
 
+func (app *Application) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, err = w.Write(js)
+	if err != nil {
+		log.Err(err).Msg("error writing json response")
+	}
+}
+
 func (app *Application) addDefaultData(w http.ResponseWriter, r *http.Request, td templateData) templateData {
 	if user := app.authenticatedUser(r); user != nil {
 		td.Username = user.Username
+		td.Role = user.Role
 	}
 
 	td.CSRFToken = nosurf.Token(r)
@@ -106,3 +131,131 @@ func (app *Application) deleteAuthCookie(w http.ResponseWriter, r *http.Request)
 		app.serverError(w, err)
 	}
 }
+
+// attachment returns the attachment from the URL if the authenticated
+// user can download it. Otherwise, it writes the error and returns false.
+// Files the user has no access to are reported as missing.
+func (app *Application) attachment(w http.ResponseWriter, r *http.Request) (models.Attachment, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return models.Attachment{}, false
+	}
+
+	a, err := app.Attachments.Get(id)
+	if errors.Is(err, models.ErrNoRecord) {
+		app.clientError(w, http.StatusNotFound)
+		return models.Attachment{}, false
+	} else if err != nil {
+		app.serverError(w, err)
+		return models.Attachment{}, false
+	}
+
+	ok, err := app.canDownload(app.authenticatedUser(r).Username, a)
+	if err != nil {
+		app.serverError(w, err)
+		return models.Attachment{}, false
+	}
+	if !ok {
+		app.clientError(w, http.StatusNotFound)
+		return models.Attachment{}, false
+	}
+
+	return a, true
+}
+
+// sendBlob writes contents of the blob with headers
+// that keep browsers from running it.
+func (app *Application) sendBlob(w http.ResponseWriter, key, contentType, disposition string) {
+	contents, err := app.Blobs.Get(key)
+	if errors.Is(err, blob.ErrNotFound) {
+		app.clientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	defer contents.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+
+	_, err = io.Copy(w, contents)
+	if err != nil {
+		log.Err(err).Msg("error sending blob")
+	}
+}
+
+// deleteBlobs removes the file and its thumbnail
+// if the attachment couldn't be stored.
+func (app *Application) deleteBlobs(a models.Attachment) {
+	for _, key := range []string{a.Key, a.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		err := app.Blobs.Delete(key)
+		if err != nil {
+			log.Err(err).Str("key", key).Msg("error deleting blob")
+		}
+	}
+}
+
+// canDownload reports whether the user can download the attached file.
+// Files attached to messages can be downloaded by members of the room,
+// and files that are not sent yet only by the uploader.
+func (app *Application) canDownload(username string, a models.Attachment) (bool, error) {
+	if a.MessageID == 0 {
+		return a.Username == username, nil
+	}
+
+	msg, err := app.Messages.Get(a.MessageID)
+	if err != nil {
+		return false, err
+	}
+	if msg.Deleted {
+		return false, nil
+	}
+
+	return app.Rooms.IsMember(msg.RoomID, username)
+}
+
+// attachmentFilename returns base name of the uploaded file
+// cut to the maximum length.
+func attachmentFilename(name string) string {
+	name = strings.ToValidUTF8(name, "")
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" {
+		name = "file"
+	}
+
+	if utf8.RuneCountInString(name) > filenameMaxLength {
+		name = string([]rune(name)[:filenameMaxLength])
+	}
+
+	return name
+}
+
+// validWebhookURL reports whether the payloads can be posted to the URL.
+func validWebhookURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// validDisplayName reports whether the name can be shown instead
+// of the username. It follows the rules of the nicknames.
+func validDisplayName(name string) bool {
+	return !strings.ContainsAny(name, `<>&'"`)
+}
+
+// absoluteURL returns the URL of the path on the host the request was sent to.
+func absoluteURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return (&url.URL{Scheme: scheme, Host: r.Host, Path: path}).String()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/lazy-void/chatapp/chat"
 	"github.com/lazy-void/chatapp/models"
@@ -12,7 +13,42 @@ import (
 )
 
 func csrfHandler(next http.Handler) http.Handler {
-	return nosurf.New(next)
+	h := nosurf.New(next)
+	// browsers don't send the Authorization header on their own,
+	// so requests of bots can't be forged
+	h.ExemptFunc(func(r *http.Request) bool {
+		_, ok := bearerToken(r)
+		return ok
+	})
+
+	return h
+}
+
+// bearerToken returns the API token from the Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+
+	header := r.Header.Get("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+// limitRequestBody fails reading of request bodies bigger than n bytes.
+func limitRequestBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 func (app *Application) requireNonAuthenticatedUser(next http.Handler) http.Handler {
@@ -37,8 +73,31 @@ func (app *Application) requireAuthenticatedUser(next http.Handler) http.Handler
 	})
 }
 
+// requireRole allows only the users with the role or a higher one.
+// It must be used after requireAuthenticatedUser.
+func (app *Application) requireRole(role models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.authenticatedUser(r).Role.AtLeast(role) {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticate adds the user to the context of the request. Bots are
+// authenticated by the API token from the Authorization header, and
+// other users by the session cookie.
 func (app *Application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := bearerToken(r); ok {
+			app.authenticateBot(w, r, token, next)
+			return
+		}
+
 		s := app.getUserSession(r)
 		username, ok := s.Values[usernameSessionKey].(string)
 		if !ok {
@@ -47,8 +106,9 @@ func (app *Application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// banned users are logged out
 		user, err := app.Users.Get(username)
-		if errors.Is(err, models.ErrNoRecord) {
+		if errors.Is(err, models.ErrNoRecord) || err == nil && user.Banned {
 			app.deleteAuthCookie(w, r)
 			next.ServeHTTP(w, r)
 			return
@@ -61,3 +121,30 @@ func (app *Application) authenticate(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// authenticateBot adds the bot with the API token to the context
+// of the request. Requests with invalid tokens are rejected.
+func (app *Application) authenticateBot(w http.ResponseWriter, r *http.Request, token string, next http.Handler) {
+	username, err := app.Users.AuthenticateToken(token)
+	if errors.Is(err, models.ErrInvalidToken) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chatapp"`)
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	user, err := app.Users.Get(username)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if user.Banned {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), chat.ContextUserKey, user)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/lazy-void/chatapp/blob"
 	"github.com/lazy-void/chatapp/chat"
 	"github.com/lazy-void/chatapp/models"
 
@@ -24,25 +25,111 @@ var htmlTemplates embed.FS
 type Application struct {
 	Sessions sessions.Store
 	Messages interface {
-		Insert(text string, username string, created time.Time) (int, error)
-		Latest(n int, offset int) ([]models.Message, error)
+		Insert(roomID, parentID int64, text, username, key string, attachments []int64, created time.Time) (models.Message, error)
+		Get(id int64) (models.Message, error)
+		Before(roomID, id int64, n int) ([]models.Message, error)
+		Thread(id int64, n int) ([]models.Message, error)
+		React(id int64, username, emoji string) (models.Message, error)
+		Unreact(id int64, username, emoji string) (models.Message, error)
+		After(username string, id int64, n int) ([]models.Message, error)
+		Changed(username string, id int64, n int) ([]models.Message, error)
+		Edit(id int64, username, text string, edited time.Time) (models.Message, error)
+		Delete(id int64, username string) (models.Message, error)
+		Remove(id int64) (models.Message, error)
+		AddPreviews(id int64, urls []string) (models.Message, error)
+		Mention(id int64, usernames []string) error
+		Search(username string, q models.SearchQuery, n int) ([]models.SearchResult, error)
+		Mentioned(username string, n int) ([]models.Message, error)
 	}
+	Rooms interface {
+		Insert(name, creator string) (int64, error)
+		Get(id int64) (models.Room, error)
+		All() ([]models.Room, error)
+		Joined(username string) ([]models.Room, error)
+		Direct(username, peer string) (int64, error)
+		Join(roomID int64, username string) error
+		Leave(roomID int64, username string) error
+		IsMember(roomID int64, username string) (bool, error)
+		Members(roomID int64, usernames []string) ([]string, error)
+		MarkRead(roomID int64, username string, messageID int64) error
+		Unread(username string) (map[int64]int, error)
+		SetTopic(id int64, topic string) error
+	}
+
+	Attachments interface {
+		Insert(a models.Attachment) (models.Attachment, error)
+		Get(id int64) (models.Attachment, error)
+	}
+
+	// Blobs keeps contents of the uploaded files.
+	Blobs blob.Store
+
+	// Broker connects the chat with other instances of
+	// the application. It is not used if nil.
+	Broker chat.Broker
+
+	// Unfurler loads previews of the links in messages.
+	// Previews are disabled if nil.
+	Unfurler chat.Unfurler
+
+	Webhooks interface {
+		Insert(w models.Webhook) (int64, error)
+		All() ([]models.Webhook, error)
+		Delete(id int64) error
+		Failed(n int) ([]models.Delivery, error)
+	}
+
+	IncomingWebhooks interface {
+		Insert(w models.IncomingWebhook) (string, error)
+		All() ([]models.IncomingWebhook, error)
+		Delete(id int64) error
+		Authenticate(token string) (models.IncomingWebhook, error)
+	}
+
 	Users interface {
 		Insert(username, email, password string) error
 		Authenticate(email, password string) (string, error)
 		Get(username string) (models.User, error)
+		SetNickname(username, nickname string) error
+		InsertBot(username, creator string) (string, error)
+		Bots() ([]models.User, error)
+		People() ([]models.User, error)
+		SetRole(username string, role models.Role) error
+		NewToken(username string) (string, error)
+		RevokeToken(username string) error
+		AuthenticateToken(token string) (string, error)
+		Sanction(s models.Sanction) (int64, error)
+		ActiveSanction(username string, kind models.SanctionKind) (models.Sanction, error)
+		LiftSanction(username string, kind models.SanctionKind) error
 	}
+
+	// Commands are slash commands registered in addition
+	// to the built-in ones, which they can override.
+	Commands []chat.Command
 }
 
 // NewRouter returns initialized server router.
 func (app *Application) NewRouter() http.Handler {
 	// start chat hub
-	hub := chat.NewHub(app.Messages)
+	hub := chat.NewHub(app.Messages, app.Rooms, app.Users, chat.HubOptions{
+		Broker:   app.Broker,
+		Unfurler: app.Unfurler,
+	})
+	for _, cmd := range app.Commands {
+		hub.Commands().Register(cmd)
+	}
 	go hub.Run()
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger, middleware.Recoverer)
 
+	// body of the upload is limited before the csrf handler can read it
+	r.With(limitRequestBody(attachmentMaxSize+multipartOverhead), csrfHandler, app.authenticate, app.requireAuthenticatedUser).
+		Post("/attachments", app.uploadAttachment)
+
+	// incoming webhooks are authenticated by the token in the URL
+	r.With(limitRequestBody(incomingWebhookMaxSize)).Post("/hooks/{token}", app.postIncomingWebhook(hub))
+
 	r.Group(func(r chi.Router) {
 		r.Use(csrfHandler, app.authenticate)
 
@@ -54,6 +141,36 @@ func (app *Application) NewRouter() http.Handler {
 				chat.ServeWS(hub, w, r)
 			})
 			r.Post("/user/logout", app.logoutUser)
+			r.Get("/mentions", app.mentions)
+
+			r.Get("/rooms", app.listRooms(hub))
+			r.Get("/rooms/unread", app.unreadCounts)
+			r.Post("/rooms", app.createRoom(hub))
+			r.Post("/rooms/{id}/join", app.joinRoom(hub))
+			r.Post("/rooms/{id}/leave", app.leaveRoom(hub))
+			r.Get("/rooms/{id}/messages", app.roomHistory(hub))
+			r.With(limitRequestBody(messageRequestMaxSize)).Post("/rooms/{id}/messages", app.sendMessage(hub))
+			r.Get("/search", app.searchMessages(hub))
+			r.Get("/attachments/{id}", app.downloadAttachment)
+			r.Get("/attachments/{id}/thumbnail", app.downloadThumbnail)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireRole(models.RoleAdmin))
+
+				r.Get("/users", app.listUsers)
+				r.Post("/users/{username}/role", app.setRole)
+
+				r.Get("/bots", app.listBots)
+				r.Post("/bots", app.createBot)
+				r.Post("/bots/{username}/token", app.newBotToken)
+				r.Post("/bots/{username}/revoke", app.revokeBotToken)
+
+				r.Get("/webhooks", app.listWebhooks)
+				r.Post("/webhooks", app.createWebhook)
+				r.Post("/webhooks/{id}/delete", app.deleteWebhook)
+				r.Post("/webhooks/incoming", app.createIncomingWebhook)
+				r.Post("/webhooks/incoming/{id}/delete", app.deleteIncomingWebhook)
+			})
 		})
 
 		r.Group(func(r chi.Router) {
@@ -1,37 +1,315 @@
 let conn;
-let msg = document.querySelector('input[name="message"]');
+let msg = document.querySelector('textarea[name="message"]');
 let chat = document.querySelector(".chat-scroll");
+let roomList = document.querySelector("#room-list");
+let directList = document.querySelector("#direct-list");
+let roomTitle = document.querySelector("#room-title");
+let typingLine = document.querySelector("#typing");
+let onlineList = document.querySelector("#online-list");
+let seenLine = document.querySelector("#seen");
+let threadPanel = document.querySelector("#thread");
+let threadMessages = document.querySelector("#thread-messages");
+let searchPanel = document.querySelector("#search");
+let searchResults = document.querySelector("#search-results");
+let fileInput = document.querySelector("#attachment-file");
+let attachedList = document.querySelector("#attached");
 let clientUsername = document.querySelector(".badge").innerHTML
+// moderators and admins can delete messages of other users in rooms
+let clientModerates = ["moderator", "admin"].includes(document.querySelector(".badge").dataset.role);
 let reachedHistoryEnd = false;
+let loadingHistory = false;
+let oldestMessage = 0;
+let currentRoom = null;
+let pendingDirect = null;
+let rooms = [];
+
+// Time to wait before reconnecting, doubled after every failed attempt.
+const minReconnectDelay = 1000;
+const maxReconnectDelay = 30000;
+let reconnectDelay = minReconnectDelay;
+// Close code of the connections of kicked and banned users.
+const kickedCloseCode = 4000;
+// id of the newest message received, used to resume after reconnecting
+let lastMessage = 0;
+let lastRequest = 0;
+// messages sent by us that are not acknowledged yet, by their keys
+let outbox = {};
+// users typing in every room
+let typists = {};
+let onlineUsers = new Set();
+// numbers of unread messages by rooms
+let unread = {};
+// ids of the last messages read by other users in every room
+let readers = {};
+// id of the message that started the thread shown in the panel
+let openThread = null;
+// files uploaded for the message being written
+let attached = [];
+// emoji offered for quick reactions
+const quickReactions = ["👍", "❤️", "😂", "🎉", "😮", "😢"];
+// the server forgets that we are typing after a few seconds,
+// so we remind it at most this often while the user keeps typing
+const typingInterval = 2000;
+let lastTyping = 0;
 
 if (window["WebSocket"]) {
+    connect();
+} else {
+    appendNotice("<b>Your browser does not support WebSockets.</b>");
+}
+
+function connect() {
     conn = new WebSocket("ws://" + document.location.host + "/ws");
 
-    conn.onopen = loadMore;
+    conn.onopen = function () {
+        reconnectDelay = minReconnectDelay;
+        loadingHistory = false;
+        listRooms();
+        // also subscribes to presence events
+        sendRequest({"action": "online"});
+        loadUnread();
+        if (lastMessage > 0) {
+            resume();
+        }
+        // it is safe to send them again, duplicates are detected by keys
+        Object.values(outbox).forEach((entry) => {
+            if (!entry.failed) {
+                sendRequest(entry.req);
+            }
+        });
+    };
 
     conn.onclose = function (ev) {
-        appendEnd("<b>Connection closed.</b>", new Date());
+        typists = {};
+        renderTyping();
+        onlineUsers.clear();
+        renderOnline();
+
+        // kicked and banned users reconnect by reloading the page
+        if (ev.code === kickedCloseCode) {
+            appendNotice("<b>" + escapeHTML(ev.reason || "You are kicked.") + "</b> Reload the page to reconnect.");
+            return;
+        }
+        appendNotice("<b>Connection closed.</b> Reconnecting...");
+
+        // add jitter so that clients don't reconnect all at once
+        setTimeout(connect, reconnectDelay * (1 + Math.random() / 2));
+        reconnectDelay = Math.min(reconnectDelay * 2, maxReconnectDelay);
     };
 
     conn.onmessage = function (ev) {
         let update = JSON.parse(ev.data);
 
-        if (update.request !== undefined && update.request.action === "loadMore") {
-            if (update.messages.length === 0) {
+        if (update.request !== undefined) {
+            handleResponse(update);
+            return;
+        }
+        if (update.event !== undefined) {
+            handleEvent(update);
+            return;
+        }
+
+        update.messages.forEach(receiveMessage);
+    };
+}
+
+// sendRequest sends the request with a unique id
+// that the response to it is going to carry.
+function sendRequest(req) {
+    req.id = String(++lastRequest);
+    conn.send(JSON.stringify(req));
+}
+
+function connected() {
+    return conn && conn.readyState === WebSocket.OPEN;
+}
+
+function receiveMessage(msg) {
+    lastMessage = Math.max(lastMessage, msg.id);
+    if (msg.parent) {
+        // replayed reply, live ones come as events
+        receiveReply(msg);
+        return;
+    }
+
+    // our message replaces the pending or acknowledged one
+    let item = pendingItem(msg.key) || chat.querySelector('[data-id="' + msg.id + '"]');
+    if (msg.username === clientUsername && item) {
+        item.replaceWith(createMessage(msg));
+        return;
+    }
+
+    if (!rooms.some((room) => room.id === msg.room)) {
+        // somebody has started a direct conversation with us
+        listRooms();
+    }
+    if (msg.room !== currentRoom) {
+        if (msg.username !== clientUsername) {
+            markUnread(msg.room);
+        }
+        return;
+    }
+    appendEnd(msg);
+    markRead(msg.id);
+    renderSeen();
+}
+
+function handleEvent(event) {
+    switch (event.event) {
+        case "presence":
+            if (event.online) {
+                onlineUsers.add(event.username);
+            } else {
+                onlineUsers.delete(event.username);
+            }
+            renderOnline();
+            break;
+        case "read":
+            if (event.username === clientUsername) {
+                // read in another tab
+                unread[event.room] = 0;
+                renderUnread();
+                break;
+            }
+            readers[event.room] = readers[event.room] || {};
+            readers[event.room][event.username] = Math.max(readers[event.room][event.username] || 0, event.messageId);
+            renderSeen();
+            break;
+        case "reply":
+            lastMessage = Math.max(lastMessage, event.message.id);
+            receiveReply(event.message, event.replies);
+            break;
+        case "mention":
+            // the message itself is delivered as an update if we are in the room
+            if (event.room !== currentRoom || document.visibilityState !== "visible") {
+                notifyMention(event.message);
+            }
+            break;
+        case "typing":
+            let users = typists[event.room] || new Set();
+            if (event.typing) {
+                users.add(event.username);
+            } else {
+                users.delete(event.username);
+            }
+            typists[event.room] = users;
+            renderTyping();
+            break;
+        case "topic":
+            let changed = rooms.find((room) => room.id === event.room);
+            if (changed) {
+                changed.topic = event.topic;
+            }
+            if (event.room === currentRoom) {
+                renderTitle();
+                appendNotice(escapeHTML(event.username + " has changed the topic to: " + event.topic));
+            }
+            break;
+        case "edit":
+        case "delete":
+        case "reaction":
+        case "preview":
+            replaceMessage(event.message);
+            break;
+    }
+}
+
+// replaceMessage shows the changed message instead of the old one.
+function replaceMessage(msg) {
+    // the message may be shown both in the timeline and in the thread
+    [chat, threadMessages].forEach((list) => {
+        let item = list.querySelector('[data-id="' + msg.id + '"]');
+        if (item) {
+            item.replaceWith(createMessage(msg));
+        }
+    });
+}
+
+function handleResponse(resp) {
+    if (resp.error) {
+        if (resp.request.action === "loadMore") {
+            loadingHistory = false;
+        }
+        if (outbox[resp.request.key]) {
+            markFailed(resp.request.key, resp.error.message);
+            return;
+        }
+        alert(resp.error.message);
+        return;
+    }
+    if (resp.ack) {
+        acknowledge(resp.ack);
+        return;
+    }
+    if (outbox[resp.request.key]) {
+        // commands are answered instead of being sent as messages
+        delete outbox[resp.request.key];
+        let item = pendingItem(resp.request.key);
+        if (item) {
+            item.remove();
+        }
+    }
+    if (resp.reply) {
+        appendReply(resp.reply);
+    }
+
+    switch (resp.request.action) {
+        case "loadMore":
+            if (resp.request.room !== currentRoom) {
+                return;
+            }
+            loadingHistory = false;
+            if (resp.messages.length === 0) {
                 reachedHistoryEnd = true;
                 return;
             }
-            update.messages.forEach((msg) => {
-                appendStart(msg.text, msg.username, new Date(msg.created));
-            })
-        } else {
-            update.messages.forEach((msg) => {
-                appendEnd(msg.text, msg.username, new Date(msg.created));
+            if (oldestMessage === 0) {
+                // the latest messages of the room are shown
+                markRead(resp.messages[0].id);
+            }
+            resp.messages.forEach((msg) => {
+                appendStart(msg);
             })
-        }
-    };
-} else {
-    appendEnd("<b>Your browser does not support WebSockets.</b>", new Date());
+            oldestMessage = resp.messages[resp.messages.length - 1].id;
+            lastMessage = Math.max(lastMessage, resp.messages[0].id);
+            break;
+        case "search":
+            renderSearch(resp.results || []);
+            break;
+        case "thread":
+            if (resp.request.messageId !== openThread) {
+                return;
+            }
+            threadMessages.innerHTML = "";
+            resp.messages.forEach((msg) => threadMessages.append(createMessage(msg)));
+            threadMessages.scrollTop = threadMessages.scrollHeight;
+            break;
+        case "online":
+            onlineUsers = new Set(resp.users);
+            renderOnline();
+            break;
+        case "resume":
+            (resp.changed || []).forEach(replaceMessage);
+            resp.messages.forEach(receiveMessage);
+            if (resp.messages.length > 0) {
+                // there may be more missed messages
+                resume();
+            }
+            break;
+        case "listRooms":
+        case "createRoom":
+        case "joinRoom":
+        case "leaveRoom":
+            renderRooms(resp.rooms);
+            break;
+        case "direct":
+            renderRooms(resp.rooms);
+            let room = rooms.find((room) => room.direct && room.name === resp.request.to);
+            if (room && pendingDirect === resp.request.to) {
+                switchRoom(room.id);
+            }
+            break;
+    }
 }
 
 chat.onscroll = () => {
@@ -44,78 +322,856 @@ chat.onscroll = () => {
 }
 
 document.querySelector("#msg-form").onsubmit = function () {
-    if (!conn) {
+    if (currentRoom === null && pendingDirect === null) {
         return false;
     }
-    if (!msg.value) {
+    if (!msg.value && attached.length === 0) {
         return false;
     }
 
-    conn.send(JSON.stringify({
+    // messages written while offline are sent after reconnecting
+    if (currentRoom === null) {
+        sendMessage({
+            "action": "direct",
+            "to": pendingDirect,
+            "message": msg.value,
+            "attachments": attached.map((a) => a.id)
+        }, attached);
+    } else {
+        sendMessage({
+            "action": "broadcast",
+            "room": currentRoom,
+            "message": msg.value,
+            "attachments": attached.map((a) => a.id)
+        }, attached);
+    }
+
+    msg.value = "";
+    attached = [];
+    renderAttached();
+    lastTyping = 0;
+    return false;
+};
+
+fileInput.onchange = function () {
+    Array.from(fileInput.files).forEach(upload);
+    fileInput.value = "";
+};
+
+// upload stores the file on the server, it is attached
+// to the next message sent by the user.
+function upload(file) {
+    let data = new FormData();
+    data.append("file", file);
+
+    fetch("/attachments", {
+        method: "POST",
+        headers: {"X-CSRF-Token": document.querySelector('input[name="csrf_token"]').value},
+        body: data
+    }).then((resp) => {
+        if (!resp.ok) {
+            throw new Error(resp.status === 413 ? "file is too large" : resp.status === 415 ? "file type is not supported" : "upload failed");
+        }
+        return resp.json();
+    }).then((attachment) => {
+        attached.push(attachment);
+        renderAttached();
+    }).catch((err) => {
+        appendNotice("<b>" + escapeHTML(file.name + ": " + err.message) + "</b>");
+    });
+}
+
+function renderAttached() {
+    attachedList.innerHTML = "";
+    attached.forEach((attachment) => {
+        let item = document.createElement("span");
+        item.setAttribute("class", "badge bg-secondary me-1");
+        item.textContent = attachment.filename + " ";
+
+        let remove = document.createElement("a");
+        remove.textContent = "×";
+        remove.onclick = () => {
+            attached = attached.filter((a) => a !== attachment);
+            renderAttached();
+        };
+        item.appendChild(remove);
+        attachedList.appendChild(item);
+    });
+}
+
+msg.onkeydown = function (ev) {
+    if (ev.key === "Enter" && !ev.shiftKey) {
+        ev.preventDefault();
+        document.querySelector("#msg-form").requestSubmit();
+    }
+};
+
+msg.oninput = function () {
+    if (!connected() || currentRoom === null || !msg.value) {
+        return;
+    }
+
+    let now = Date.now();
+    if (now - lastTyping < typingInterval) {
+        return;
+    }
+    lastTyping = now;
+    sendRequest({"action": "typing", "room": currentRoom});
+};
+
+// receiveReply shows the reply in the thread if it is open
+// and updates the number of replies to the thread.
+function receiveReply(msg, replies) {
+    if (msg.parent === openThread && threadMessages.querySelector('[data-id="' + msg.id + '"]') === null) {
+        threadMessages.append(createMessage(msg));
+        threadMessages.scrollTop = threadMessages.scrollHeight;
+    }
+
+    let parent = chat.querySelector('[data-id="' + msg.parent + '"]');
+    if (parent === null) {
+        return;
+    }
+    let link = parent.querySelector(".thread-link");
+    if (replies === undefined) {
+        replies = (Number(link.dataset.replies) || 0) + 1;
+    }
+    link.dataset.replies = replies;
+    link.textContent = repliesText(replies);
+}
+
+function repliesText(replies) {
+    if (!replies) {
+        return "reply";
+    }
+    return replies + (replies === 1 ? " reply" : " replies");
+}
+
+// showThread opens the thread started by the message with the id.
+// The message itself is shown right away if it is known.
+function showThread(id, msg) {
+    openThread = id;
+    threadPanel.hidden = false;
+    threadMessages.innerHTML = "";
+    if (msg) {
+        threadMessages.append(createMessage(msg));
+    }
+    if (connected()) {
+        sendRequest({"action": "thread", "messageId": id});
+    }
+}
+
+function closeThread() {
+    openThread = null;
+    threadPanel.hidden = true;
+    threadMessages.innerHTML = "";
+}
+
+document.querySelector("#thread-close").onclick = closeThread;
+
+document.querySelector("#thread-form").onsubmit = function () {
+    let reply = document.querySelector('input[name="reply"]');
+    if (!connected() || openThread === null || !reply.value) {
+        return false;
+    }
+
+    sendRequest({
         "action": "broadcast",
-        "message": msg.value
+        "room": currentRoom,
+        "parent": openThread,
+        "message": reply.value
+    });
+
+    reply.value = "";
+    return false;
+};
+
+document.querySelector("#search-form").onsubmit = function () {
+    let query = document.querySelector('input[name="query"]');
+    if (!connected() || !query.value.trim()) {
+        return false;
+    }
+
+    sendRequest({"action": "search", "query": query.value});
+    return false;
+};
+
+document.querySelector("#search-close").onclick = () => {
+    searchPanel.hidden = true;
+};
+
+function renderSearch(results) {
+    searchPanel.hidden = false;
+    searchResults.innerHTML = "";
+    if (results.length === 0) {
+        searchResults.innerHTML = '<li class="list-group-item text-muted">Nothing found.</li>';
+        return;
+    }
+
+    results.forEach((result) => {
+        let msg = result.message;
+        let room = rooms.find((room) => room.id === msg.room);
+
+        let item = document.createElement("li");
+        item.setAttribute("class", "list-group-item search-result");
+
+        let info = document.createElement("div");
+        info.setAttribute("class", "small text-muted");
+        info.textContent = msg.username + (room ? " in " + roomName(room) : "") + ", " +
+            new Date(msg.created).toLocaleString();
+        item.appendChild(info);
+
+        // the headline is escaped by the server, only the marks are added
+        let text = document.createElement("div");
+        text.innerHTML = result.headline;
+        item.appendChild(text);
+
+        item.onclick = () => {
+            switchRoom(msg.room);
+            if (msg.parent) {
+                showThread(msg.parent);
+            }
+        };
+        searchResults.appendChild(item);
+    });
+}
+
+// sendMessage sends the message request with a new key
+// and shows the message as pending until it is acknowledged.
+function sendMessage(req, files = []) {
+    req.key = Date.now().toString(36) + Math.random().toString(36).substring(2);
+    outbox[req.key] = {req: req, files: files, failed: false};
+    insertEnd(createPending(req, files));
+
+    if (connected()) {
+        sendRequest(req);
+    }
+}
+
+function acknowledge(ack) {
+    let entry = outbox[ack.key];
+    delete outbox[ack.key];
+
+    // the message itself may have arrived first
+    let item = pendingItem(ack.key);
+    if (!entry || !item) {
+        return;
+    }
+
+    item.replaceWith(createMessage({
+        id: ack.id,
+        room: entry.req.room,
+        text: entry.req.message,
+        // formatted once the message itself arrives
+        html: escapeHTML(entry.req.message),
+        username: clientUsername,
+        created: ack.created,
+        attachments: entry.files,
+        key: ack.key
     }));
+}
 
-    msg.value = "";
+function markFailed(key, reason) {
+    let item = pendingItem(key);
+    outbox[key].failed = true;
+    if (!item) {
+        return;
+    }
+
+    item.classList.replace("pending", "failed");
+    let status = item.querySelector(".client-time");
+    status.textContent = "failed: " + reason + " ";
+
+    let retry = document.createElement("a");
+    retry.textContent = "retry";
+    retry.onclick = () => {
+        outbox[key].failed = false;
+        item.classList.replace("failed", "pending");
+        status.textContent = "sending...";
+        if (connected()) {
+            sendRequest(outbox[key].req);
+        }
+    };
+    status.appendChild(retry);
+}
+
+function pendingItem(key) {
+    if (!key) {
+        return null;
+    }
+    return chat.querySelector('[data-key="' + CSS.escape(key) + '"]');
+}
+
+document.querySelector("#direct-form").onsubmit = function () {
+    let username = document.querySelector('input[name="direct"]');
+    if (!connected() || !username.value) {
+        return false;
+    }
+
+    openDirect(username.value);
+    username.value = "";
     return false;
 };
 
+function openDirect(username) {
+    let room = rooms.find((room) => room.direct && room.name === username);
+    if (room) {
+        switchRoom(room.id);
+    } else {
+        // conversation is created with the first message
+        switchRoom(null);
+        pendingDirect = username;
+        roomTitle.textContent = "@" + username;
+    }
+}
+
+document.querySelector("#room-form").onsubmit = function () {
+    let name = document.querySelector('input[name="room"]');
+    if (!connected() || !name.value) {
+        return false;
+    }
+
+    sendRequest({
+        "action": "createRoom",
+        "name": name.value
+    });
+
+    name.value = "";
+    return false;
+};
+
+function listRooms() {
+    sendRequest({"action": "listRooms"});
+}
+
+function resume() {
+    sendRequest({"action": "resume", "after": lastMessage});
+}
+
+function joinRoom(id) {
+    sendRequest({"action": "joinRoom", "room": id});
+}
+
+function leaveRoom(id) {
+    sendRequest({"action": "leaveRoom", "room": id});
+}
+
+function renderRooms(newRooms) {
+    rooms = newRooms;
+    roomList.innerHTML = "";
+    directList.innerHTML = "";
+
+    let joined = rooms.filter((room) => room.joined);
+    if (pendingDirect === null && !joined.some((room) => room.id === currentRoom)) {
+        switchRoom(joined.length > 0 ? joined[0].id : null);
+    }
+
+    rooms.forEach((room) => {
+        let item = document.createElement("li");
+        item.setAttribute("class", "list-group-item d-flex justify-content-between align-items-center room");
+        item.dataset.room = room.id;
+
+        let name = document.createElement("span");
+        name.textContent = roomName(room);
+        item.appendChild(name);
+
+        let button = document.createElement("button");
+        button.setAttribute("class", "btn btn-sm btn-link p-0");
+        if (room.joined) {
+            if (room.id === currentRoom) {
+                item.classList.add("active");
+            }
+            name.onclick = () => switchRoom(room.id);
+            button.textContent = "leave";
+            button.onclick = () => leaveRoom(room.id);
+        } else {
+            item.classList.add("text-muted");
+            button.textContent = "join";
+            button.onclick = () => joinRoom(room.id);
+        }
+        item.appendChild(button);
+
+        if (room.direct) {
+            directList.appendChild(item);
+        } else {
+            roomList.appendChild(item);
+        }
+    });
+    if (pendingDirect === null) {
+        renderTitle();
+    }
+    renderUnread();
+}
+
+function roomName(room) {
+    return (room.direct ? "@" : "#") + room.name;
+}
+
+// renderTitle shows the name and the topic of the current room.
+function renderTitle() {
+    let room = rooms.find((room) => room.id === currentRoom);
+    roomTitle.textContent = room ? roomName(room) : "";
+    if (room && room.topic) {
+        let topic = document.createElement("small");
+        topic.setAttribute("class", "text-muted ms-2");
+        topic.textContent = room.topic;
+        roomTitle.appendChild(topic);
+    }
+}
+
+function switchRoom(id) {
+    if (id === currentRoom) {
+        return;
+    }
+
+    currentRoom = id;
+    pendingDirect = null;
+    reachedHistoryEnd = false;
+    loadingHistory = false;
+    oldestMessage = 0;
+    chat.innerHTML = "";
+    closeThread();
+    renderTyping();
+    renderSeen();
+
+    renderTitle();
+
+    document.querySelectorAll(".room").forEach((item) => {
+        item.classList.toggle("active", Number(item.dataset.room) === id);
+    });
+    unread[id] = 0;
+    renderUnread();
+
+    if (id !== null) {
+        loadMore();
+    }
+}
+
+function renderOnline() {
+    onlineList.innerHTML = "";
+    Array.from(onlineUsers).sort().forEach((username) => {
+        let item = document.createElement("li");
+        item.setAttribute("class", "list-group-item online-user");
+        item.textContent = username;
+        if (username !== clientUsername) {
+            item.onclick = () => openDirect(username);
+        }
+        onlineList.appendChild(item);
+    });
+}
+
+function renderTyping() {
+    let users = Array.from(typists[currentRoom] || []);
+    switch (users.length) {
+        case 0:
+            typingLine.textContent = "";
+            break;
+        case 1:
+            typingLine.textContent = users[0] + " is typing...";
+            break;
+        default:
+            typingLine.textContent = users.join(", ") + " are typing...";
+    }
+}
+
+function renderSeen() {
+    // the newest message is the first one
+    let newest = chat.querySelector("[data-id]");
+    let seen = readers[currentRoom] || {};
+    let users = newest === null ? [] : Object.keys(seen).filter((username) => seen[username] >= Number(newest.dataset.id));
+    seenLine.textContent = users.length > 0 ? "Seen by " + users.sort().join(", ") : "";
+}
+
+function markUnread(id) {
+    unread[id] = (unread[id] || 0) + 1;
+    renderUnread();
+}
+
+function markRead(id) {
+    if (connected() && document.visibilityState === "visible") {
+        sendRequest({"action": "read", "room": currentRoom, "messageId": id});
+    }
+}
+
+document.onvisibilitychange = function () {
+    let newest = chat.querySelector("[data-id]");
+    if (newest !== null) {
+        markRead(Number(newest.dataset.id));
+    }
+};
+
+function notifyMention(msg) {
+    document.querySelector("#mentions-link").classList.add("mentioned");
+    if (!("Notification" in window)) {
+        return;
+    }
+    if (Notification.permission === "granted") {
+        new Notification(msg.username + " mentioned you", {body: msg.text});
+    } else if (Notification.permission === "default") {
+        Notification.requestPermission();
+    }
+}
+
+function loadUnread() {
+    fetch("/rooms/unread")
+        .then((resp) => resp.json())
+        .then((counts) => {
+            unread = counts;
+            // the current room is being read
+            delete unread[currentRoom];
+            renderUnread();
+        });
+}
+
+function renderUnread() {
+    document.querySelectorAll(".room").forEach((item) => {
+        let count = unread[item.dataset.room] || 0;
+        item.classList.toggle("unread", count > 0);
+        item.querySelector("span").dataset.count = count;
+    });
+}
+
 function loadMore() {
-    if (!conn) {
+    if (!connected() || currentRoom === null || loadingHistory) {
         return;
     }
 
-    // try to load more messages
-    conn.send(JSON.stringify({
+    // try to load messages older than the oldest one we have
+    loadingHistory = true;
+    sendRequest({
         "action": "loadMore",
-        "offset": chat.children.length
-    }));
+        "room": currentRoom,
+        "before": oldestMessage
+    });
+}
+
+function editMessage(msg) {
+    let text = prompt("Edit message", msg.text);
+    if (!text) {
+        return;
+    }
+
+    sendRequest({"action": "edit", "messageId": msg.id, "message": text});
+}
+
+function deleteMessage(msg) {
+    if (!confirm("Delete message?")) {
+        return;
+    }
+
+    sendRequest({"action": "delete", "messageId": msg.id});
+}
+
+function createPending(req, files) {
+    let item = createMessage({
+        text: req.message,
+        html: escapeHTML(req.message),
+        username: clientUsername,
+        created: new Date(),
+        attachments: files
+    });
+    item.dataset.key = req.key;
+    item.classList.add("pending");
+    item.querySelector(".client-time").textContent = "sending...";
+
+    return item;
 }
 
-function createMessage(text, username, date) {
+function escapeHTML(text) {
+    let item = document.createElement("div");
+    item.textContent = text;
+    return item.innerHTML;
+}
+
+function createMessage(msg) {
     let messageItem = document.createElement("div");
+    // pending messages don't have an id yet
+    if (msg.id !== undefined) {
+        messageItem.dataset.id = msg.id;
+    }
     let textTimeWrapper = document.createElement("div")
     textTimeWrapper.setAttribute("class", "d-flex flex-wrap")
 
     let textItem = document.createElement("div");
-    textItem.innerHTML = text;
+    if (msg.deleted) {
+        textItem.innerHTML = "<i>Message deleted.</i>";
+    } else {
+        // the server renders Markdown to safe HTML
+        textItem.innerHTML = msg.html;
+    }
     textItem.setAttribute("class", "text")
 
     let timeItem = document.createElement("div");
-    timeItem.innerHTML = new Date(date).toLocaleTimeString(undefined, {hour: "2-digit", minute: "2-digit"});
+    timeItem.innerHTML = new Date(msg.created).toLocaleTimeString(undefined, {hour: "2-digit", minute: "2-digit"});
+    if (msg.edited && !msg.deleted) {
+        timeItem.innerHTML += " (edited)";
+    }
 
-    if (username !== clientUsername) {
+    if (msg.username !== clientUsername) {
         messageItem.setAttribute("class", "px-2 py-1 my-2 rounded-3 bg-primary message");
+        if (!msg.deleted && (msg.mentions || []).includes(clientUsername)) {
+            messageItem.classList.add("mention");
+        }
         timeItem.setAttribute("class", "time ps-2");
 
         let usernameItem = document.createElement("div");
-        usernameItem.innerHTML = username;
+        usernameItem.textContent = msg.nickname || msg.username;
+        if (msg.nickname) {
+            usernameItem.title = msg.username;
+        }
         usernameItem.setAttribute("class", "username")
+        if (msg.bot) {
+            let badge = document.createElement("span");
+            badge.textContent = "bot";
+            badge.setAttribute("class", "badge bg-info ms-1 bot");
+            usernameItem.appendChild(badge);
+        }
         messageItem.appendChild(usernameItem);
+
+        let room = rooms.find((room) => room.id === msg.room);
+        if (clientModerates && !msg.deleted && msg.id !== undefined && room && !room.direct) {
+            let actions = document.createElement("div");
+            actions.setAttribute("class", "actions");
+
+            let del = document.createElement("a");
+            del.textContent = "delete";
+            del.onclick = () => deleteMessage(msg);
+            actions.appendChild(del);
+
+            messageItem.appendChild(actions);
+        }
     } else {
         messageItem.setAttribute("class", "px-2 py-1 my-2 rounded-3 bg-secondary client-message");
         timeItem.setAttribute("class", "client-time ps-2");
+
+        if (!msg.deleted && msg.id !== undefined) {
+            let actions = document.createElement("div");
+            actions.setAttribute("class", "actions");
+
+            let edit = document.createElement("a");
+            edit.textContent = "edit";
+            edit.onclick = () => editMessage(msg);
+            actions.appendChild(edit);
+
+            let del = document.createElement("a");
+            del.textContent = "delete";
+            del.onclick = () => deleteMessage(msg);
+            actions.appendChild(del);
+
+            messageItem.appendChild(actions);
+        }
     }
 
     textTimeWrapper.appendChild(textItem);
     textTimeWrapper.appendChild(timeItem);
     messageItem.appendChild(textTimeWrapper)
 
+    if (!msg.deleted && msg.attachments && msg.attachments.length > 0) {
+        messageItem.appendChild(createAttachments(msg.attachments));
+    }
+
+    if (!msg.deleted && msg.previews && msg.previews.length > 0) {
+        messageItem.appendChild(createPreviews(msg.previews));
+    }
+
+    if (msg.id !== undefined && !msg.deleted) {
+        messageItem.appendChild(createReactions(msg));
+    }
+
+    // replies can't start threads of their own
+    if (msg.id !== undefined && !msg.parent && (!msg.deleted || msg.replies)) {
+        let threadLink = document.createElement("a");
+        threadLink.setAttribute("class", "thread-link small");
+        threadLink.dataset.replies = msg.replies || 0;
+        threadLink.textContent = repliesText(msg.replies);
+        threadLink.onclick = () => showThread(msg.id, msg);
+        messageItem.appendChild(threadLink);
+    }
+
     return messageItem;
 }
 
-function appendStart(text, username, date) {
+// createAttachments shows thumbnails of images linked to the full
+// images, and links to other files.
+function createAttachments(attachments) {
+    let list = document.createElement("div");
+    list.setAttribute("class", "attachments");
+
+    attachments.forEach((attachment) => {
+        let link = document.createElement("a");
+        link.href = attachment.url;
+        link.target = "_blank";
+        link.rel = "noopener";
+
+        if (attachment.thumbnail) {
+            let image = document.createElement("img");
+            image.src = attachment.thumbnail.url;
+            image.width = attachment.thumbnail.width;
+            image.height = attachment.thumbnail.height;
+            image.alt = attachment.filename;
+            image.loading = "lazy";
+            link.appendChild(image);
+        } else {
+            link.setAttribute("download", attachment.filename);
+            link.textContent = attachment.filename + " (" + formatSize(attachment.size) + ")";
+        }
+        list.appendChild(link);
+    });
+
+    return list;
+}
+
+// createPreviews shows titles, descriptions and images
+// of the pages linked from the message.
+function createPreviews(previews) {
+    let list = document.createElement("div");
+    list.setAttribute("class", "previews");
+
+    previews.forEach((preview) => {
+        let item = document.createElement("div");
+        item.setAttribute("class", "preview");
+
+        let title = document.createElement("a");
+        title.href = preview.url;
+        title.target = "_blank";
+        title.rel = "noopener nofollow";
+        title.textContent = preview.title;
+        item.appendChild(title);
+
+        if (preview.description) {
+            let description = document.createElement("p");
+            description.setAttribute("class", "small text-muted");
+            description.textContent = preview.description;
+            item.appendChild(description);
+        }
+        if (preview.image) {
+            let image = document.createElement("img");
+            image.src = preview.image;
+            image.alt = "";
+            image.loading = "lazy";
+            image.referrerPolicy = "no-referrer";
+            item.appendChild(image);
+        }
+        list.appendChild(item);
+    });
+
+    return list;
+}
+
+function formatSize(size) {
+    if (size < 1024) {
+        return size + " B";
+    }
+    if (size < 1024 * 1024) {
+        return (size / 1024).toFixed(1) + " KB";
+    }
+    return (size / 1024 / 1024).toFixed(1) + " MB";
+}
+
+// createReactions returns reactions to the message, clicking
+// on them adds or removes the reaction of the user.
+function createReactions(msg) {
+    let reactions = document.createElement("div");
+    reactions.setAttribute("class", "reactions");
+
+    (msg.reactions || []).forEach((reaction) => {
+        let reacted = reaction.users.includes(clientUsername);
+        let item = document.createElement("a");
+        item.setAttribute("class", "reaction" + (reacted ? " reacted" : ""));
+        item.textContent = reaction.emoji + " " + reaction.count;
+        item.title = reaction.users.join(", ");
+        item.onclick = () => react(msg.id, reaction.emoji, !reacted);
+        reactions.appendChild(item);
+    });
+
+    let add = document.createElement("a");
+    add.setAttribute("class", "reaction add-reaction");
+    add.textContent = "+";
+    add.onclick = () => {
+        let picker = document.createElement("span");
+        quickReactions.forEach((emoji) => {
+            let item = document.createElement("a");
+            item.setAttribute("class", "reaction");
+            item.textContent = emoji;
+            item.onclick = () => {
+                picker.replaceWith(add);
+                react(msg.id, emoji, true);
+            };
+            picker.appendChild(item);
+        });
+        add.replaceWith(picker);
+    };
+    reactions.appendChild(add);
+
+    return reactions;
+}
+
+function react(id, emoji, add) {
+    if (!connected()) {
+        return;
+    }
+    sendRequest({"action": add ? "addReaction" : "removeReaction", "messageId": id, "emoji": emoji});
+}
+
+function hasMessage(msg) {
+    return chat.querySelector('[data-id="' + msg.id + '"]') !== null;
+}
+
+function appendStart(msg) {
+    if (hasMessage(msg)) {
+        return;
+    }
+
     // insert after add to the top because our chat is column-reverse flexbox container
-    chat.append(createMessage(text, username, date));
+    chat.append(createMessage(msg));
+}
+
+function appendEnd(msg) {
+    if (hasMessage(msg)) {
+        return;
+    }
+
+    // replayed messages may arrive after newer live ones,
+    // so find the oldest message that is newer than this one
+    let newer = null;
+    for (let item of chat.children) {
+        if (item.dataset.id === undefined) {
+            continue;
+        }
+        if (Number(item.dataset.id) < msg.id) {
+            break;
+        }
+        newer = item;
+    }
+
+    if (newer === null) {
+        insertEnd(createMessage(msg));
+    } else {
+        // the first child is the newest message
+        newer.after(createMessage(msg));
+    }
+}
+
+// appendReply shows the reply to the command, which only we can see.
+function appendReply(text) {
+    let reply = document.createElement("div");
+    reply.setAttribute("class", "text-muted small my-2 reply");
+    reply.textContent = text;
+    insertEnd(reply);
+}
+
+function appendNotice(html) {
+    let notice = document.createElement("div");
+    notice.setAttribute("class", "text-center text-muted my-2");
+    notice.innerHTML = html;
+    insertEnd(notice);
 }
 
-function appendEnd(text, username, date) {
-    let message = createMessage(text, username, date);
+function insertEnd(item) {
     if (chat.children.length === 0) {
-        chat.append(message);
+        chat.append(item);
         return;
     }
 
     // insert before adds to the bottom because our chat is column-reverse flexbox container
-    chat.insertBefore(createMessage(text, username, date), chat.firstChild);
+    chat.insertBefore(item, chat.firstChild);
 }
@@ -1,18 +1,23 @@
 package server
 
 import (
+	"bytes"
 	"errors"
 	"html"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gorilla/sessions"
+	"github.com/lazy-void/chatapp/blob"
 	"github.com/lazy-void/chatapp/models/mock"
 )
 
@@ -28,12 +33,60 @@ func extractCSRFToken(body string) (string, error) {
 
 func newTestApp() *Application {
 	return &Application{
-		Sessions: sessions.NewCookieStore([]byte("946IpCV9y5Vlur8YvODJEhaOY8m9J1E4")),
-		Messages: &mock.MessageModel{},
-		Users:    &mock.UserModel{},
+		Sessions:         sessions.NewCookieStore([]byte("946IpCV9y5Vlur8YvODJEhaOY8m9J1E4")),
+		Messages:         &mock.MessageModel{},
+		Rooms:            &mock.RoomModel{},
+		Users:            &mock.UserModel{},
+		Attachments:      &mock.AttachmentModel{},
+		Webhooks:         &mock.WebhookModel{},
+		IncomingWebhooks: &mock.IncomingWebhookModel{},
+		Blobs: &memoryBlobs{blobs: map[string][]byte{
+			mock.AttachmentMock.Key:                 []byte("hello world"),
+			mock.PendingAttachmentMock.Key:          []byte("\x89PNG\r\n\x1a\n"),
+			mock.PendingAttachmentMock.ThumbnailKey: []byte("thumbnail"),
+		}},
 	}
 }
 
+// memoryBlobs keeps blobs in memory.
+type memoryBlobs struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func (s *memoryBlobs) Put(key string, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[key] = b
+
+	return nil
+}
+
+func (s *memoryBlobs) Get(key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.blobs[key]
+	if !ok {
+		return nil, blob.ErrNotFound
+	}
+
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *memoryBlobs) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, key)
+
+	return nil
+}
+
 type testServer struct {
 	*httptest.Server
 }
@@ -85,7 +138,75 @@ func (ts testServer) post(t *testing.T, path string, form url.Values) (int, http
 	return resp.StatusCode, resp.Header, string(body)
 }
 
+// upload posts the file as a multipart form with the csrf token.
+func (ts testServer) upload(t *testing.T, path, filename string, contents []byte, csrfToken string) (int, http.Header, string) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	err := w.WriteField("csrf_token", csrfToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filename != "" {
+		part, err := w.CreateFormFile("file", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = part.Write(contents)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := ts.Client().Post(ts.URL+path, w.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, resp.Header, string(respBody)
+}
+
+// postJSON posts the body as JSON on behalf of the bot with the token.
+func (ts testServer) postJSON(t *testing.T, path, token, body string) (int, http.Header, string) {
+	req, err := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, resp.Header, string(respBody)
+}
+
 func (ts testServer) authenticate(t *testing.T) {
+	ts.authenticateAs(t, mock.UserMock.Email)
+}
+
+// authenticateAs logs in as the user with the email.
+func (ts testServer) authenticateAs(t *testing.T, email string) {
 	_, _, body := ts.get(t, "/user/login")
 	csrfToken, err := extractCSRFToken(body)
 	if err != nil {
@@ -93,7 +214,7 @@ func (ts testServer) authenticate(t *testing.T) {
 	}
 
 	form := url.Values{}
-	form.Add("email", mock.UserMock.Email)
+	form.Add("email", email)
 	form.Add("password", mock.ValidPassword)
 	form.Add("csrf_token", csrfToken)
 
@@ -115,3 +236,15 @@ func (ts testServer) authenticate(t *testing.T) {
 	}})
 
 }
+
+// csrfToken collects csrf token and cookie from the chat page.
+// Test server must be authenticated.
+func (ts testServer) csrfToken(t *testing.T) string {
+	_, _, body := ts.get(t, "/")
+	csrfToken, err := extractCSRFToken(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return csrfToken
+}
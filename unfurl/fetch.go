@@ -0,0 +1,175 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/lazy-void/chatapp/models"
+)
+
+const (
+	// Time allowed to load the page.
+	fetchTimeout = 5 * time.Second
+
+	// Only the beginning of the page is read,
+	// metadata is expected in its head.
+	pageMaxSize = 512 << 10
+
+	// Maximum number of redirects followed.
+	redirectsMaxCount = 5
+
+	userAgent = "ChatApp link preview"
+)
+
+// HTTPFetcher loads pages over HTTP and parses their metadata.
+type HTTPFetcher struct {
+	Client *http.Client
+
+	// Maximum number of bytes read from the page.
+	MaxSize int64
+}
+
+// NewHTTPFetcher returns the fetcher that connects only to public
+// addresses, so that links to the internal network can't be used
+// to reach it from the server. Addresses are checked after they are
+// resolved, so DNS names pointing to private addresses are refused too.
+func NewHTTPFetcher() *HTTPFetcher {
+	dialer := &net.Dialer{
+		Timeout: fetchTimeout,
+		Control: checkAddress,
+	}
+
+	return &HTTPFetcher{
+		Client: &http.Client{
+			Timeout: fetchTimeout,
+			Transport: &http.Transport{
+				// a proxy would connect to private addresses on our behalf
+				Proxy:                  nil,
+				DialContext:            dialer.DialContext,
+				TLSHandshakeTimeout:    fetchTimeout,
+				ResponseHeaderTimeout:  fetchTimeout,
+				MaxResponseHeaderBytes: 64 << 10,
+				MaxIdleConns:           10,
+				IdleConnTimeout:        30 * time.Second,
+			},
+			CheckRedirect: checkRedirect,
+		},
+		MaxSize: pageMaxSize,
+	}
+}
+
+// Fetch loads the HTML page at the URL and returns its metadata.
+func (f *HTTPFetcher) Fetch(ctx context.Context, url string) (models.Preview, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return models.Preview{}, err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return models.Preview{}, ErrNoPreview
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return models.Preview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.Preview{}, fmt.Errorf("unfurl: unexpected status %d", resp.StatusCode)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return models.Preview{}, ErrNoPreview
+	}
+
+	page, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxSize))
+	if err != nil {
+		return models.Preview{}, err
+	}
+
+	// relative image URLs are resolved against the final URL after redirects
+	return parsePage(resp.Request.URL, page), nil
+}
+
+// checkAddress refuses connections to loopback, private, link-local
+// and other addresses that are not reachable from the Internet.
+func checkAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublic(ip) {
+		return ErrForbiddenAddress
+	}
+
+	return nil
+}
+
+// Ranges of addresses that are not public, in addition
+// to the ones recognized by the net package.
+var reservedNets = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+	"100::/64",
+	"2001:db8::/32",
+	"fc00::/7",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+
+	return nets
+}
+
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= redirectsMaxCount {
+		return errors.New("unfurl: too many redirects")
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return ErrNoPreview
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPage = `<!DOCTYPE html>
+<html>
+<head>
+	<title>Fallback title</title>
+	<meta charset="utf-8">
+	<meta property="og:title" content="Tom &amp; Jerry">
+	<meta content='Cat   and
+		mouse' property='og:description'>
+	<meta property="og:image" content="/images/cover.png">
+</head>
+<body></body>
+</html>`
+
+func TestHTTPFetcher_Fetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/og", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, userAgent, r.UserAgent())
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(testPage))
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<title>Plain page</title><meta name="description" content="No OpenGraph">`))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/og", http.StatusFound)
+	})
+	mux.HandleFunc("/huge", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(strings.Repeat(" ", 1024) + testPage))
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// the test server listens on the loopback interface,
+	// so the client is not protected
+	f := &HTTPFetcher{Client: ts.Client(), MaxSize: 1024}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    models.Preview
+		wantErr bool
+	}{
+		{"OpenGraph", "/og", models.Preview{Title: "Tom & Jerry", Description: "Cat and mouse", Image: ts.URL + "/images/cover.png"}, false},
+		{"Document title", "/plain", models.Preview{Title: "Plain page", Description: "No OpenGraph"}, false},
+		{"Redirect", "/redirect", models.Preview{Title: "Tom & Jerry", Description: "Cat and mouse", Image: ts.URL + "/images/cover.png"}, false},
+		{"Metadata after the limit", "/huge", models.Preview{}, false},
+		{"Not HTML", "/image", models.Preview{}, true},
+		{"Not found", "/missing", models.Preview{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := f.Fetch(context.Background(), ts.URL+tt.path)
+
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.want, p)
+		})
+	}
+}
+
+func TestHTTPFetcher_PrivateAddresses(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("private address is reached")
+	}))
+	defer ts.Close()
+
+	f := NewHTTPFetcher()
+	_, err := f.Fetch(context.Background(), ts.URL)
+	assert.True(t, errors.Is(err, ErrForbiddenAddress), err)
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.20.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.100.100.200", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.want, isPublic(net.ParseIP(tt.ip)))
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package unfurl
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/lazy-void/chatapp/models"
+)
+
+var (
+	metaTagRx   = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attributeRx = regexp.MustCompile(`(?s)([a-zA-Z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titleTagRx  = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	spacesRx    = regexp.MustCompile(`\s+`)
+)
+
+// parsePage returns metadata of the HTML page at the URL. OpenGraph
+// properties are preferred, the title and description of the document
+// are used if the page has none.
+func parsePage(pageURL *url.URL, page []byte) models.Preview {
+	src := strings.ToValidUTF8(string(page), "")
+
+	meta := make(map[string]string)
+	for _, tag := range metaTagRx.FindAllString(src, -1) {
+		attrs := make(map[string]string)
+		for _, m := range attributeRx.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(m[1])] = m[2] + m[3] + m[4]
+		}
+
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		if _, ok := meta[key]; !ok && key != "" {
+			meta[key] = clean(attrs["content"])
+		}
+	}
+
+	p := models.Preview{
+		Title:       meta["og:title"],
+		Description: meta["og:description"],
+	}
+	if p.Title == "" {
+		if m := titleTagRx.FindStringSubmatch(src); m != nil {
+			p.Title = clean(m[1])
+		}
+	}
+	if p.Description == "" {
+		p.Description = meta["description"]
+	}
+
+	for _, key := range []string{"og:image:secure_url", "og:image", "og:image:url"} {
+		if image := resolve(pageURL, meta[key]); image != "" {
+			p.Image = image
+			break
+		}
+	}
+
+	return p
+}
+
+// clean decodes entities of the text and collapses spaces.
+func clean(text string) string {
+	return strings.TrimSpace(spacesRx.ReplaceAllString(html.UnescapeString(text), " "))
+}
+
+// resolve returns the absolute http or https URL of the reference
+// from the page, or an empty string if there is none.
+func resolve(pageURL *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+
+	u, err := pageURL.Parse(ref)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return ""
+	}
+
+	return u.String()
+}
@@ -0,0 +1,118 @@
+// Package unfurl loads previews of the pages linked from messages:
+// their OpenGraph titles, descriptions and images.
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lazy-void/chatapp/models"
+)
+
+// Notable errors.
+var (
+	ErrNoPreview        = errors.New("unfurl: page has no preview")
+	ErrForbiddenAddress = errors.New("unfurl: address is not public")
+)
+
+// Fetcher loads metadata of the page at the URL. The preview
+// may have no title if the page doesn't describe itself.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) (models.Preview, error)
+}
+
+// Cache keeps loaded previews. Get returns models.ErrNoRecord
+// if there is no preview of the URL.
+type Cache interface {
+	Get(url string) (models.Preview, error)
+	Save(p models.Preview) error
+}
+
+// Time after which previews are loaded again. Pages that had no preview
+// are tried again sooner, since they may have been unavailable.
+const (
+	cacheTTL   = 24 * time.Hour
+	missingTTL = time.Hour
+)
+
+// Limits of the preview fields in characters.
+const (
+	urlMaxLength         = 2048
+	titleMaxLength       = 300
+	descriptionMaxLength = 1000
+)
+
+// Unfurler loads previews by the Fetcher and caches them.
+// Pages without previews are cached too, so that they are
+// not loaded again every time somebody links to them.
+type Unfurler struct {
+	fetcher Fetcher
+	cache   Cache
+}
+
+// New returns an Unfurler loading the previews by the fetcher.
+func New(fetcher Fetcher, cache Cache) *Unfurler {
+	return &Unfurler{fetcher: fetcher, cache: cache}
+}
+
+// Unfurl returns the preview of the page at the URL
+// or ErrNoPreview if the page has none.
+func (u *Unfurler) Unfurl(ctx context.Context, url string) (models.Preview, error) {
+	if len(url) > urlMaxLength {
+		return models.Preview{}, ErrNoPreview
+	}
+
+	p, err := u.cache.Get(url)
+	switch {
+	case errors.Is(err, models.ErrNoRecord):
+	case err != nil:
+		return models.Preview{}, err
+	case p.Title != "" && time.Since(p.Fetched) < cacheTTL,
+		p.Title == "" && time.Since(p.Fetched) < missingTTL:
+		return found(p)
+	}
+
+	p, err = u.fetcher.Fetch(ctx, url)
+	if ctx.Err() != nil {
+		// the page is not to blame
+		return models.Preview{}, ctx.Err()
+	}
+	if err != nil {
+		// the error is cached as a page without preview
+		p = models.Preview{}
+	}
+	p.URL = url
+	p.Title = truncate(p.Title, titleMaxLength)
+	p.Description = truncate(p.Description, descriptionMaxLength)
+	if len(p.Image) > urlMaxLength {
+		p.Image = ""
+	}
+	p.Fetched = time.Now().UTC()
+
+	err = u.cache.Save(p)
+	if err != nil {
+		return models.Preview{}, err
+	}
+
+	return found(p)
+}
+
+func found(p models.Preview) (models.Preview, error) {
+	if p.Title == "" {
+		return models.Preview{}, ErrNoPreview
+	}
+	return p, nil
+}
+
+// truncate cuts the text to n characters.
+func truncate(text string, n int) string {
+	i := 0
+	for pos := range text {
+		if i == n {
+			return text[:pos]
+		}
+		i++
+	}
+	return text
+}
@@ -0,0 +1,98 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeFetcher returns the previews by URLs and counts requests.
+type fakeFetcher struct {
+	mu       sync.Mutex
+	previews map[string]models.Preview
+	fetched  int
+}
+
+func (f *fakeFetcher) Fetch(_ context.Context, url string) (models.Preview, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.fetched++
+	p, ok := f.previews[url]
+	if !ok {
+		return models.Preview{}, errors.New("not found")
+	}
+
+	return p, nil
+}
+
+// memoryCache keeps previews in memory.
+type memoryCache map[string]models.Preview
+
+func (c memoryCache) Get(url string) (models.Preview, error) {
+	p, ok := c[url]
+	if !ok {
+		return models.Preview{}, models.ErrNoRecord
+	}
+	return p, nil
+}
+
+func (c memoryCache) Save(p models.Preview) error {
+	c[p.URL] = p
+	return nil
+}
+
+func TestUnfurler_Unfurl(t *testing.T) {
+	fetcher := &fakeFetcher{previews: map[string]models.Preview{
+		"https://example.com": {Title: "Example", Description: "Example domain"},
+	}}
+	cache := memoryCache{}
+	u := New(fetcher, cache)
+
+	for i := 0; i < 2; i++ {
+		p, err := u.Unfurl(context.Background(), "https://example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com", p.URL)
+		assert.Equal(t, "Example", p.Title)
+	}
+	// the second time the preview is cached
+	assert.Equal(t, 1, fetcher.fetched)
+
+	for i := 0; i < 2; i++ {
+		_, err := u.Unfurl(context.Background(), "https://example.com/missing")
+		assert.Equal(t, ErrNoPreview, err)
+	}
+	// failures are cached too
+	assert.Equal(t, 2, fetcher.fetched)
+
+	// stale previews are loaded again
+	p := cache["https://example.com"]
+	p.Fetched = time.Now().Add(-cacheTTL)
+	cache[p.URL] = p
+
+	_, err := u.Unfurl(context.Background(), "https://example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, fetcher.fetched)
+}
+
+func TestUnfurler_Truncate(t *testing.T) {
+	long := strings.Repeat("a", urlMaxLength)
+	fetcher := &fakeFetcher{previews: map[string]models.Preview{
+		"https://example.com": {Title: "Ω" + long, Description: long, Image: "https://example.com/" + long},
+	}}
+	u := New(fetcher, memoryCache{})
+
+	p, err := u.Unfurl(context.Background(), "https://example.com")
+	if assert.NoError(t, err) {
+		assert.Equal(t, titleMaxLength, len([]rune(p.Title)))
+		assert.Equal(t, descriptionMaxLength, len([]rune(p.Description)))
+		assert.Empty(t, p.Image)
+	}
+}
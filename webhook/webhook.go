@@ -0,0 +1,267 @@
+// Package webhook delivers new messages to the URLs registered by admins
+// as signed JSON POST requests. Deliveries are queued in the storage
+// along with the messages and retried with exponential backoff until
+// the receiver accepts them.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lazy-void/chatapp/chat"
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Headers of the webhook requests.
+const (
+	// SignatureHeader carries "sha256=" followed by the hex-encoded
+	// HMAC-SHA256 of the body keyed by the secret of the webhook.
+	SignatureHeader = "X-Chatapp-Signature"
+
+	// DeliveryHeader carries the id of the delivery, which stays
+	// the same when the delivery is retried.
+	DeliveryHeader = "X-Chatapp-Delivery"
+)
+
+// MessageEvent is the event of the payloads sent when a message is stored.
+const MessageEvent = "message"
+
+const (
+	// Number of attempts after which the delivery fails
+	// and is no longer retried.
+	attemptsMaxCount = 10
+
+	// Time before the first retry, which doubles after every attempt.
+	backoffBase = 10 * time.Second
+
+	// Maximum time between the attempts.
+	backoffMax = 6 * time.Hour
+
+	// How often the queue is checked for due deliveries.
+	pollInterval = time.Second
+
+	// Number of deliveries claimed from the queue at once.
+	batchSize = 20
+
+	// Time allowed to deliver the payload.
+	deliveryTimeout = 10 * time.Second
+
+	// Maximum length of the error saved after the failed attempt in bytes.
+	errorMaxLength = 500
+)
+
+// Queue keeps the deliveries until they are delivered.
+type Queue interface {
+	Claim(now, lease time.Time, n int) ([]models.Delivery, error)
+	Delivered(id int64) error
+	Retry(id int64, lastError string, next time.Time, failed bool) error
+}
+
+// MessageInterface loads the messages sent in the payloads.
+type MessageInterface interface {
+	Get(id int64) (models.Message, error)
+}
+
+// Payload is the body of the webhook request.
+type Payload struct {
+	Event   string       `json:"event"`
+	Message chat.Message `json:"message"`
+}
+
+// Dispatcher delivers the queued messages to the webhooks. Several
+// instances can share the queue, every delivery is claimed by one
+// of them at a time.
+type Dispatcher struct {
+	queue    Queue
+	messages MessageInterface
+	client   *http.Client
+
+	pollInterval time.Duration
+	backoffBase  time.Duration
+}
+
+// New returns a Dispatcher delivering the messages from the queue.
+func New(queue Queue, messages MessageInterface) *Dispatcher {
+	return &Dispatcher{
+		queue:        queue,
+		messages:     messages,
+		client:       &http.Client{Timeout: deliveryTimeout},
+		pollInterval: pollInterval,
+		backoffBase:  backoffBase,
+	}
+}
+
+// Run delivers the due payloads until the context is canceled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if d.deliverDue(ctx) == batchSize && ctx.Err() == nil {
+			// more deliveries may be due
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverDue claims the due deliveries and attempts them at once.
+// It returns the number of claimed deliveries.
+func (d *Dispatcher) deliverDue(ctx context.Context) int {
+	now := time.Now().UTC()
+	// the deliveries are claimed until they are surely attempted
+	deliveries, err := d.queue.Claim(now, now.Add(2*deliveryTimeout), batchSize)
+	if err != nil {
+		log.Err(err).Msg("error claiming webhook deliveries")
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery models.Delivery) {
+			defer wg.Done()
+			d.attempt(ctx, delivery)
+		}(delivery)
+	}
+	wg.Wait()
+
+	return len(deliveries)
+}
+
+// attempt delivers the payload and removes it from the queue,
+// or schedules the next attempt if the delivery has failed.
+func (d *Dispatcher) attempt(ctx context.Context, delivery models.Delivery) {
+	err := d.post(ctx, delivery)
+	if ctx.Err() != nil {
+		// the delivery will be claimed again when the lease expires
+		return
+	}
+
+	if err == nil {
+		err = d.queue.Delivered(delivery.ID)
+		if err != nil {
+			log.Err(err).Int64("delivery", delivery.ID).Msg("error removing delivered webhook payload")
+		}
+		return
+	}
+
+	attempts := delivery.Attempts + 1
+	failed := attempts >= attemptsMaxCount
+	next := time.Now().UTC().Add(d.backoff(attempts))
+	log.Warn().Err(err).Int64("delivery", delivery.ID).Int("attempts", attempts).Bool("failed", failed).
+		Msg("error delivering webhook payload")
+
+	err = d.queue.Retry(delivery.ID, truncate(err.Error(), errorMaxLength), next, failed)
+	if err != nil {
+		log.Err(err).Int64("delivery", delivery.ID).Msg("error scheduling webhook delivery")
+	}
+}
+
+// post sends the payload with the message to the webhook. The message
+// is loaded as it is at the time of the attempt. Any response
+// other than 2xx is an error.
+func (d *Dispatcher) post(ctx context.Context, delivery models.Delivery) error {
+	m, err := d.messages.Get(delivery.MessageID)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(Payload{Event: MessageEvent, Message: chat.NewMessage(m)})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chatapp-webhook")
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// let the connection be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook: unexpected response status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// backoff returns the time to wait before the next attempt
+// after the number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.backoffBase
+	for i := 1; i < attempts && wait < backoffMax; i++ {
+		wait *= 2
+	}
+	if wait > backoffMax {
+		wait = backoffMax
+	}
+
+	return wait
+}
+
+// Sign returns the value of the SignatureHeader for the payload.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether the signature of the payload
+// is made with the secret. Receivers can use it to check
+// that the requests come from the chat.
+func Verify(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, payload)), []byte(signature))
+}
+
+// NewSecret returns a random secret for signing the payloads.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// truncate cuts the text to n bytes at most
+// without splitting characters.
+func truncate(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
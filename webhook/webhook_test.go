@@ -0,0 +1,274 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lazy-void/chatapp/chat"
+	"github.com/lazy-void/chatapp/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryQueue keeps deliveries to the single webhook and their messages
+// in memory. Queueing along with the messages is tested against the SQL.
+type memoryQueue struct {
+	mu         sync.Mutex
+	webhook    models.Webhook
+	messages   map[int64]models.Message
+	deliveries []models.Delivery
+	delivered  []int64
+}
+
+// add stores the message and queues it for delivery.
+func (q *memoryQueue) add(m models.Message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.messages == nil {
+		q.messages = make(map[int64]models.Message)
+	}
+	q.messages[m.ID] = m
+	q.deliveries = append(q.deliveries, models.Delivery{
+		ID:          int64(len(q.deliveries) + len(q.delivered) + 1),
+		WebhookID:   q.webhook.ID,
+		URL:         q.webhook.URL,
+		Secret:      q.webhook.Secret,
+		MessageID:   m.ID,
+		NextAttempt: time.Now().UTC(),
+	})
+}
+
+func (q *memoryQueue) Get(id int64) (models.Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	m, ok := q.messages[id]
+	if !ok {
+		return models.Message{}, models.ErrNoRecord
+	}
+
+	return m, nil
+}
+
+func (q *memoryQueue) Claim(now, lease time.Time, n int) ([]models.Delivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var due []models.Delivery
+	for i, d := range q.deliveries {
+		if len(due) == n {
+			break
+		}
+		if !d.Failed && !d.NextAttempt.After(now) {
+			q.deliveries[i].NextAttempt = lease
+			due = append(due, d)
+		}
+	}
+
+	return due, nil
+}
+
+func (q *memoryQueue) Delivered(id int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, d := range q.deliveries {
+		if d.ID == id {
+			q.deliveries = append(q.deliveries[:i], q.deliveries[i+1:]...)
+			q.delivered = append(q.delivered, id)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (q *memoryQueue) Retry(id int64, lastError string, next time.Time, failed bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, d := range q.deliveries {
+		if d.ID == id {
+			q.deliveries[i].Attempts++
+			q.deliveries[i].LastError = lastError
+			q.deliveries[i].NextAttempt = next
+			q.deliveries[i].Failed = failed
+		}
+	}
+
+	return nil
+}
+
+// receiver records requests and responds with the status
+// codes from the list, the last one is repeated.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+
+	status := rc.statuses[0]
+	if len(rc.statuses) > 1 {
+		rc.statuses = rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newTestDispatcher(t *testing.T, statuses ...int) (*Dispatcher, *memoryQueue, *receiver) {
+	rc := &receiver{statuses: statuses}
+	ts := httptest.NewServer(rc)
+	t.Cleanup(ts.Close)
+
+	queue := &memoryQueue{webhook: models.Webhook{ID: 1, URL: ts.URL, Secret: "secret"}}
+	d := New(queue, queue)
+	d.backoffBase = 0
+
+	return d, queue, rc
+}
+
+func TestDispatcher_Deliver(t *testing.T) {
+	d, queue, rc := newTestDispatcher(t, http.StatusOK)
+
+	msg := models.Message{ID: 1, RoomID: models.DefaultRoomID, Text: "deployed", Username: "alice"}
+	queue.add(msg)
+
+	assert.Equal(t, 1, d.deliverDue(context.Background()))
+	assert.Equal(t, []int64{1}, queue.delivered)
+	assert.Empty(t, queue.deliveries)
+
+	if assert.Len(t, rc.requests, 1) {
+		r := rc.requests[0]
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "1", r.Header.Get(DeliveryHeader))
+		assert.True(t, Verify("secret", rc.bodies[0], r.Header.Get(SignatureHeader)))
+		assert.False(t, Verify("other", rc.bodies[0], r.Header.Get(SignatureHeader)))
+
+		var p Payload
+		err := json.Unmarshal(rc.bodies[0], &p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, Payload{Event: MessageEvent, Message: chat.NewMessage(msg)}, p)
+	}
+
+	// nothing is delivered twice
+	assert.Equal(t, 0, d.deliverDue(context.Background()))
+}
+
+func TestDispatcher_Retry(t *testing.T) {
+	d, queue, rc := newTestDispatcher(t, http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK)
+
+	queue.add(models.Message{ID: 1, RoomID: models.DefaultRoomID, Text: "deployed"})
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, 1, d.deliverDue(context.Background()))
+	}
+	if assert.Len(t, queue.deliveries, 1) {
+		assert.Equal(t, 2, queue.deliveries[0].Attempts)
+		assert.Contains(t, queue.deliveries[0].LastError, "500")
+		assert.False(t, queue.deliveries[0].Failed)
+	}
+
+	assert.Equal(t, 1, d.deliverDue(context.Background()))
+	assert.Equal(t, []int64{1}, queue.delivered)
+
+	// retries are the same delivery
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for i := range rc.requests {
+		assert.Equal(t, "1", rc.requests[i].Header.Get(DeliveryHeader))
+		assert.Equal(t, rc.bodies[0], rc.bodies[i])
+	}
+}
+
+func TestDispatcher_Fail(t *testing.T) {
+	d, queue, rc := newTestDispatcher(t, http.StatusBadGateway)
+
+	queue.add(models.Message{ID: 1, RoomID: models.DefaultRoomID, Text: "deployed"})
+
+	for i := 0; i < attemptsMaxCount; i++ {
+		assert.Equal(t, 1, d.deliverDue(context.Background()))
+	}
+	if assert.Len(t, queue.deliveries, 1) {
+		assert.True(t, queue.deliveries[0].Failed)
+		assert.Equal(t, attemptsMaxCount, queue.deliveries[0].Attempts)
+	}
+
+	// failed deliveries are not retried
+	assert.Equal(t, 0, d.deliverDue(context.Background()))
+	assert.Len(t, rc.requests, attemptsMaxCount)
+}
+
+func TestDispatcher_MissingMessage(t *testing.T) {
+	d, queue, rc := newTestDispatcher(t, http.StatusOK)
+
+	queue.add(models.Message{ID: 1, RoomID: models.DefaultRoomID, Text: "deployed"})
+	delete(queue.messages, 1)
+
+	// the delivery is retried as the message can't be loaded
+	assert.Equal(t, 1, d.deliverDue(context.Background()))
+	if assert.Len(t, queue.deliveries, 1) {
+		assert.Equal(t, 1, queue.deliveries[0].Attempts)
+		assert.Equal(t, models.ErrNoRecord.Error(), queue.deliveries[0].LastError)
+	}
+	assert.Empty(t, rc.requests)
+}
+
+func TestDispatcher_Backoff(t *testing.T) {
+	queue := &memoryQueue{}
+	d := New(queue, queue)
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, backoffBase},
+		{2, 2 * backoffBase},
+		{4, 8 * backoffBase},
+		{100, backoffMax},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, d.backoff(tt.attempts))
+	}
+}
+
+func TestDispatcher_Run(t *testing.T) {
+	d, queue, _ := newTestDispatcher(t, http.StatusOK)
+	d.pollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+
+	queue.add(models.Message{ID: 1, RoomID: models.DefaultRoomID, Text: "deployed"})
+
+	assert.Eventually(t, func() bool {
+		queue.mu.Lock()
+		defer queue.mu.Unlock()
+		return len(queue.delivered) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}